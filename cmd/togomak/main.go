@@ -11,11 +11,14 @@ import (
 	"github.com/srevinsaju/togomak/v1/internal/global"
 	"github.com/srevinsaju/togomak/v1/internal/logging"
 	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"github.com/srevinsaju/togomak/v1/internal/metrics"
 	"github.com/srevinsaju/togomak/v1/internal/orchestra"
 	"github.com/srevinsaju/togomak/v1/internal/path"
 	"github.com/srevinsaju/togomak/v1/internal/rules"
+	"github.com/srevinsaju/togomak/v1/internal/trace"
 	"github.com/urfave/cli/v2"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -47,6 +50,91 @@ func main() {
 			Usage:  "run a pipeline",
 			Action: run,
 		},
+		{
+			Name:      "resume",
+			Usage:     "resume a previous run, only running what did not succeed",
+			ArgsUsage: "[run-id]",
+			Action:    resume,
+		},
+		{
+			Name:      "watch",
+			Usage:     "run a pipeline, and run the stages again when the files they watch change",
+			ArgsUsage: "[filters...]",
+			Action:    watch,
+		},
+		{
+			Name:      "profile",
+			Usage:     "show where the time of a previous run was spent, and its critical path",
+			ArgsUsage: "[run-id]",
+			Action:    profile,
+		},
+		{
+			Name:      "logs",
+			Usage:     "print the output of the stages of a previous run",
+			ArgsUsage: "[runnables...]",
+			Action:    logs,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "run",
+					Usage: "identifier of the run, the latest run when it is not set",
+				},
+				&cli.BoolFlag{
+					Name:    "follow",
+					Usage:   "keep printing the output of the run until it finishes",
+					Aliases: []string{"f"},
+				},
+			},
+		},
+		{
+			Name:      "graph",
+			Usage:     "print the dependency graph of the pipeline, highlighting the stages and modules the filters select",
+			ArgsUsage: "[filters...]",
+			Action:    graph,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "format",
+					Usage:   "format of the graph, one of dot, mermaid or json",
+					Aliases: []string{"f"},
+					Value:   "dot",
+				},
+				&cli.BoolFlag{
+					Name:  "hide-internal",
+					Usage: "hide togomak.root, togomak.pre and togomak.post when they are not defined, and the locals",
+				},
+				&cli.BoolFlag{
+					Name:  "group-by-phase",
+					Usage: "group the stages and modules by their lifecycle phases",
+				},
+			},
+		},
+		{
+			Name:      "plan",
+			Usage:     "show which stages and modules would be run, and why, without running them",
+			ArgsUsage: "[filters...]",
+			Action:    plan,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "format",
+					Usage:   "format of the plan, one of text or json",
+					Aliases: []string{"f"},
+					Value:   "text",
+				},
+			},
+		},
+		{
+			Name:      "explain",
+			Usage:     "explain how the filters, the phases and the queries decide if a stage or a module is run",
+			ArgsUsage: "<runnable> [filters...]",
+			Action:    explain,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "format",
+					Usage:   "format of the explanation, one of text or json",
+					Aliases: []string{"f"},
+					Value:   "text",
+				},
+			},
+		},
 		{
 			Name:    "list",
 			Usage:   "list all the pipelines",
@@ -124,6 +212,13 @@ func main() {
 			EnvVars: []string{"CI", "TOGOMAK_CI"},
 			Value:   false,
 		},
+		&cli.StringFlag{
+			Name: "output",
+			Usage: "how the output of the stages is printed, one of stream, grouped or prefix. " +
+				"stream logs each line as it is written, grouped prints the output of each stage as a single block once it has finished, " +
+				"and prefix prints each line after the aligned identifier of its stage. The output is grouped by default in CI mode",
+			EnvVars: []string{"TOGOMAK_OUTPUT"},
+		},
 		&cli.StringFlag{
 			Name:    "dir",
 			Aliases: []string{"C", "directory"},
@@ -140,7 +235,24 @@ func main() {
 			Aliases: []string{"disable-parallel"},
 			Usage:   "disable concurrency",
 		},
+		&cli.BoolFlag{
+			Name:    "keep-going",
+			Aliases: []string{"k"},
+			Usage:   "continue running the stages which do not depend on a failed stage, and report all failures at the end",
+			EnvVars: []string{"TOGOMAK_KEEP_GOING"},
+		},
+		&cli.IntFlag{
+			Name:    "concurrency",
+			Aliases: []string{"max-parallel"},
+			Usage:   "maximum number of stages to run at the same time, 0 for no limit",
+			EnvVars: []string{"TOGOMAK_CONCURRENCY"},
+		},
 		&cli.BoolFlag{Name: "json", Usage: "enable json logging", EnvVars: []string{"TOGOMAK_JSON_LOG"}},
+		&cli.BoolFlag{
+			Name:    "tui",
+			Usage:   "show a live dashboard of the stages and their logs, instead of the logs, on a terminal",
+			EnvVars: []string{"TOGOMAK_TUI"},
+		},
 		&cli.BoolFlag{
 			Name:    "dry-run",
 			Aliases: []string{"n", "just-print", "recon"},
@@ -152,13 +264,23 @@ func main() {
 			Aliases: []string{"q"},
 			Usage:   "filter the pipeline by a query",
 		},
-		&cli.StringSliceFlag{
-			Name: "var",
+		&cli.GenericFlag{
+			Name:  "var",
+			Value: &variableFlags{},
 			Usage: "set a variable in the pipeline. " + "The format is <key>=<value>. " +
+				"The value is a HCL literal, such as [\"a\", \"b\"], when the type of the variable is a list, a map or an object. " +
 				"Multiple variables can be set by passing the flag multiple times. " +
-				"Variables set this way take precedence over variables set in the pipeline file.",
+				"Variables set this way take precedence over TOGOMAK_VAR_<key> environment variables, " +
+				"variables set in variable files and the pipeline file.",
 			Aliases: []string{"variable"},
 		},
+		&cli.StringSliceFlag{
+			Name: "var-file",
+			Usage: "set the variables in the pipeline from a HCL file, or a JSON file ending with .json. " +
+				"Multiple files can be set by passing the flag multiple times, the later files take precedence. " +
+				"Files ending with " + meta.AutoVarFileSuffix + " or " + meta.AutoVarFileJSONSuffix + " next to the pipeline are loaded " +
+				"before the variable files set this way. TOGOMAK_VAR_<key> environment variables take precedence over both.",
+		},
 		&cli.BoolFlag{
 			Name:    "logging.remote.google-cloud",
 			Usage:   "Enable remote logging to Google Cloud",
@@ -182,6 +304,52 @@ func main() {
 			EnvVars: []string{"TOGOMAK_LOGGING_LOCAL_FILE_PATH"},
 			Value:   "togomak.log",
 		},
+		&cli.IntFlag{
+			Name:    "logging.local.runs",
+			Usage:   "Number of the latest runs whose output is kept in .togomak/logs, with a file for each stage, 0 to disable. The state of the runs in .togomak/runs is pruned the same way",
+			EnvVars: []string{"TOGOMAK_LOGGING_LOCAL_RUNS"},
+			Value:   10,
+		},
+		&cli.StringFlag{
+			Name:    "report",
+			Usage:   "Path to a file where a JSON report of the run is written",
+			EnvVars: []string{"TOGOMAK_REPORT"},
+		},
+		&cli.StringFlag{
+			Name:    "junit",
+			Usage:   "Path to a file where a JUnit XML report of the stages and modules is written",
+			EnvVars: []string{"TOGOMAK_JUNIT"},
+		},
+		&cli.StringFlag{
+			Name:    "trace",
+			Usage:   "Path to a file where a trace of the run is written, in the Chrome trace event format",
+			EnvVars: []string{"TOGOMAK_TRACE"},
+		},
+		&cli.BoolFlag{
+			Name:    "tracing.remote.otlp",
+			Usage:   "Export the spans of the run to an OpenTelemetry collector, configured with the OTEL_EXPORTER_OTLP_* environment variables. Enabled when an OTLP endpoint is set",
+			EnvVars: []string{"TOGOMAK_TRACING_REMOTE_OTLP"},
+		},
+		&cli.StringFlag{
+			Name:    "metrics.textfile",
+			Usage:   "Path to a file where the Prometheus metrics of the run are written, for the textfile collector of node_exporter",
+			EnvVars: []string{"TOGOMAK_METRICS_TEXTFILE"},
+		},
+		&cli.StringFlag{
+			Name:    "metrics.listen",
+			Usage:   "Address where the Prometheus metrics are served on /metrics while the pipeline runs, for example localhost:9090",
+			EnvVars: []string{"TOGOMAK_METRICS_LISTEN"},
+		},
+		&cli.StringFlag{
+			Name:    "cache.local.dir",
+			Usage:   "Directory, which may be shared, where the outputs of stages are cached",
+			EnvVars: []string{"TOGOMAK_CACHE_LOCAL_DIR"},
+		},
+		&cli.StringFlag{
+			Name:    "cache.remote.http.url",
+			Usage:   "Base URL of an HTTP server where the outputs of stages are cached, using GET and PUT",
+			EnvVars: []string{"TOGOMAK_CACHE_REMOTE_HTTP_URL"},
+		},
 	}
 	cli.VersionFlag = &cli.BoolFlag{
 		Name:    "version",
@@ -230,6 +398,18 @@ func newConfigFromCliContext(ctx *cli.Context) ci.ConductorConfig {
 	}
 
 	args := ctx.Args().Slice()
+	if ctx.Command != nil && (ctx.Command.Name == "resume" || ctx.Command.Name == "profile") && len(args) > 0 {
+		// the first argument of resume and profile is the identifier of a run
+		args = args[1:]
+	}
+	if ctx.Command != nil && ctx.Command.Name == "explain" && len(args) > 0 {
+		// the first argument of explain is the runnable to explain
+		args = args[1:]
+	}
+	if ctx.Command != nil && ctx.Command.Name == "logs" {
+		// the arguments of logs are the runnables whose logs are printed
+		args = nil
+	}
 	envArgs := os.Getenv("TOGOMAK_ARGS")
 	if envArgs != "" {
 		args = append(args, strings.Split(envArgs, " ")...)
@@ -253,12 +433,40 @@ func newConfigFromCliContext(ctx *cli.Context) ci.ConductorConfig {
 		os.Exit(1)
 	}
 	var variables []*ci.Variable
-	for _, v := range ctx.StringSlice("var") {
+	for _, v := range *ctx.Generic("var").(*variableFlags) {
 		shell, d := ci.ParseVariableShell(v)
 		diags = diags.Extend(d)
 		variables = append(variables, shell)
 	}
+	// the files set with --var-file are relative to the original working directory,
+	// the auto files are next to the pipeline
+	var varFiles []string
+	varFiles = append(varFiles, ci.AutoVariableFiles(filepath.Dir(pipelineFilePath))...)
+	for _, f := range ctx.StringSlice("var-file") {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(owd, f)
+		}
+		varFiles = append(varFiles, f)
+	}
+	parser := ci.NewParser()
+	fileVariables, d := ci.ReadVariableFiles(parser, varFiles)
+	diags = diags.Extend(d)
+	variables = append(variables, fileVariables...)
+
+	// the output of a child is printed within the output of the stage of its parent
+	output := ci.OutputStream
+	if !ctx.Bool("child") {
+		output, err = ci.ParseOutputMode(ctx.String("output"), ctx.Bool("ci"), ctx.Bool("json"))
+		if err != nil {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid --output option",
+				Detail:   err.Error(),
+			})
+		}
+	}
 	if diags.HasErrors() {
+		diagWriter = hcl.NewDiagnosticTextWriter(os.Stdout, parser.Files(), 0, true)
 		diagWriter.WriteDiagnostics(diags)
 		os.Exit(1)
 	}
@@ -269,6 +477,8 @@ func newConfigFromCliContext(ctx *cli.Context) ci.ConductorConfig {
 			Ci:                 ctx.Bool("ci"),
 			DryRun:             ctx.Bool("dry-run"),
 			DisableConcurrency: ctx.Bool("disable-concurrency"),
+			MaxParallel:        ctx.Int("concurrency"),
+			KeepGoing:          ctx.Bool("keep-going"),
 
 			Child: behavior.Child{
 				Enabled:      ctx.Bool("child"),
@@ -285,7 +495,7 @@ func newConfigFromCliContext(ctx *cli.Context) ci.ConductorConfig {
 		},
 		User:      os.Getenv("USER"),
 		Hostname:  hostname,
-		Interface: ci.Interface{Verbosity: verboseCount, JSONLogging: ctx.Bool("json")},
+		Interface: ci.Interface{Verbosity: verboseCount, JSONLogging: ctx.Bool("json"), TUI: ctx.Bool("tui"), Output: output},
 		Pipeline: ci.ConfigPipeline{
 			FilterQuery: engines,
 			Filtered:    filtered,
@@ -300,6 +510,15 @@ func newConfigFromCliContext(ctx *cli.Context) ci.ConductorConfig {
 			JSON:          ctx.Bool("json"),
 			CorrelationID: "",
 			Sinks:         logging.ParseSinksFromCLI(ctx),
+			KeepRuns:      ctx.Int("logging.local.runs"),
+		},
+		Cache:   cache.ParseConfigFromCLI(ctx),
+		Metrics: metrics.ParseConfigFromCLI(ctx),
+		Report: ci.ReportConfig{
+			JSON:  absPath(ctx.String("report")),
+			JUnit: absPath(ctx.String("junit")),
+			Trace: absPath(ctx.String("trace")),
+			OTLP:  ctx.Bool("tracing.remote.otlp") || trace.OTLPConfigured(),
 		},
 	}
 	return cfg
@@ -321,6 +540,40 @@ func run(ctx *cli.Context) error {
 	return nil
 }
 
+func resume(ctx *cli.Context) error {
+	cfg := newConfigFromCliContext(ctx)
+	cfg.Pipeline.Resume = true
+	cfg.Pipeline.ResumeRunId = ctx.Args().First()
+	logger, err := logging.New(cfg.Logging)
+	if err != nil {
+		panic(err)
+	}
+
+	global.SetLogger(logger)
+
+	t := ci.NewConductor(cfg)
+	v := orchestra.Perform(t)
+	t.Destroy()
+	os.Exit(v)
+	return nil
+}
+
+func watch(ctx *cli.Context) error {
+	cfg := newConfigFromCliContext(ctx)
+	logger, err := logging.New(cfg.Logging)
+	if err != nil {
+		panic(err)
+	}
+
+	global.SetLogger(logger)
+
+	t := ci.NewConductor(cfg)
+	v := orchestra.Watch(t)
+	t.Destroy()
+	os.Exit(v)
+	return nil
+}
+
 func cleanCache(ctx *cli.Context) error {
 	recursive := ctx.Bool("recursive")
 	owd, err := os.Getwd()
@@ -335,6 +588,41 @@ func cleanCache(ctx *cli.Context) error {
 	return nil
 }
 
+func profile(ctx *cli.Context) error {
+	cfg := newConfigFromCliContext(ctx)
+	return orchestra.Profile(cfg, ctx.Args().First())
+}
+
+func logs(ctx *cli.Context) error {
+	cfg := newConfigFromCliContext(ctx)
+	cfg.Logging.Stderr = true
+	return orchestra.Logs(cfg, ctx.String("run"), ctx.Args().Slice(), ctx.Bool("follow"))
+}
+
+func graph(ctx *cli.Context) error {
+	cfg := newConfigFromCliContext(ctx)
+	cfg.Logging.Stderr = true
+	return orchestra.Graph(cfg, ctx.String("format"), ci.GraphOptions{
+		HideInternal: ctx.Bool("hide-internal"),
+		GroupByPhase: ctx.Bool("group-by-phase"),
+	})
+}
+
+func plan(ctx *cli.Context) error {
+	cfg := newConfigFromCliContext(ctx)
+	cfg.Logging.Stderr = true
+	return orchestra.Plan(cfg, ctx.String("format"))
+}
+
+func explain(ctx *cli.Context) error {
+	if ctx.Args().Len() == 0 {
+		return fmt.Errorf("explain requires the runnable to explain, such as stage.build")
+	}
+	cfg := newConfigFromCliContext(ctx)
+	cfg.Logging.Stderr = true
+	return orchestra.Explain(cfg, ctx.Args().First(), ctx.String("format"))
+}
+
 func list(ctx *cli.Context) error {
 	cfg := newConfigFromCliContext(ctx)
 	return orchestra.List(cfg)
@@ -7,6 +7,7 @@ import (
 	"log"
 	"path"
 	"path/filepath"
+	"strings"
 )
 
 func autoDetectFilePath(cwd string) string {
@@ -42,3 +43,30 @@ func autoDetectFilePath(cwd string) string {
 	return autoDetectFilePath(path.Join(cwd, ".."))
 
 }
+
+// absPath returns the absolute path of p, since togomak changes its working
+// directory to the directory of the pipeline. Empty paths are left empty.
+func absPath(p string) string {
+	if p == "" {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	return abs
+}
+
+// variableFlags are the values of the --var flags. Unlike the values of a
+// cli.StringSliceFlag, they are not split on commas, since they may be HCL
+// literals such as ["a", "b"].
+type variableFlags []string
+
+func (f *variableFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func (f *variableFlags) String() string {
+	return strings.Join(*f, " ")
+}
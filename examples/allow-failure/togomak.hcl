@@ -0,0 +1,20 @@
+togomak {
+  version = 2
+}
+
+stage "experimental_lint" {
+  allow_failure = true
+  script        = <<-EOT
+  echo "this linter is not stable yet"
+  exit 1
+  EOT
+}
+
+stage "test" {
+  script = "echo running tests"
+}
+
+stage "report" {
+  depends_on = [stage.test]
+  script     = "echo experimental lint finished with ${stage.experimental_lint.status}"
+}
@@ -0,0 +1,24 @@
+togomak {
+  version = 2
+
+  # a directory shared between machines, or an HTTP server with
+  # url = "http://cache.example.com/togomak"
+  cache {
+    dir = ".artifacts"
+  }
+}
+
+stage "compile" {
+  inputs  = ["src/**"]
+  outputs = ["dist/app.txt"]
+  script  = <<-EOT
+  mkdir -p dist
+  cat src/*.txt > dist/app.txt
+  echo "compiled dist/app.txt"
+  EOT
+}
+
+stage "package" {
+  depends_on = [stage.compile]
+  script     = "echo packaging dist/app.txt, compile was ${stage.compile.status}"
+}
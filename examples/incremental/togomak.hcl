@@ -0,0 +1,18 @@
+togomak {
+  version = 2
+}
+
+stage "build" {
+  inputs  = ["src/*.txt"]
+  outputs = ["build/greeting.txt"]
+  script  = <<-EOT
+  mkdir -p build
+  cat src/*.txt > build/greeting.txt
+  echo "built build/greeting.txt"
+  EOT
+}
+
+stage "print" {
+  depends_on = [stage.build]
+  script     = "echo build was ${stage.build.status}, $(cat build/greeting.txt | xargs)"
+}
@@ -0,0 +1,15 @@
+togomak {
+  version = 2
+  behavior {
+    max_parallel = 2
+  }
+}
+
+stage "build" {
+  for_each = toset(["linux", "darwin", "windows", "freebsd"])
+  name     = "build"
+  script   = <<-EOT
+  echo "building for ${each.key}"
+  sleep 1
+  EOT
+}
@@ -0,0 +1,28 @@
+togomak {
+  version = 2
+}
+
+variable "fail" {
+  type    = string
+  default = "false"
+}
+
+stage "build" {
+  script = <<-EOT
+  echo "building..."
+  echo "VERSION=1.2.3" >> $TOGOMAK_OUTPUTS
+  EOT
+}
+
+stage "deploy" {
+  depends_on = [stage.build]
+  script     = <<-EOT
+  if [ -f .togomak/deploy-failed ] || [ "${var.fail}" != "true" ]; then
+    echo "deployed ${output.VERSION}"
+  else
+    touch .togomak/deploy-failed
+    echo "deploy flaked"
+    exit 1
+  fi
+  EOT
+}
@@ -0,0 +1,24 @@
+togomak {
+  version = 2
+}
+
+stage "database" {
+  script = "echo starting a test database"
+}
+
+stage "test" {
+  depends_on = [stage.database]
+  script     = "echo running tests against the database"
+}
+
+stage "report" {
+  depends_on = [stage.test]
+  run_when   = "on_failure"
+  script     = "echo ${join(", ", this.failed_upstream)} failed"
+}
+
+stage "teardown" {
+  depends_on = [stage.test]
+  run_when   = "always"
+  script     = "echo stopping the test database, tests ${stage.test.status}"
+}
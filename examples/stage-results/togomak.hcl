@@ -0,0 +1,21 @@
+togomak {
+  version = 2
+}
+
+stage "build" {
+  script = "echo v1.2.3"
+}
+
+stage "lint" {
+  if     = false
+  script = "echo linting"
+}
+
+stage "summary" {
+  if     = stage.build.status == "success"
+  script = <<-EOT
+  echo "build completed in ${stage.build.duration}s after ${stage.build.attempts} attempt(s) with exit code ${stage.build.exit_code}"
+  echo "built version: ${trimspace(stage.build.output)}"
+  echo "lint: ${stage.lint.status}"
+  EOT
+}
@@ -0,0 +1,20 @@
+togomak {
+  version = 2
+}
+
+stage "integration_tests" {
+  script = <<-EOT
+  echo "running integration tests"
+  sleep 1
+  EOT
+
+  lifecycle {
+    timeout = 60
+  }
+
+  post_hook {
+    stage {
+      script = "echo integration tests completed with status: ${this.status}"
+    }
+  }
+}
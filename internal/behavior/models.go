@@ -28,6 +28,14 @@ type Behavior struct {
 	DryRun bool
 
 	DisableConcurrency bool
+
+	// KeepGoing is the flag to indicate whether the pipeline continues running the
+	// stages which do not depend on a stage that failed
+	KeepGoing bool
+
+	// MaxParallel is the maximum number of stages which can run at the same time,
+	// zero means that there is no limit
+	MaxParallel int
 }
 
 func NewDefaultBehavior() *Behavior {
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ArtifactExtension is the extension of the artifacts in the artifact cache
+const ArtifactExtension = ".tar.gz"
+
+// OutputsEntry is the entry of an artifact with the outputs the stage wrote
+// to TOGOMAK_OUTPUTS, it is not extracted with the files of the artifact
+const OutputsEntry = meta.BuildDirPrefix + "/" + meta.OutputEnvFile
+
+// Pack writes a gzipped tar archive with files, which are relative to dir, and
+// with outputs, the outputs the stage wrote to TOGOMAK_OUTPUTS, to w
+func Pack(w io.Writer, dir string, files []string, outputs []byte) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	if len(outputs) > 0 {
+		header := &tar.Header{Name: OutputsEntry, Mode: 0644, Size: int64(len(outputs)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(outputs); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		if err := packFile(tw, dir, file); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func packFile(tw *tar.Writer, dir string, file string) error {
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(file)
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Unpack extracts the gzipped tar archive read from r, created by Pack, into dir,
+// and returns the outputs the stage wrote to TOGOMAK_OUTPUTS
+func Unpack(r io.Reader, dir string) ([]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var outputs []byte
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return outputs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if header.Name == OutputsEntry {
+			if outputs, err = io.ReadAll(tr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("artifact contains a file outside of the working directory: %s", header.Name)
+		}
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config configures where the outputs of stages are stored, so that they
+// can be restored on another machine instead of running the stage again
+type Config struct {
+	// Dir is a directory, which may be shared between machines, used as the artifact cache
+	Dir string
+
+	// URL is the base URL of an HTTP artifact cache. Artifacts are read with
+	// GET <URL>/<key>, which returns 404 when the artifact does not exist,
+	// and stored with PUT <URL>/<key>.
+	URL string
+}
+
+func ParseConfigFromCLI(ctx *cli.Context) Config {
+	dir := ctx.String("cache.local.dir")
+	if dir != "" {
+		// togomak changes its working directory to the pipeline's directory later
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+	}
+	return Config{
+		Dir: dir,
+		URL: ctx.String("cache.remote.http.url"),
+	}
+}
+
+// Enabled returns true if an artifact cache is configured
+func (c Config) Enabled() bool {
+	return c.Dir != "" || c.URL != ""
+}
+
+// Store is a content addressed store for artifacts, keyed by the fingerprint
+// of the stage which produced them
+type Store interface {
+	// Get returns the artifact stored for key, and false if there is none
+	Get(key string) (io.ReadCloser, bool, error)
+
+	// Put stores the artifact read from r for key
+	Put(key string, r io.Reader) error
+
+	// String returns a description of the store for logging
+	String() string
+}
+
+// NewStore creates the Store described by cfg. When both a directory and
+// a URL are configured, the directory is checked first, and artifacts are
+// stored in both. NewStore returns nil if no artifact cache is configured.
+func NewStore(cfg Config) Store {
+	var stores Stores
+	if cfg.Dir != "" {
+		stores = append(stores, &LocalStore{Dir: cfg.Dir})
+	}
+	if cfg.URL != "" {
+		stores = append(stores, NewHTTPStore(cfg.URL))
+	}
+	switch len(stores) {
+	case 0:
+		return nil
+	case 1:
+		return stores[0]
+	}
+	return stores
+}
+
+// LocalStore stores artifacts in a directory
+type LocalStore struct {
+	Dir string
+}
+
+func (s *LocalStore) path(key string) string {
+	prefix := key
+	if len(prefix) > 2 {
+		prefix = prefix[:2]
+	}
+	return filepath.Join(s.Dir, prefix, key+ArtifactExtension)
+}
+
+func (s *LocalStore) Get(key string) (io.ReadCloser, bool, error) {
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return f, true, nil
+}
+
+func (s *LocalStore) Put(key string, r io.Reader) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// the artifact is renamed into place once it is complete, so that
+	// other machines sharing the directory never read a partial artifact
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *LocalStore) String() string {
+	return s.Dir
+}
+
+// HTTPStore stores artifacts on an HTTP server
+type HTTPStore struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewHTTPStore(baseUrl string) *HTTPStore {
+	return &HTTPStore{
+		URL:    strings.TrimSuffix(baseUrl, "/"),
+		Client: &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+func (s *HTTPStore) url(key string) string {
+	return s.URL + "/" + url.PathEscape(key)
+}
+
+func (s *HTTPStore) Get(key string) (io.ReadCloser, bool, error) {
+	resp, err := s.Client.Get(s.url(key))
+	if err != nil {
+		return nil, false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, true, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, false, nil
+	}
+	resp.Body.Close()
+	return nil, false, fmt.Errorf("GET %s: %s", s.url(key), resp.Status)
+}
+
+func (s *HTTPStore) Put(key string, r io.Reader) error {
+	req, err := http.NewRequest(http.MethodPut, s.url(key), r)
+	if err != nil {
+		return err
+	}
+	if f, ok := r.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			req.ContentLength = info.Size()
+		}
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("PUT %s: %s", s.url(key), resp.Status)
+	}
+	return nil
+}
+
+func (s *HTTPStore) String() string {
+	return s.URL
+}
+
+// Stores checks each of its stores in order, and stores artifacts in all of them
+type Stores []Store
+
+func (s Stores) Get(key string) (io.ReadCloser, bool, error) {
+	var errs []error
+	for _, store := range s {
+		r, ok, err := store.Get(key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			return r, true, nil
+		}
+	}
+	return nil, false, errors.Join(errs...)
+}
+
+func (s Stores) Put(key string, r io.Reader) error {
+	if len(s) == 1 {
+		return s[0].Put(key, r)
+	}
+
+	// the artifact can only be read once, it is buffered in a
+	// temporary file so that it can be stored in every store
+	f, err := os.CreateTemp("", "togomak-artifact-*"+ArtifactExtension)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, store := range s {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := store.Put(key, f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s Stores) String() string {
+	var names []string
+	for _, store := range s {
+		names = append(names, store.String())
+	}
+	return strings.Join(names, ", ")
+}
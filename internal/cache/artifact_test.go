@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestServer starts an HTTP artifact cache which keeps the artifacts in memory
+func newTestServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	artifacts := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := artifacts[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(data)
+		case http.MethodPut:
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			artifacts[r.URL.Path] = data
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testStore(t *testing.T, store Store) {
+	_, ok, err := store.Get("abcdef")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, store.Put("abcdef", strings.NewReader("artifact")))
+
+	r, ok, err := store.Get("abcdef")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "artifact", string(data))
+}
+
+func TestLocalStore(t *testing.T) {
+	testStore(t, NewStore(Config{Dir: t.TempDir()}))
+}
+
+func TestHTTPStore(t *testing.T) {
+	testStore(t, NewStore(Config{URL: newTestServer(t).URL + "/"}))
+}
+
+func TestStores(t *testing.T) {
+	dir := t.TempDir()
+	server := newTestServer(t)
+	testStore(t, NewStore(Config{Dir: dir, URL: server.URL}))
+
+	// the artifact was stored in both stores
+	_, ok, err := (&LocalStore{Dir: dir}).Get("abcdef")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, ok, err = NewHTTPStore(server.URL).Get("abcdef")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Nil(t, NewStore(Config{}))
+}
+
+func TestPack(t *testing.T) {
+	src := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "bin"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "bin", "app"), []byte("binary"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "report.txt"), []byte("report"), 0644))
+
+	var buf bytes.Buffer
+	assert.NoError(t, Pack(&buf, src, []string{"bin/app", "report.txt"}, []byte("VERSION=1.2.3\n")))
+
+	dst := t.TempDir()
+	outputs, err := Unpack(&buf, dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "VERSION=1.2.3\n", string(outputs))
+	assert.NoFileExists(t, filepath.Join(dst, OutputsEntry))
+	data, err := os.ReadFile(filepath.Join(dst, "bin", "app"))
+	assert.NoError(t, err)
+	assert.Equal(t, "binary", string(data))
+	info, err := os.Stat(filepath.Join(dst, "bin", "app"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	data, err = os.ReadFile(filepath.Join(dst, "report.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "report", string(data))
+}
+
+func TestUnpack_OutsideDir(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "a/../../escape", Mode: 0644, Size: 1, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("x"))
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+
+	dir := t.TempDir()
+	_, err = Unpack(&buf, filepath.Join(dir, "workspace"))
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(dir, "escape"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -24,6 +24,13 @@ func CleanCache(dir string, recursive bool) {
 	if err != nil {
 		panic(err)
 	}
+
+	fingerprintPath := filepath.Join(dir, meta.BuildDirPrefix, FingerprintDir)
+	if _, err := os.Stat(fingerprintPath); err == nil {
+		fmt.Println("removing", fingerprintPath)
+		x.Must(os.RemoveAll(fingerprintPath))
+	}
+
 	var wg sync.WaitGroup
 	if recursive {
 		entries, err := os.ReadDir(dir)
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FingerprintDir is the directory within meta.BuildDirPrefix where the
+// fingerprints of the last successful run of each stage are stored
+const FingerprintDir = "fingerprints"
+
+// FingerprintOutputsDir is the directory within FingerprintDir where the outputs
+// the stages wrote to TOGOMAK_OUTPUTS in their last successful run are stored
+const FingerprintOutputsDir = "outputs"
+
+// Fingerprint is a hash over everything which decides the outcome of a stage,
+// such as the content of its input files, its rendered command and its environment.
+type Fingerprint struct {
+	h hash.Hash
+}
+
+func NewFingerprint() *Fingerprint {
+	return &Fingerprint{h: sha256.New()}
+}
+
+// Add adds a named value to the fingerprint
+func (f *Fingerprint) Add(key string, value string) {
+	// the lengths are included, so that moving content from one
+	// value to the next does not produce the same fingerprint
+	_, _ = fmt.Fprintf(f.h, "%d:%s=%d:%s\n", len(key), key, len(value), value)
+}
+
+// AddFile adds the name and the content of the file at path to the
+// fingerprint. name is usually the path relative to the working directory,
+// so that the fingerprint does not change when the project is moved.
+func (f *Fingerprint) AddFile(name string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	content := sha256.New()
+	if _, err := io.Copy(content, file); err != nil {
+		return err
+	}
+	f.Add("file:"+name, hex.EncodeToString(content.Sum(nil)))
+	return nil
+}
+
+// Sum returns the hex encoded fingerprint
+func (f *Fingerprint) Sum() string {
+	return hex.EncodeToString(f.h.Sum(nil))
+}
+
+// FingerprintPath returns the path where the fingerprint of the runnable
+// identified by id is stored, for the pipeline in dir
+func FingerprintPath(dir string, id string) string {
+	return filepath.Join(dir, meta.BuildDirPrefix, FingerprintDir, url.PathEscape(id))
+}
+
+// LoadFingerprint returns the fingerprint stored for the runnable identified by
+// id, and false if there is none
+func LoadFingerprint(dir string, id string) (string, bool) {
+	data, err := os.ReadFile(FingerprintPath(dir, id))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(data)), true
+}
+
+// FingerprintOutputsPath returns the path where the outputs of the runnable
+// identified by id are stored, along with its fingerprint
+func FingerprintOutputsPath(dir string, id string) string {
+	return filepath.Join(dir, meta.BuildDirPrefix, FingerprintDir, FingerprintOutputsDir, url.PathEscape(id))
+}
+
+// LoadFingerprintOutputs returns the outputs stored along with the fingerprint
+// of the runnable identified by id, which are empty if it has no outputs
+func LoadFingerprintOutputs(dir string, id string) ([]byte, error) {
+	data, err := os.ReadFile(FingerprintOutputsPath(dir, id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// SaveFingerprint stores sum as the fingerprint of the runnable identified by
+// id, along with outputs, the outputs it wrote to TOGOMAK_OUTPUTS
+func SaveFingerprint(dir string, id string, sum string, outputs []byte) error {
+	path := FingerprintOutputsPath(dir, id)
+	if len(outputs) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, outputs, 0644); err != nil {
+			return err
+		}
+	}
+
+	path = FingerprintPath(dir, id)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(sum+"\n"), 0644)
+}
+
+// ForgetFingerprint removes the fingerprint stored for the runnable identified
+// by id, and its outputs, so that it is not considered up to date on the next run
+func ForgetFingerprint(dir string, id string) error {
+	for _, path := range []string{FingerprintPath(dir, id), FingerprintOutputsPath(dir, id)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "main.go")
+	assert.NoError(t, os.WriteFile(input, []byte("package main"), 0644))
+
+	sum := func() string {
+		f := NewFingerprint()
+		f.Add("arg", "go build")
+		assert.NoError(t, f.AddFile("main.go", input))
+		return f.Sum()
+	}
+	before := sum()
+	assert.Equal(t, before, sum())
+
+	assert.NoError(t, os.WriteFile(input, []byte("package main // changed"), 0644))
+	assert.NotEqual(t, before, sum())
+
+	a := NewFingerprint()
+	a.Add("arg", "ab")
+	a.Add("arg", "c")
+	b := NewFingerprint()
+	b.Add("arg", "a")
+	b.Add("arg", "bc")
+	assert.NotEqual(t, a.Sum(), b.Sum())
+}
+
+func TestSaveFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	id := `stage.build["linux"]`
+
+	_, ok := LoadFingerprint(dir, id)
+	assert.False(t, ok)
+
+	assert.NoError(t, SaveFingerprint(dir, id, "abc", []byte("VERSION=1.2.3\n")))
+	sum, ok := LoadFingerprint(dir, id)
+	assert.True(t, ok)
+	assert.Equal(t, "abc", sum)
+	outputs, err := LoadFingerprintOutputs(dir, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "VERSION=1.2.3\n", string(outputs))
+
+	// the outputs of a previous run are not kept
+	assert.NoError(t, SaveFingerprint(dir, id, "abc", nil))
+	outputs, err = LoadFingerprintOutputs(dir, id)
+	assert.NoError(t, err)
+	assert.Empty(t, outputs)
+
+	assert.NoError(t, SaveFingerprint(dir, id, "abc", []byte("VERSION=1.2.3\n")))
+	assert.NoError(t, ForgetFingerprint(dir, id))
+	assert.NoError(t, ForgetFingerprint(dir, id))
+	_, ok = LoadFingerprint(dir, id)
+	assert.False(t, ok)
+	assert.NoFileExists(t, FingerprintOutputsPath(dir, id))
+}
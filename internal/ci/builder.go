@@ -4,9 +4,18 @@ const BuilderBlock = "togomak"
 
 type Behavior struct {
 	DisableConcurrency bool `hcl:"disable_concurrency,optional" json:"disable_concurrency"`
+	MaxParallel        int  `hcl:"max_parallel,optional" json:"max_parallel"`
+}
+
+// Cache configures the artifact cache, see cache.Config. The --cache.local.dir
+// and --cache.remote.http.url flags take precedence over it.
+type Cache struct {
+	Dir string `hcl:"dir,optional" json:"dir"`
+	URL string `hcl:"url,optional" json:"url"`
 }
 
 type Builder struct {
 	Version  int       `hcl:"version" json:"version"`
 	Behavior *Behavior `hcl:"behavior,block" json:"behavior"`
+	Cache    *Cache    `hcl:"cache,block" json:"cache"`
 }
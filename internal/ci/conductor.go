@@ -7,10 +7,14 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclparse"
 	"github.com/sirupsen/logrus"
+	"github.com/srevinsaju/togomak/v1/internal/cache"
 	"github.com/srevinsaju/togomak/v1/internal/conductor"
 	"github.com/srevinsaju/togomak/v1/internal/logging"
 	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"github.com/srevinsaju/togomak/v1/internal/metrics"
+	"github.com/srevinsaju/togomak/v1/internal/pool"
 	"github.com/srevinsaju/togomak/v1/internal/rules"
+	"github.com/srevinsaju/togomak/v1/internal/trace"
 	"github.com/srevinsaju/togomak/v1/internal/x"
 	"os"
 	"path/filepath"
@@ -38,6 +42,20 @@ func ConductorWithContext(ctx context.Context) ConductorOption {
 	}
 }
 
+func ConductorWithDaemonContext(ctx context.Context) ConductorOption {
+	return func(c *Conductor) {
+		c.daemonCtx = ctx
+	}
+}
+
+// ConductorWithTracker sets the tracker of the runnables started by the pipeline
+// of the conductor, so that they can be followed while the pipeline runs
+func ConductorWithTracker(tracker *Tracker) ConductorOption {
+	return func(c *Conductor) {
+		c.tracker = tracker
+	}
+}
+
 func ConductorWithParser(parser *Parser) ConductorOption {
 	return func(c *Conductor) {
 		c.Parser = parser
@@ -74,6 +92,48 @@ func ConductorWithVariablesList(variables Variables) ConductorOption {
 	}
 }
 
+func ConductorWithPool(p *pool.Pool) ConductorOption {
+	return func(c *Conductor) {
+		c.pool = p
+	}
+}
+
+func ConductorWithArtifacts(store cache.Store) ConductorOption {
+	return func(c *Conductor) {
+		c.artifacts = store
+	}
+}
+
+func ConductorWithResults(results *Results) ConductorOption {
+	return func(c *Conductor) {
+		c.results = results
+	}
+}
+
+func ConductorWithTracer(tracer *trace.Tracer) ConductorOption {
+	return func(c *Conductor) {
+		c.tracer = tracer
+	}
+}
+
+func ConductorWithRunLogs(logs *RunLogs) ConductorOption {
+	return func(c *Conductor) {
+		c.logs = logs
+	}
+}
+
+func ConductorWithModule(module string) ConductorOption {
+	return func(c *Conductor) {
+		c.module = module
+	}
+}
+
+func ConductorWithMetrics(metrics *metrics.Metrics) ConductorOption {
+	return func(c *Conductor) {
+		c.metrics = metrics
+	}
+}
+
 type Eval struct {
 	context *hcl.EvalContext
 	mu      *sync.RWMutex
@@ -95,6 +155,11 @@ type Conductor struct {
 
 	ctx context.Context
 
+	// daemonCtx is the context the daemons run in, togomak watch sets it to a
+	// context which outlives the run, so that the daemons keep running across
+	// runs. The daemons run in ctx when it is nil.
+	daemonCtx context.Context
+
 	// Process is the current process
 	Process Process
 
@@ -114,6 +179,47 @@ type Conductor struct {
 
 	variables Variables
 
+	// pool limits the number of stages which run at the same time,
+	// it is shared between a conductor and all of its children
+	pool *pool.Pool
+
+	// results has the results of the stages and modules which have finished running
+	results *Results
+
+	// artifacts stores the outputs of stages, so that they can be restored instead
+	// of running the stage again. It is nil when no artifact cache is configured,
+	// and is shared between a conductor and all of its children
+	artifacts cache.Store
+
+	// tracer records the spans of the run when --trace or OTLP is enabled, it is
+	// created by orchestra.Perform. Modules record their spans in their own lanes
+	// of the tracer of their parent
+	tracer *trace.Tracer
+
+	// metrics records the Prometheus metrics of the run when they are written or
+	// served, it is created by orchestra.Perform. Modules record their metrics in
+	// the metrics of their parent, prefixed with the identifier of the module
+	metrics *metrics.Metrics
+
+	// output prints the output of the stages, it is shared between a conductor
+	// and all of its children
+	output *Output
+
+	// logs writes the output of each runnable of the root pipeline to its own
+	// file, it is nil for dry runs and children. Modules write their logs in a
+	// directory of the logs of their parent
+	logs *RunLogs
+
+	// module is the path of the module whose pipeline is run by the conductor,
+	// such as module.deploy or module.deploy/module.app, it is empty for the
+	// root pipeline
+	module string
+
+	// tracker follows the runnables started by the pipeline of the conductor,
+	// when it is set by the dashboard of orchestra.Perform. It is not shared
+	// with the modules.
+	tracker *Tracker
+
 	outputsMu sync.Mutex
 	outputs   map[string]*bytes.Buffer
 }
@@ -148,12 +254,26 @@ type Parser struct {
 	mu     *sync.RWMutex
 }
 
+// NewParser creates a parser, which caches the files it has parsed
+func NewParser() *Parser {
+	return &Parser{
+		parser: hclparse.NewParser(),
+		mu:     &sync.RWMutex{},
+	}
+}
+
 func (p *Parser) ParseHCLFile(filename string) (*hcl.File, hcl.Diagnostics) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	return p.parser.ParseHCLFile(filename)
 }
 
+func (p *Parser) ParseJSONFile(filename string) (*hcl.File, hcl.Diagnostics) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.parser.ParseJSONFile(filename)
+}
+
 func (p *Parser) Files() map[string]*hcl.File {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
@@ -183,6 +303,12 @@ func (c *Conductor) Child(opts ...ConductorOption) *Conductor {
 	opts = append(inheritOpts, opts...)
 	child := NewConductor(c.Config, opts...)
 	child.parent = c
+	child.pool = c.pool
+	child.artifacts = c.artifacts
+	child.tracer = c.tracer
+	child.metrics = c.metrics
+	child.logs = c.logs
+	child.output = c.output
 	return child
 }
 
@@ -197,6 +323,58 @@ func (c *Conductor) RootParent() *Conductor {
 	return c.parent.RootParent()
 }
 
+func (c *Conductor) Pool() *pool.Pool {
+	return c.pool
+}
+
+func (c *Conductor) Results() *Results {
+	return c.results
+}
+
+func (c *Conductor) Artifacts() cache.Store {
+	return c.artifacts
+}
+
+// Tracer returns the tracer of the run, which is nil when tracing is disabled
+func (c *Conductor) Tracer() *trace.Tracer {
+	return c.tracer
+}
+
+// Output returns how the output of the stages is printed
+func (c *Conductor) Output() *Output {
+	return c.output
+}
+
+// Module returns the path of the module whose pipeline is run by the
+// conductor, which is empty for the root pipeline
+func (c *Conductor) Module() string {
+	return c.module
+}
+
+// ModuleId returns the identifier of runnableId within the run, such as
+// module.deploy/stage.build for a stage of a module
+func (c *Conductor) ModuleId(runnableId string) string {
+	if c.module == "" {
+		return runnableId
+	}
+	return c.module + "/" + runnableId
+}
+
+// RunLogs returns the log files of the runnables of the run, which is nil when
+// they are not written
+func (c *Conductor) RunLogs() *RunLogs {
+	return c.logs
+}
+
+// Metrics returns the metrics of the run, which is nil when metrics are disabled
+func (c *Conductor) Metrics() *metrics.Metrics {
+	return c.metrics
+}
+
+func (c *Conductor) Tracker() *Tracker {
+	return c.tracker
+}
+
 func (c *Conductor) Logger() logrus.Ext1FieldLogger {
 	return c.RootLogger
 }
@@ -217,6 +395,14 @@ func (c *Conductor) Context() context.Context {
 	return c.ctx
 }
 
+// DaemonContext returns the context the daemons run in
+func (c *Conductor) DaemonContext() context.Context {
+	if c.daemonCtx == nil {
+		return c.ctx
+	}
+	return c.daemonCtx
+}
+
 func NewProcess(cfg ConductorConfig) Process {
 	e, err := os.Executable()
 	x.Must(err)
@@ -259,9 +445,9 @@ func Chdir(cfg ConductorConfig, logger *logrus.Logger) string {
 }
 
 func NewConductor(cfg ConductorConfig, opts ...ConductorOption) *Conductor {
-	parser := hclparse.NewParser()
+	parser := NewParser()
 
-	diagWriter := hcl.NewDiagnosticTextWriter(os.Stdout, parser.Files(), 0, true)
+	diagWriter := hcl.NewDiagnosticTextWriter(logging.Secrets().Writer(os.Stdout), parser.Files(), 0, true)
 
 	process := NewProcess(cfg)
 	// create a new logger derived from conductor configurations
@@ -271,6 +457,7 @@ func NewConductor(cfg ConductorConfig, opts ...ConductorOption) *Conductor {
 		IsCI:          cfg.Logging.IsCI,
 		JSON:          cfg.Logging.JSON,
 		CorrelationID: process.Id.String(),
+		Stderr:        cfg.Logging.Stderr,
 		Sinks:         cfg.Logging.Sinks,
 	})
 	if err != nil {
@@ -286,15 +473,16 @@ func NewConductor(cfg ConductorConfig, opts ...ConductorOption) *Conductor {
 	}
 
 	c := &Conductor{
-		Parser: &Parser{
-			parser: parser,
-			mu:     &sync.RWMutex{},
-		},
+		Parser:     parser,
 		DiagWriter: diagWriter,
 		ctx:        context.Background(),
 		Process:    process,
 		RootLogger: logger,
 		Config:     cfg,
+		pool:       pool.New(cfg.Behavior.MaxParallel),
+		results:    NewResults(),
+		artifacts:  cache.NewStore(cfg.Cache),
+		output:     NewOutput(cfg.Interface.Output),
 	}
 	for _, v := range cfg.Variables {
 		c.variables = append(c.variables, v)
@@ -2,7 +2,9 @@ package ci
 
 import (
 	"github.com/srevinsaju/togomak/v1/internal/behavior"
+	"github.com/srevinsaju/togomak/v1/internal/cache"
 	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/srevinsaju/togomak/v1/internal/metrics"
 	"github.com/srevinsaju/togomak/v1/internal/path"
 	"github.com/srevinsaju/togomak/v1/internal/rules"
 )
@@ -11,12 +13,49 @@ type ConfigPipeline struct {
 	Filtered    rules.Operations
 	FilterQuery QueryEngines
 	DryRun      bool
+
+	// Resume is set when resuming a previous run, see RunState. ResumeRunId
+	// is the identifier of the run, the latest run is resumed when it is empty.
+	Resume      bool
+	ResumeRunId string
+
+	// Watch is set by togomak watch, the run does not wait for its daemons,
+	// which keep running until they are stopped, see Tracker.StopDaemons
+	Watch bool
+
+	// Rerun are the identifiers of the stages and modules which are run again
+	// by togomak watch, the other stages and modules are not run. Every
+	// runnable is run when Rerun is nil.
+	Rerun map[string]bool
+}
+
+// ReportConfig configures the reports which are written once the pipeline has finished
+type ReportConfig struct {
+	// JSON is the path of the JSON report, see Report
+	JSON string
+
+	// JUnit is the path of the JUnit XML report, see Report.JUnit
+	JUnit string
+
+	// Trace is the path of the trace of the run, in the Chrome trace event format
+	Trace string
+
+	// OTLP exports the spans of the run to the OpenTelemetry collector which is
+	// configured with the OTEL_EXPORTER_OTLP_* environment variables
+	OTLP bool
 }
 
 type Interface struct {
 	// Verbosity is the level of verbosity
 	Verbosity   int
 	JSONLogging bool
+
+	// TUI shows a live dashboard of the run instead of its logs, when
+	// togomak is run on a terminal
+	TUI bool
+
+	// Output is how the output of the stages is printed
+	Output OutputMode
 }
 
 type ConductorConfig struct {
@@ -36,4 +75,13 @@ type ConductorConfig struct {
 	Variables Variables
 
 	Logging logging.Config
+
+	// Cache configures the artifact cache, which stores the outputs of stages
+	Cache cache.Config
+
+	// Report configures the reports of the run
+	Report ReportConfig
+
+	// Metrics configures where the Prometheus metrics of the run are written
+	Metrics metrics.Config
 }
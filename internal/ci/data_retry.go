@@ -19,3 +19,7 @@ func (s *Data) RetryExponentialBackoff() bool {
 	return false
 
 }
+
+func (s *Data) FailureAllowed() bool {
+	return false
+}
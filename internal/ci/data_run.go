@@ -6,6 +6,7 @@ import (
 	dataBlock "github.com/srevinsaju/togomak/v1/internal/blocks/data"
 	"github.com/srevinsaju/togomak/v1/internal/global"
 	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/srevinsaju/togomak/v1/internal/third-party/hashicorp/terraform/lang/marks"
 	"github.com/zclconf/go-cty/cty"
 )
 
@@ -41,6 +42,7 @@ func (s *Data) Run(conductor *Conductor, options ...runnable.Option) (diags hcl.
 	for _, pr := range dataBlock.DefaultProviders {
 		if pr.Name() == s.Provider {
 			validProvider = true
+			span := conductor.Tracer().Start(ResultId(s), "data", fmt.Sprintf("provider %s", s.Provider))
 			provide := pr.New()
 			provide.SetContext(ctx)
 			diags = diags.Extend(provide.DecodeBody(conductor, s.Body, opts...))
@@ -48,6 +50,7 @@ func (s *Data) Run(conductor *Conductor, options ...runnable.Option) (diags hcl.
 			diags = diags.Extend(d)
 			attr, d = provide.Attributes(conductor, ctx, s.Id, opts...)
 			diags = diags.Extend(d)
+			span.End()
 			break
 		}
 	}
@@ -69,6 +72,12 @@ func (s *Data) Run(conductor *Conductor, options ...runnable.Option) (diags hcl.
 	for k, v := range attr {
 		m[k] = v
 	}
+	if s.Sensitive {
+		for k, v := range m {
+			m[k] = v.Mark(marks.Sensitive)
+		}
+	}
+	recordSensitiveValues(cty.ObjectVal(m))
 
 	global.DataBlockEvalContextMutex.Lock()
 
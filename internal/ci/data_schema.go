@@ -9,6 +9,10 @@ type Data struct {
 	Name  string `hcl:"name,optional" json:"name"`
 	Value string `json:"value"`
 
+	// Sensitive marks the value and the attributes of the data block as sensitive,
+	// they are masked in the logs
+	Sensitive bool `hcl:"sensitive,optional" json:"sensitive"`
+
 	Body hcl.Body `hcl:",remain"`
 }
 
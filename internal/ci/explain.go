@@ -0,0 +1,198 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"strings"
+)
+
+// the steps of the decision to run a runnable, see BlockCanRun
+const (
+	ExplainCondition = "condition"
+	ExplainRunWhen   = "run_when"
+	ExplainQuery     = "query"
+	ExplainPhase     = "phase"
+	ExplainFilter    = "filter"
+)
+
+// ExplainStep is a step of the decision to run a runnable
+type ExplainStep struct {
+	Step   string `json:"step"`
+	Detail string `json:"detail"`
+
+	// Ok and Overridden are the decision after the step
+	Ok         bool `json:"ok"`
+	Overridden bool `json:"overridden"`
+}
+
+// Explanation records how BlockCanRun decided if a runnable is run. Its methods
+// do nothing when it is nil, so that the decision is only recorded when explained.
+type Explanation struct {
+	Id string `json:"id"`
+
+	// Filters and Queries are the filters and the queries given on the command line
+	Filters []string `json:"filters"`
+	Queries []string `json:"queries"`
+
+	Steps []ExplainStep `json:"steps"`
+
+	Run        bool   `json:"run"`
+	Overridden bool   `json:"overridden"`
+	Reason     string `json:"reason,omitempty"`
+
+	// Module explains the decision to run the module the runnable is part of
+	Module *Explanation `json:"module,omitempty"`
+}
+
+func (e *Explanation) record(step string, ok bool, overridden bool, format string, args ...any) {
+	if e == nil {
+		return
+	}
+	e.Steps = append(e.Steps, ExplainStep{
+		Step:       step,
+		Detail:     fmt.Sprintf(format, args...),
+		Ok:         ok,
+		Overridden: overridden,
+	})
+}
+
+// len returns the number of steps recorded
+func (e *Explanation) len() int {
+	if e == nil {
+		return 0
+	}
+	return len(e.Steps)
+}
+
+// explainErrors describes the errors in diags, to be appended to a step
+func explainErrors(diags hcl.Diagnostics) string {
+	if !diags.HasErrors() {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", planReason(diags))
+}
+
+// Explain decides if the runnable identified by runnableId would be run, and
+// records each step of the decision. The variables, locals, data and macro blocks
+// it depends on are evaluated first, stages and modules are never run. The
+// runnables of a module are identified as module.<id>/<runnable>, in which case
+// the source of the module is downloaded.
+func Explain(conductor *Conductor, pipe *Pipeline, depGraph *depgraph.Graph, runnableId string) (*Explanation, hcl.Diagnostics) {
+	opts := []runnable.Option{
+		runnable.WithBehavior(conductor.Config.Behavior),
+		runnable.WithPaths(conductor.Config.Paths),
+	}
+	if moduleId, childId, ok := strings.Cut(runnableId, "/"); ok {
+		return explainModule(conductor, pipe, depGraph, moduleId, childId, opts...)
+	}
+
+	var diags hcl.Diagnostics
+	target, skip, d := pipe.Resolve(runnableId)
+	diags = diags.Extend(d)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	if skip || target == nil {
+		return nil, diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "nothing to explain",
+			Detail:   fmt.Sprintf("%s is not defined in the pipeline", runnableId),
+		})
+	}
+
+	for _, layer := range depGraph.TopoSortedLayers() {
+		for _, id := range layer {
+			if !depGraph.DependsOn(runnableId, id) {
+				continue
+			}
+			blockType, _, _ := strings.Cut(id, ".")
+			if blockType == blocks.StageBlock || blockType == blocks.ModuleBlock {
+				continue
+			}
+			r, skip, d := pipe.Resolve(id)
+			if skip || d.HasErrors() {
+				diags = diags.Extend(d)
+				continue
+			}
+			if data, ok := r.(*Data); ok && planProviders[data.Provider] {
+				continue
+			}
+			diags = diags.Extend(r.Run(conductor, opts...))
+		}
+	}
+
+	explain := &Explanation{
+		Id:      runnableId,
+		Filters: conductor.Config.Pipeline.Filtered.Marshall(),
+		Queries: conductor.Config.Pipeline.FilterQuery.Marshall(),
+	}
+	target.Set(StageContextFailedUpstream, []string{})
+	ok, overridden, reason, d := blockCanRun(target, conductor, runnableId, depGraph, explain, opts...)
+	diags = diags.Extend(d)
+	explain.Run, explain.Overridden, explain.Reason = ok, overridden, reason
+	return explain, diags
+}
+
+// explainModule explains the decision to run the runnable identified by childId,
+// in the pipeline of the module identified by moduleId
+func explainModule(conductor *Conductor, pipe *Pipeline, depGraph *depgraph.Graph, moduleId string, childId string, opts ...runnable.Option) (*Explanation, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	module, d := Explain(conductor, pipe, depGraph, moduleId)
+	diags = diags.Extend(d)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	runnableId := fmt.Sprintf("%s/%s", moduleId, childId)
+	if !module.Run {
+		return &Explanation{
+			Id:      runnableId,
+			Filters: module.Filters,
+			Queries: module.Queries,
+			Module:  module,
+			Reason:  fmt.Sprintf("%s is not run", moduleId),
+		}, diags
+	}
+
+	r, _, _ := pipe.Resolve(moduleId)
+	m, ok := r.(*Module)
+	if !ok {
+		return nil, diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "nothing to explain",
+			Detail:   fmt.Sprintf("%s is not a module, only the runnables of modules are identified as module.<id>/<runnable>", moduleId),
+		})
+	}
+
+	source, evalCtx, d := m.source(conductor, opts...)
+	diags = diags.Extend(d)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	childConductor, childPipe, d := m.child(conductor, conductor.Context(), source, evalCtx, opts...)
+	diags = diags.Extend(d)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	childPipe, childGraph, d := ExpandGraph(childConductor, childPipe)
+	diags = diags.Extend(d)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	explain, d := Explain(childConductor, childPipe, childGraph, childId)
+	diags = diags.Extend(d)
+	if explain != nil {
+		explain.Id = runnableId
+		explain.Module = module
+	}
+	return explain, diags
+}
+
+// JSON returns the explanation as JSON
+func (e *Explanation) JSON() ([]byte, error) {
+	return json.MarshalIndent(e, "", "  ")
+}
@@ -0,0 +1,86 @@
+package ci
+
+import (
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"github.com/srevinsaju/togomak/v1/internal/rules"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const explainTestPipeline = `
+togomak {
+  version = 2
+}
+
+stage "build" {
+  script = "true"
+}
+
+stage "test" {
+  depends_on = [stage.build]
+  script     = "true"
+  lifecycle {
+    phase   = ["test"]
+    timeout = 0
+  }
+}
+`
+
+func explainSteps(e *Explanation, step string) []string {
+	var details []string
+	for _, s := range e.Steps {
+		if s.Step == step {
+			details = append(details, s.Detail)
+		}
+	}
+	return details
+}
+
+func TestExplain(t *testing.T) {
+	conductor, pipe, g := readTestPipeline(t, explainTestPipeline)
+
+	e, diags := Explain(conductor, pipe, g, "stage.build")
+	assert.False(t, diags.HasErrors())
+	assert.True(t, e.Run)
+	assert.Equal(t, []string{"the condition evaluated to true"}, explainSteps(e, ExplainCondition))
+	assert.Contains(t, explainSteps(e, ExplainFilter), `filter "default" matches the "default" phase`)
+
+	e, diags = Explain(conductor, pipe, g, "stage.test")
+	assert.False(t, diags.HasErrors())
+	assert.False(t, e.Run)
+	assert.Equal(t, ReasonNotSelected, e.Reason)
+	assert.Equal(t, []string{"has the phases [test]"}, explainSteps(e, ExplainPhase))
+	assert.Contains(t, explainSteps(e, ExplainFilter), "no filter matches the runnable or its phases")
+
+	conductor.Config.Pipeline.Filtered = rules.Operations{
+		rules.NewOperation(rules.OperationTypeAnd, "test"),
+		rules.NewOperation(rules.OperationTypeSub, "stage.build"),
+	}
+	e, diags = Explain(conductor, pipe, g, "stage.test")
+	assert.False(t, diags.HasErrors())
+	assert.True(t, e.Run)
+	assert.Equal(t, []string{"test", "^stage.build"}, e.Filters)
+	assert.Contains(t, explainSteps(e, ExplainFilter), `filter "test" matches the phase test`)
+
+	e, diags = Explain(conductor, pipe, g, "stage.build")
+	assert.False(t, diags.HasErrors())
+	assert.False(t, e.Run)
+	assert.True(t, e.Overridden)
+	assert.Equal(t, ReasonExcluded, e.Reason)
+	assert.Contains(t, explainSteps(e, ExplainFilter), `filter "^stage.build" removes the runnable`)
+
+	_, diags = Explain(conductor, pipe, g, meta.RootStage)
+	assert.True(t, diags.HasErrors())
+}
+
+func TestExplain_Query(t *testing.T) {
+	conductor, pipe, g := readTestPipeline(t, explainTestPipeline)
+	queries, diags := NewSlice([]string{`id == "build"`})
+	assert.False(t, diags.HasErrors())
+	conductor.Config.Pipeline.FilterQuery = queries
+
+	e, diags := Explain(conductor, pipe, g, "stage.test")
+	assert.False(t, diags.HasErrors())
+	assert.False(t, e.Run)
+	assert.Equal(t, []string{`query "id == \"build\"" evaluated to false`}, explainSteps(e, ExplainQuery))
+}
@@ -40,6 +40,27 @@ func GraphResolve(ctx context.Context, pipe *Pipeline, g *depgraph.Graph, v []hc
 	}
 	return diags
 }
+
+// ExpandGraph expands the imports and the locals of pipe, and creates its dependency
+// graph, for the commands which inspect a pipeline without running it
+func ExpandGraph(conductor *Conductor, pipe *Pipeline) (*Pipeline, *depgraph.Graph, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	pipe, d := ExpandImports(conductor, pipe, conductor.Config.Paths)
+	diags = diags.Extend(d)
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+	pipe.Local, d = pipe.Locals.Expand()
+	diags = diags.Extend(d)
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+
+	depGraph, d := GraphTopoSort(conductor, pipe)
+	diags = diags.Extend(d)
+	return pipe, depGraph, diags
+}
+
 func GraphTopoSort(conductor *Conductor, pipe *Pipeline) (*depgraph.Graph, hcl.Diagnostics) {
 	g := depgraph.New()
 	var diags hcl.Diagnostics
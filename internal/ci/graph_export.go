@@ -0,0 +1,259 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"sort"
+	"strings"
+)
+
+// GraphOptions configures which runnables of the dependency graph are exported, and how
+type GraphOptions struct {
+	// HideInternal hides togomak.root, togomak.pre and togomak.post when they are
+	// not defined in the pipeline, and the locals
+	HideInternal bool
+
+	// GroupByPhase groups the stages and modules by their lifecycle phases
+	GroupByPhase bool
+}
+
+// GraphNode is a runnable in the dependency graph of a pipeline
+type GraphNode struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+
+	// Phases are the lifecycle phases of a stage or a module
+	Phases []string `json:"phases,omitempty"`
+
+	// Selected is true if the filters and the queries select the runnable
+	Selected bool `json:"selected"`
+
+	// DependsOn are the runnables the runnable waits for. Dependencies which are
+	// implied by another dependency are left out, and so are hidden runnables,
+	// in which case the runnable depends on their dependencies instead.
+	DependsOn []string `json:"depends_on"`
+}
+
+// PipelineGraph is the dependency graph of a pipeline, written by togomak graph
+type PipelineGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+
+	// Layers are the runnables in the order they can be run, the runnables of
+	// a layer only depend on the runnables of the layers before it
+	Layers [][]string `json:"layers"`
+
+	opts GraphOptions
+}
+
+// NewPipelineGraph creates the graph of pipe from depGraph, the graph created by GraphTopoSort
+func NewPipelineGraph(conductor *Conductor, pipe *Pipeline, depGraph *depgraph.Graph, opts GraphOptions) (PipelineGraph, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	graph := PipelineGraph{Nodes: []GraphNode{}, Layers: [][]string{}, opts: opts}
+	visible := make(map[string]bool)
+	for _, layer := range depGraph.TopoSortedLayers() {
+		var ids []string
+		for _, id := range layer {
+			node, hidden, d := graphNode(conductor, pipe, depGraph, id, opts)
+			diags = diags.Extend(d)
+			if hidden {
+				continue
+			}
+			visible[id] = true
+			ids = append(ids, id)
+			graph.Nodes = append(graph.Nodes, node)
+		}
+		if len(ids) > 0 {
+			sort.Strings(ids)
+			graph.Layers = append(graph.Layers, ids)
+		}
+	}
+
+	for i := range graph.Nodes {
+		graph.Nodes[i].DependsOn = graphDependencies(depGraph, graph.Nodes[i].Id, visible)
+	}
+	return graph, diags
+}
+
+// graphNode describes the runnable identified by id, hidden is true if it is left out of the graph
+func graphNode(conductor *Conductor, pipe *Pipeline, depGraph *depgraph.Graph, id string, opts GraphOptions) (node GraphNode, hidden bool, diags hcl.Diagnostics) {
+	blockType, _, _ := strings.Cut(id, ".")
+	node = GraphNode{Id: id, Type: blockType, Selected: true}
+
+	runnable, skip, d := pipe.Resolve(id)
+	if d.HasErrors() {
+		return node, false, diags.Extend(d)
+	}
+	if skip || runnable == nil {
+		// togomak.root, and togomak.pre and togomak.post when they are not defined
+		return node, opts.HideInternal, diags
+	}
+	if blockType == LocalBlock && opts.HideInternal {
+		return node, true, diags
+	}
+	if runnable.Type() != blocks.StageBlock && runnable.Type() != blocks.ModuleBlock {
+		return node, false, diags
+	}
+
+	node.Phases = blockPhases(conductor, runnable)
+	node.Selected, _, d = blockFiltered(runnable, conductor, id, depGraph, true, nil)
+	for _, diag := range d {
+		// the runnable is shown as not selected when its phases cannot be evaluated
+		diag.Severity = hcl.DiagWarning
+	}
+	return node, false, diags.Extend(d)
+}
+
+// graphDependencies returns the visible runnables id depends on, without the
+// ones which another of these runnables already depends on
+func graphDependencies(depGraph *depgraph.Graph, id string, visible map[string]bool) []string {
+	var candidates []string
+	for dependency := range depGraph.Dependencies(id) {
+		if visible[dependency] {
+			candidates = append(candidates, dependency)
+		}
+	}
+
+	dependencies := []string{}
+	for _, dependency := range candidates {
+		implied := false
+		for _, other := range candidates {
+			if other != dependency && depGraph.DependsOn(other, dependency) {
+				implied = true
+				break
+			}
+		}
+		if !implied {
+			dependencies = append(dependencies, dependency)
+		}
+	}
+	sort.Strings(dependencies)
+	return dependencies
+}
+
+// groups returns the nodes grouped by their lifecycle phases, along with the names of
+// the groups in order. Nodes which are not stages or modules are in the group named "".
+func (g PipelineGraph) groups() ([]string, map[string][]GraphNode) {
+	groups := make(map[string][]GraphNode)
+	var names []string
+	for _, node := range g.Nodes {
+		name := ""
+		if g.opts.GroupByPhase {
+			name = strings.Join(node.Phases, ", ")
+		}
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], node)
+	}
+	sort.Strings(names)
+	return names, groups
+}
+
+// dotShapes are the Graphviz shapes of the types of runnables
+var dotShapes = map[string]string{
+	blocks.StageBlock:  "box",
+	blocks.ModuleBlock: "box3d",
+	blocks.MacroBlock:  "component",
+	meta.AppName:       "diamond",
+}
+
+// DOT returns the graph in the Graphviz DOT language. Edges point from a
+// runnable to the runnables which wait for it.
+func (g PipelineGraph) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", meta.AppName)
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [fontname=\"sans-serif\", shape=ellipse];\n")
+
+	names, groups := g.groups()
+	for i, name := range names {
+		indent := "  "
+		if name != "" {
+			fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+			fmt.Fprintf(&b, "    label=%q;\n", "phase: "+name)
+			indent = "    "
+		}
+		for _, node := range groups[name] {
+			attrs := []string{fmt.Sprintf("label=%q", node.Id)}
+			if shape, ok := dotShapes[node.Type]; ok {
+				attrs = append(attrs, fmt.Sprintf("shape=%s", shape))
+			}
+			if !node.Selected {
+				attrs = append(attrs, "style=dashed", "fontcolor=gray50", "color=gray50")
+			} else if node.Type == blocks.StageBlock || node.Type == blocks.ModuleBlock {
+				attrs = append(attrs, "style=\"filled,bold\"", "fillcolor=lightblue")
+			}
+			fmt.Fprintf(&b, "%s%q [%s];\n", indent, node.Id, strings.Join(attrs, ", "))
+		}
+		if name != "" {
+			b.WriteString("  }\n")
+		}
+	}
+
+	for _, node := range g.Nodes {
+		for _, dependency := range node.DependsOn {
+			fmt.Fprintf(&b, "  %q -> %q;\n", dependency, node.Id)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid returns the graph as a Mermaid flowchart. Edges point from a
+// runnable to the runnables which wait for it.
+func (g PipelineGraph) Mermaid() string {
+	// identifiers such as data.env.home are not valid Mermaid node identifiers
+	ids := make(map[string]string)
+	for i, node := range g.Nodes {
+		ids[node.Id] = fmt.Sprintf("n%d", i)
+	}
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	names, groups := g.groups()
+	for i, name := range names {
+		indent := "  "
+		if name != "" {
+			fmt.Fprintf(&b, "  subgraph phase%d [\"phase: %s\"]\n", i, name)
+			indent = "    "
+		}
+		for _, node := range groups[name] {
+			label := fmt.Sprintf("[\"%s\"]", node.Id)
+			switch node.Type {
+			case blocks.StageBlock, blocks.ModuleBlock, blocks.MacroBlock:
+			case meta.AppName:
+				label = fmt.Sprintf("{\"%s\"}", node.Id)
+			default:
+				label = fmt.Sprintf("([\"%s\"])", node.Id)
+			}
+			class := ""
+			if !node.Selected {
+				class = ":::unselected"
+			} else if node.Type == blocks.StageBlock || node.Type == blocks.ModuleBlock {
+				class = ":::selected"
+			}
+			fmt.Fprintf(&b, "%s%s%s%s\n", indent, ids[node.Id], label, class)
+		}
+		if name != "" {
+			b.WriteString("  end\n")
+		}
+	}
+
+	for _, node := range g.Nodes {
+		for _, dependency := range node.DependsOn {
+			fmt.Fprintf(&b, "  %s --> %s\n", ids[dependency], ids[node.Id])
+		}
+	}
+	b.WriteString("  classDef selected fill:#add8e6,stroke-width:2px\n")
+	b.WriteString("  classDef unselected stroke-dasharray:5 5,color:#7f7f7f\n")
+	return b.String()
+}
+
+// JSON returns the graph as JSON
+func (g PipelineGraph) JSON() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
@@ -0,0 +1,76 @@
+package ci
+
+import (
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"github.com/srevinsaju/togomak/v1/internal/rules"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const graphTestPipeline = `
+togomak {
+  version = 2
+}
+
+locals {
+  version = "1.0.0"
+}
+
+stage "build" {
+  script = "echo ${local.version}"
+}
+
+stage "test" {
+  depends_on = [stage.build]
+  script     = "true"
+  lifecycle {
+    phase = ["test"]
+  }
+}
+
+stage "deploy" {
+  depends_on = [stage.test, stage.build]
+  script     = "true"
+  lifecycle {
+    phase = ["deploy"]
+  }
+}
+`
+
+func TestNewPipelineGraph(t *testing.T) {
+	conductor, pipe, g := readTestPipeline(t, graphTestPipeline)
+	conductor.Config.Pipeline.Filtered = rules.Operations{rules.NewOperation(rules.OperationTypeAnd, "test")}
+
+	graph, diags := NewPipelineGraph(conductor, pipe, g, GraphOptions{})
+	assert.False(t, diags.HasErrors())
+	assert.Len(t, graph.Nodes, 7)
+
+	nodes := make(map[string]GraphNode)
+	for _, node := range graph.Nodes {
+		nodes[node.Id] = node
+	}
+	assert.Equal(t, []string{"default"}, nodes["stage.build"].Phases)
+	assert.False(t, nodes["stage.build"].Selected)
+	assert.True(t, nodes["stage.test"].Selected)
+	assert.False(t, nodes["stage.deploy"].Selected)
+
+	// stage.deploy depends on stage.build through stage.test
+	assert.Equal(t, []string{"stage.test"}, nodes["stage.deploy"].DependsOn)
+	assert.Equal(t, []string{"local.version", meta.PreStage}, nodes["stage.build"].DependsOn)
+
+	graph, diags = NewPipelineGraph(conductor, pipe, g, GraphOptions{HideInternal: true, GroupByPhase: true})
+	assert.False(t, diags.HasErrors())
+	assert.Len(t, graph.Nodes, 3)
+	assert.Equal(t, [][]string{{"stage.build"}, {"stage.test"}, {"stage.deploy"}}, graph.Layers)
+	assert.Empty(t, graph.Nodes[0].DependsOn)
+
+	dot := graph.DOT()
+	assert.Contains(t, dot, `label="phase: test";`)
+	assert.Contains(t, dot, `"stage.build" -> "stage.test";`)
+	assert.NotContains(t, dot, "local.version")
+
+	mermaid := graph.Mermaid()
+	assert.Contains(t, mermaid, `n1["stage.test"]:::selected`)
+	assert.Contains(t, mermaid, `n0["stage.build"]:::unselected`)
+	assert.Contains(t, mermaid, "n1 --> n2")
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/sirupsen/logrus"
 	"github.com/srevinsaju/togomak/v1/internal/dg"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
 	"github.com/srevinsaju/togomak/v1/internal/ui"
 	"github.com/srevinsaju/togomak/v1/internal/x"
 	"os"
@@ -20,21 +21,40 @@ type Tracker struct {
 	runnablesMu sync.Mutex
 	runnablesWg sync.WaitGroup
 
-	daemons   Blocks
-	daemonsMu sync.Mutex
-	daemonsWg sync.WaitGroup
+	daemons        Blocks
+	daemonsMu      sync.Mutex
+	daemonsWg      sync.WaitGroup
+	daemonsStopped map[Block]chan struct{}
 
 	completed       Blocks
 	completedMu     sync.Mutex
 	completedSignal chan Block
 
+	started   []*TrackedRunnable
+	startedMu sync.Mutex
+
 	killSignal      chan os.Signal
 	interruptSignal chan os.Signal
 }
 
+// TrackedRunnable is a runnable which was started by the Tracker
+type TrackedRunnable struct {
+	Block  Block
+	Daemon bool
+
+	Started time.Time
+
+	// Attempts is the number of the attempt which is running, including retries
+	Attempts int
+
+	// Done is true once the runnable has finished running, or the daemon has stopped
+	Done bool
+}
+
 func NewTracker() *Tracker {
 	return &Tracker{
 		completedSignal: make(chan Block, 1),
+		daemonsStopped:  make(map[Block]chan struct{}),
 
 		killSignal:      make(chan os.Signal, 1),
 		interruptSignal: make(chan os.Signal, 1),
@@ -46,13 +66,15 @@ func (t *Tracker) AppendRunnable(runnable Block) {
 	t.runnablesMu.Lock()
 	defer t.runnablesMu.Unlock()
 	t.runnables = append(t.runnables, runnable)
+	t.track(runnable, false)
 }
 
 func (t *Tracker) RunnableWait() {
 	t.runnablesWg.Wait()
 }
 
-func (t *Tracker) RunnableDone() {
+func (t *Tracker) RunnableDone(runnable Block) {
+	t.update(runnable, func(r *TrackedRunnable) { r.Done = true })
 	t.runnablesWg.Done()
 }
 
@@ -61,25 +83,122 @@ func (t *Tracker) AppendDaemon(daemon Block) {
 	t.daemonsMu.Lock()
 	defer t.daemonsMu.Unlock()
 	t.daemons = append(t.daemons, daemon)
+	t.daemonsStopped[daemon] = make(chan struct{})
+	t.track(daemon, true)
 }
 
 func (t *Tracker) DaemonWait() {
 	t.daemonsWg.Wait()
 }
 
-func (t *Tracker) DaemonDone() {
+func (t *Tracker) DaemonDone(daemon Block) {
+	t.daemonsMu.Lock()
+	close(t.daemonsStopped[daemon])
+	t.daemonsMu.Unlock()
+	t.update(daemon, func(r *TrackedRunnable) { r.Done = true })
 	t.daemonsWg.Done()
 }
 
+func (t *Tracker) track(runnable Block, daemon bool) {
+	t.startedMu.Lock()
+	defer t.startedMu.Unlock()
+	t.started = append(t.started, &TrackedRunnable{
+		Block:    runnable,
+		Daemon:   daemon,
+		Started:  time.Now(),
+		Attempts: 1,
+	})
+}
+
+func (t *Tracker) update(runnable Block, update func(r *TrackedRunnable)) {
+	t.startedMu.Lock()
+	defer t.startedMu.Unlock()
+	for _, r := range t.started {
+		if r.Block == runnable {
+			update(r)
+		}
+	}
+}
+
+// Attempt records that the attempt-th attempt of runnable has started
+func (t *Tracker) Attempt(runnable Block, attempt int) {
+	t.update(runnable, func(r *TrackedRunnable) { r.Attempts = attempt })
+}
+
+// Started returns the runnables which were started, in the order they started
+func (t *Tracker) Started() []TrackedRunnable {
+	t.startedMu.Lock()
+	defer t.startedMu.Unlock()
+	started := make([]TrackedRunnable, 0, len(t.started))
+	for _, r := range t.started {
+		started = append(started, *r)
+	}
+	return started
+}
+
+// Interrupt cancels the pipeline, as if it had received an interrupt signal
+func (t *Tracker) Interrupt() {
+	select {
+	case t.interruptSignal <- os.Interrupt:
+	default:
+	}
+}
+
+// StopDaemons terminates the daemons which are still running, and waits for
+// them to stop, for at most TerminationGracePeriod. Only the daemons identified
+// in ids are stopped, unless ids is nil.
+func (t *Tracker) StopDaemons(conductor *Conductor, ids map[string]bool) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	t.daemonsMu.Lock()
+	var stopped []chan struct{}
+	var daemons Blocks
+	for _, daemon := range t.daemons {
+		select {
+		case <-t.daemonsStopped[daemon]:
+			continue
+		default:
+		}
+		if ids != nil && !ids[x.RenderBlock(daemon.Type(), daemon.Identifier())] {
+			continue
+		}
+		daemons = append(daemons, daemon)
+		stopped = append(stopped, t.daemonsStopped[daemon])
+	}
+	t.daemonsMu.Unlock()
+
+	for _, daemon := range daemons {
+		diags = diags.Extend(daemon.Terminate(conductor, true))
+	}
+	deadline := time.NewTimer(TerminationGracePeriod)
+	defer deadline.Stop()
+	for i, daemon := range daemons {
+		select {
+		case <-stopped[i]:
+		case <-deadline.C:
+			return diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "failed to stop daemon",
+				Detail:   fmt.Sprintf("%s did not stop within %s", daemon.Identifier(), TerminationGracePeriod),
+			})
+		}
+	}
+	return diags
+}
+
 func (t *Tracker) HasDaemons() bool {
 	return len(t.daemons) > 0
 }
 
-func (t *Tracker) AppendCompleted(completed Block) {
+// AppendCompleted records that completed has completed, and signals the watchdog
+// of the daemons, unless done is closed since the watchdog has stopped
+func (t *Tracker) AppendCompleted(completed Block, done <-chan struct{}) {
 	t.completedMu.Lock()
 	defer t.completedMu.Unlock()
 	t.completed = append(t.completed, completed)
-	t.completedSignal <- completed
+	select {
+	case t.completedSignal <- completed:
+	case <-done:
+	}
 }
 
 type Handler struct {
@@ -88,10 +207,19 @@ type Handler struct {
 	Logger  logrus.Ext1FieldLogger
 	Process *HandlerProcess
 
+	// conductor is passed to the runnables the handler terminates
+	conductor *Conductor
+
 	diagWriter hcl.DiagnosticWriter
 	ctxMu      sync.RWMutex
 	ctx        context.Context
 	cancel     context.CancelFunc
+
+	signalsMu      sync.Mutex
+	signalsIgnored bool
+
+	// watchdogDone is closed when the watchdog of the daemons has stopped
+	watchdogDone chan struct{}
 }
 
 func (h *Handler) Context() context.Context {
@@ -126,6 +254,12 @@ func WithDiagnostics(diagnostics *dg.SafeDiagnostics) HandlerOption {
 	}
 }
 
+func WithConductor(conductor *Conductor) HandlerOption {
+	return func(h *Handler) {
+		h.conductor = conductor
+	}
+}
+
 func WithDiagnosticWriter(diagnosticWriter hcl.DiagnosticWriter) HandlerOption {
 	return func(h *Handler) {
 		h.diagWriter = diagnosticWriter
@@ -163,9 +297,11 @@ func NewHandler(opts ...HandlerOption) *Handler {
 		Logger:  logrus.New(),
 		Process: NewHandlerProcess(),
 
-		diagWriter: hcl.NewDiagnosticTextWriter(os.Stdout, nil, 0, true),
+		diagWriter: hcl.NewDiagnosticTextWriter(logging.Secrets().Writer(os.Stdout), nil, 0, true),
 		ctx:        ctx,
 		cancel:     cancel,
+
+		watchdogDone: make(chan struct{}),
 	}
 
 	h = h.Update(opts...)
@@ -183,12 +319,37 @@ func (h *Handler) Ptr() *Handler {
 	return h
 }
 
+// Completed records that runnable has completed, the watchdog of the daemons is
+// only signalled while the run is not over
+func (h *Handler) Completed(runnable Block) {
+	h.Tracker.AppendCompleted(runnable, h.watchdogDone)
+}
+
+// IgnoreSignals stops handling the interrupt and kill signals, so that they can
+// be handled by the caller once the pipeline has run
+func (h *Handler) IgnoreSignals() {
+	h.signalsMu.Lock()
+	defer h.signalsMu.Unlock()
+	h.signalsIgnored = true
+	signal.Stop(h.Tracker.interruptSignal)
+	signal.Stop(h.Tracker.killSignal)
+}
+
+func (h *Handler) ignoresSignals() bool {
+	h.signalsMu.Lock()
+	defer h.signalsMu.Unlock()
+	return h.signalsIgnored
+}
+
 func (h *Handler) Kill() {
 	signal.Notify(h.Tracker.killSignal, os.Kill)
 	ctx := h.Context()
 	logger := h.Logger.WithField("orchestra", "watchdog")
 	select {
 	case <-h.Tracker.killSignal:
+		if h.ignoresSignals() {
+			return
+		}
 		var diags hcl.Diagnostics
 		logger.Warn("received kill signal, killing all subprocesses")
 		logger.Warn("stopping running operations...")
@@ -206,7 +367,7 @@ func (h *Handler) Kill() {
 			Detail:   "data loss may have occurred",
 		})
 		if diags.HasErrors() {
-			writer := hcl.NewDiagnosticTextWriter(os.Stderr, nil, 78, true)
+			writer := hcl.NewDiagnosticTextWriter(logging.Secrets().Writer(os.Stderr), nil, 78, true)
 			_ = writer.WriteDiagnostics(diags)
 		}
 		os.Exit(h.Fatal())
@@ -223,9 +384,16 @@ func (h *Handler) Daemons() {
 	defer h.WriteDiagnostics()
 	logger.Tracef("starting watchdog")
 
-	// execute the following function when we receive any message on the completed channel
+	// execute the following function when we receive any message on the completed channel,
+	// until the run is over
+	defer close(h.watchdogDone)
 	for {
-		c := <-h.Tracker.completedSignal
+		var c Block
+		select {
+		case c = <-h.Tracker.completedSignal:
+		case <-h.Context().Done():
+			return
+		}
 		logger.Debugf("received completed runnable, %s", c.Identifier())
 		completedRunnables = append(completedRunnables, c)
 
@@ -238,13 +406,17 @@ func (h *Handler) Daemons() {
 			lifecycle, d := daemon.ExecutionOptions(h.Context())
 			if d.HasErrors() {
 				h.Diags.Extend(d)
-				d := daemon.Terminate(nil, false)
+				d := daemon.Terminate(h.conductor, false)
 				h.Diags.Extend(d)
 				return
 			}
 			if lifecycle == nil {
 				continue
 			}
+			// in watch mode, the daemons without stop_when_complete run until togomak watch stops them
+			if len(lifecycle.StopWhenComplete) == 0 && h.conductor != nil && h.conductor.Config.Pipeline.Watch {
+				continue
+			}
 
 			allCompleted := true
 			for _, block := range lifecycle.StopWhenComplete {
@@ -263,7 +435,7 @@ func (h *Handler) Daemons() {
 			}
 			if allCompleted {
 				logger.Infof("stopping daemon %s", daemon.Identifier())
-				d := daemon.Terminate(nil, true)
+				d := daemon.Terminate(h.conductor, true)
 				if d.HasErrors() {
 					h.Diags.Extend(d)
 				}
@@ -280,6 +452,9 @@ func (h *Handler) Interrupt() {
 	logger := h.Logger.WithField("orchestra", "watchdog")
 	select {
 	case <-h.Tracker.interruptSignal:
+		if h.ignoresSignals() {
+			return
+		}
 		var diags hcl.Diagnostics
 		logger.Warn("received interrupt signal, cancelling the pipeline")
 		logger.Warn("stopping running operations...")
@@ -300,12 +475,12 @@ func (h *Handler) Interrupt() {
 		}()
 		for _, runnable := range h.Tracker.runnables {
 			logger.Debugf("stopping runnable %s", runnable.Identifier())
-			d := runnable.Terminate(nil, false)
+			d := runnable.Terminate(h.conductor, false)
 			diags = diags.Extend(d)
 		}
 
 		if diags.HasErrors() {
-			writer := hcl.NewDiagnosticTextWriter(os.Stderr, nil, 78, true)
+			writer := hcl.NewDiagnosticTextWriter(logging.Secrets().Writer(os.Stderr), nil, 78, true)
 			_ = writer.WriteDiagnostics(diags)
 			os.Exit(h.Fatal())
 		}
@@ -0,0 +1,67 @@
+package ci
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTracker_Started(t *testing.T) {
+	tracker := NewTracker()
+	build := &Stage{Id: "build"}
+	server := &Stage{Id: "server"}
+
+	tracker.AppendRunnable(build)
+	tracker.AppendDaemon(server)
+	tracker.Attempt(build, 2)
+
+	started := tracker.Started()
+	assert.Len(t, started, 2)
+	assert.Equal(t, build, started[0].Block)
+	assert.Equal(t, 2, started[0].Attempts)
+	assert.False(t, started[0].Daemon)
+	assert.False(t, started[0].Done)
+	assert.Equal(t, server, started[1].Block)
+	assert.Equal(t, 1, started[1].Attempts)
+	assert.True(t, started[1].Daemon)
+
+	tracker.RunnableDone(build)
+	tracker.DaemonDone(server)
+	started = tracker.Started()
+	assert.True(t, started[0].Done)
+	assert.True(t, started[1].Done)
+	tracker.RunnableWait()
+	tracker.DaemonWait()
+}
+
+func TestHandler_DaemonsStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewHandler(WithContext(ctx))
+	stopped := make(chan struct{})
+	go func() {
+		h.Daemons()
+		close(stopped)
+	}()
+
+	h.Completed(&Stage{Id: "build"})
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watchdog of the daemons did not stop with the run")
+	}
+
+	// the runnables which complete once the run is over do not wait for the watchdog
+	completed := make(chan struct{})
+	go func() {
+		h.Completed(&Stage{Id: "server"})
+		h.Completed(&Stage{Id: "client"})
+		close(completed)
+	}()
+	select {
+	case <-completed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("completing a runnable blocked once the run was over")
+	}
+}
@@ -28,7 +28,9 @@ func (m *Import) Expand(conductor *Conductor, pwd string, dst string) (*Pipeline
 	}
 	ppb := ui.NewPassiveProgressBar(logger, fmt.Sprintf("pulling %s", m.Identifier()))
 	ppb.Init()
+	span := conductor.Tracer().Start("import", "download", fmt.Sprintf("import %s", m.Identifier()))
 	err = get.Get()
+	span.End()
 	if err != nil {
 		diags = diags.Append(&hcl.Diagnostic{
 			Severity: hcl.DiagError,
@@ -0,0 +1,52 @@
+package ci
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"time"
+)
+
+// TerminationGracePeriod is how long a stage is given to exit after it
+// has been sent SIGTERM on exceeding its lifecycle.timeout, before it is
+// forcefully killed
+const TerminationGracePeriod = 10 * time.Second
+
+// TimeoutDuration evaluates lifecycle.timeout, which is specified in seconds.
+// A zero duration is returned if the lifecycle does not specify a timeout.
+func (l *Lifecycle) TimeoutDuration(conductor *Conductor, evalCtx *hcl.EvalContext) (time.Duration, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	if l == nil || l.Timeout == nil {
+		return 0, diags
+	}
+
+	conductor.Eval().Mutex().RLock()
+	v, d := l.Timeout.Value(evalCtx)
+	conductor.Eval().Mutex().RUnlock()
+	diags = diags.Extend(d)
+	if d.HasErrors() || v.IsNull() {
+		return 0, diags
+	}
+
+	if !v.IsKnown() || v.Type() != cty.Number {
+		return 0, diags.Append(&hcl.Diagnostic{
+			Severity:    hcl.DiagError,
+			Summary:     "invalid timeout",
+			Detail:      fmt.Sprintf("lifecycle.timeout must be a number of seconds, got %s", v.Type().FriendlyName()),
+			Subject:     l.Timeout.Range().Ptr(),
+			EvalContext: evalCtx,
+		})
+	}
+
+	seconds, _ := v.AsBigFloat().Float64()
+	if seconds < 0 {
+		return 0, diags.Append(&hcl.Diagnostic{
+			Severity:    hcl.DiagError,
+			Summary:     "invalid timeout",
+			Detail:      "lifecycle.timeout cannot be negative",
+			Subject:     l.Timeout.Range().Ptr(),
+			EvalContext: evalCtx,
+		})
+	}
+	return time.Duration(seconds * float64(time.Second)), diags
+}
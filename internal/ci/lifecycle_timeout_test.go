@@ -0,0 +1,113 @@
+package ci
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLifecycle_TimeoutDuration(t *testing.T) {
+	conductor := &Conductor{eval: &Eval{context: &hcl.EvalContext{}, mu: &sync.RWMutex{}}}
+
+	var lifecycle *Lifecycle
+	timeout, diags := lifecycle.TimeoutDuration(conductor, nil)
+	assert.False(t, diags.HasErrors())
+	assert.Equal(t, time.Duration(0), timeout)
+
+	lifecycle = &Lifecycle{Timeout: hcl.StaticExpr(cty.NullVal(cty.Number), hcl.Range{})}
+	timeout, diags = lifecycle.TimeoutDuration(conductor, nil)
+	assert.False(t, diags.HasErrors())
+	assert.Equal(t, time.Duration(0), timeout)
+
+	lifecycle = &Lifecycle{Timeout: hcl.StaticExpr(cty.NumberFloatVal(1.5), hcl.Range{})}
+	timeout, diags = lifecycle.TimeoutDuration(conductor, nil)
+	assert.False(t, diags.HasErrors())
+	assert.Equal(t, 1500*time.Millisecond, timeout)
+
+	lifecycle = &Lifecycle{Timeout: hcl.StaticExpr(cty.NumberIntVal(-1), hcl.Range{})}
+	_, diags = lifecycle.TimeoutDuration(conductor, nil)
+	assert.True(t, diags.HasErrors())
+
+	lifecycle = &Lifecycle{Timeout: hcl.StaticExpr(cty.StringVal("10m"), hcl.Range{})}
+	_, diags = lifecycle.TimeoutDuration(conductor, nil)
+	assert.True(t, diags.HasErrors())
+}
+
+func TestLifecycle_Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the processes started by a stage are not stopped on windows")
+	}
+	dir := t.TempDir()
+	writeTestPipeline(t, dir, `
+togomak {
+  version = 2
+}
+
+stage "slow" {
+  script = <<-EOT
+  (sleep 2 && touch outlived) &
+  wait
+  EOT
+
+  lifecycle {
+    timeout = 0.5
+  }
+}
+`)
+
+	started := time.Now()
+	diags := runTestPipeline(t, dir)
+	assert.True(t, diags.HasErrors())
+	assert.Contains(t, diags.Error(), "stage timed out")
+	assert.Less(t, time.Since(started), TerminationGracePeriod)
+
+	// the processes started by the stage are stopped along with it
+	time.Sleep(2500 * time.Millisecond)
+	assert.NoFileExists(t, filepath.Join(dir, "outlived"))
+}
+
+func TestLifecycle_WithoutPhase(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPipeline(t, dir, `
+togomak {
+  version = 2
+}
+
+stage "build" {
+  script = "touch built"
+
+  lifecycle {
+    timeout = 5
+  }
+}
+
+module "deploy" {
+  depends_on = [stage.build]
+  source     = "./deploy"
+
+  lifecycle {}
+}
+`)
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "deploy"), 0755))
+	writeTestPipeline(t, filepath.Join(dir, "deploy"), `
+togomak {
+  version = 2
+}
+
+stage "deploy" {
+  script = "touch deployed"
+}
+`)
+
+	// the stages and modules without a phase run in the default phase
+	diags := runTestPipeline(t, dir)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.FileExists(t, filepath.Join(dir, "built"))
+	assert.FileExists(t, filepath.Join(dir, "deployed"))
+}
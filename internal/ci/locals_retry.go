@@ -18,3 +18,7 @@ func (l *Local) MaxRetryBackoff() int {
 func (l *Local) RetryExponentialBackoff() bool {
 	return false
 }
+
+func (l *Local) FailureAllowed() bool {
+	return false
+}
@@ -30,6 +30,7 @@ func (l *Local) Run(conductor *Conductor, options ...runnable.Option) (diags hcl
 	conductor.Eval().Mutex().RUnlock()
 
 	diags = diags.Extend(d)
+	recordSensitiveValues(v)
 	localMutated[l.Key] = v
 
 	conductor.Eval().Mutex().Lock()
@@ -18,3 +18,7 @@ func (m *Macro) MaxRetryBackoff() int {
 func (m *Macro) RetryExponentialBackoff() bool {
 	return false
 }
+
+func (m *Macro) FailureAllowed() bool {
+	return false
+}
@@ -8,5 +8,16 @@ func (m *Module) Variables() []hcl.Traversal {
 	vars = append(vars, m.DependsOn.Variables()...)
 	vars = append(vars, m.Condition.Variables()...)
 	vars = append(vars, m.ForEach.Variables()...)
+	if m.Lifecycle != nil {
+		vars = append(vars, m.Lifecycle.Variables()...)
+	}
+
+	// the attributes are passed as variables to the module
+	if m.Body != nil {
+		attrs, _ := m.Body.JustAttributes()
+		for _, attr := range attrs {
+			vars = append(vars, attr.Expr.Variables()...)
+		}
+	}
 	return vars
 }
@@ -21,3 +21,7 @@ func (m *Module) MaxRetryBackoff() int {
 func (m *Module) RetryExponentialBackoff() bool {
 	return m.Retry.ExponentialBackoff
 }
+
+func (m *Module) FailureAllowed() bool {
+	return false
+}
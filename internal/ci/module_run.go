@@ -1,6 +1,8 @@
 package ci
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/go-getter"
 	"github.com/hashicorp/hcl/v2"
@@ -9,6 +11,7 @@ import (
 	"github.com/srevinsaju/togomak/v1/internal/dg"
 	"github.com/srevinsaju/togomak/v1/internal/meta"
 	"github.com/srevinsaju/togomak/v1/internal/path"
+	"github.com/srevinsaju/togomak/v1/internal/pool"
 	"github.com/srevinsaju/togomak/v1/internal/runnable"
 	"github.com/srevinsaju/togomak/v1/internal/ui"
 	"github.com/srevinsaju/togomak/v1/internal/x"
@@ -36,32 +39,10 @@ func (m *Module) Prepare(conductor *Conductor, skip bool, overridden bool) hcl.D
 
 func (m *Module) Run(conductor *Conductor, options ...runnable.Option) (diags hcl.Diagnostics) {
 	cfg := runnable.NewConfig(options...)
-	evalCtx := conductor.Eval().Context()
-	evalCtx = evalCtx.NewChild()
-
-	evalCtx.Variables = map[string]cty.Value{
-		"this": cty.ObjectVal(map[string]cty.Value{
-			"id":     cty.StringVal(m.Id),
-			"status": cty.StringVal(string(cfg.Status.Status)),
-		}),
-	}
-
-	conductor.Eval().Mutex().RLock()
-	source, d := m.Source.Value(evalCtx)
-	conductor.Eval().Mutex().RUnlock()
+	src, evalCtx, d := m.source(conductor, options...)
 	if d.HasErrors() {
 		return diags.Extend(d)
 	}
-	if source.Type() != cty.String {
-		return diags.Append(&hcl.Diagnostic{
-			Severity:    hcl.DiagError,
-			Summary:     "source must be a string",
-			Detail:      fmt.Sprintf("source must be a string, got %s", source.Type().FriendlyName()),
-			Subject:     m.Source.Range().Ptr(),
-			EvalContext: evalCtx,
-		})
-	}
-	src := source.AsString()
 
 	if m.ForEach == nil {
 		d = m.run(conductor, src, evalCtx, options...)
@@ -84,6 +65,9 @@ func (m *Module) Run(conductor *Conductor, options ...runnable.Option) (diags hc
 		return diags
 	}
 
+	if diag := sensitiveForEach(m.ForEach, forEachItems); diag != nil {
+		return diags.Append(diag)
+	}
 	if !forEachItems.CanIterateElements() {
 		diags = diags.Append(&hcl.Diagnostic{
 			Severity: hcl.DiagError,
@@ -97,6 +81,10 @@ func (m *Module) Run(conductor *Conductor, options ...runnable.Option) (diags hc
 
 	var safeDg dg.SafeDiagnostics
 
+	// stages within the modules are limited by the conductor's pool, this
+	// prevents spawning a module instance for every element upfront
+	instances := pool.New(conductor.Pool().Size())
+
 	var counter int
 	forEachItems.ForEachElement(func(k cty.Value, v cty.Value) bool {
 		var key string
@@ -123,7 +111,9 @@ func (m *Module) Run(conductor *Conductor, options ...runnable.Option) (diags hc
 			Daemon:    m.Daemon,
 			Body:      m.Body,
 		}
+		instances.Acquire()
 		go func(keyCty cty.Value, options ...runnable.Option) {
+			defer instances.Release()
 			options = append(options, runnable.WithEach(keyCty, v))
 			d := module.Run(conductor, options...)
 			safeDg.Extend(d)
@@ -139,8 +129,79 @@ func (m *Module) Run(conductor *Conductor, options ...runnable.Option) (diags hc
 	return diags
 }
 
+// source evaluates the source of the module, along with the evaluation context of the module
+func (m *Module) source(conductor *Conductor, options ...runnable.Option) (string, *hcl.EvalContext, hcl.Diagnostics) {
+	cfg := runnable.NewConfig(options...)
+	evalCtx := conductor.Eval().Context()
+	evalCtx = evalCtx.NewChild()
+
+	evalCtx.Variables = map[string]cty.Value{
+		"this": cty.ObjectVal(map[string]cty.Value{
+			"id":     cty.StringVal(m.Id),
+			"status": cty.StringVal(string(cfg.Status.Status)),
+		}),
+	}
+
+	conductor.Eval().Mutex().RLock()
+	source, diags := m.Source.Value(evalCtx)
+	conductor.Eval().Mutex().RUnlock()
+	if diags.HasErrors() {
+		return "", evalCtx, diags
+	}
+	if source.Type() != cty.String {
+		return "", evalCtx, diags.Append(&hcl.Diagnostic{
+			Severity:    hcl.DiagError,
+			Summary:     "source must be a string",
+			Detail:      fmt.Sprintf("source must be a string, got %s", source.Type().FriendlyName()),
+			Subject:     m.Source.Range().Ptr(),
+			EvalContext: evalCtx,
+		})
+	}
+	return source.AsString(), evalCtx, diags
+}
+
 func (m *Module) run(conductor *Conductor, source string, evalCtx *hcl.EvalContext, options ...runnable.Option) hcl.Diagnostics {
 	var diags hcl.Diagnostics
+
+	// the stages of the module are stopped once the module exceeds its lifecycle.timeout,
+	// or when the module it is part of exceeds its own
+	ctx := conductor.Context()
+	timeout, d := m.Lifecycle.TimeoutDuration(conductor, evalCtx)
+	diags = diags.Extend(d)
+	if diags.HasErrors() {
+		return diags
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	childConductor, pipe, d := m.child(conductor, ctx, source, evalCtx, options...)
+	diags = diags.Extend(d)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	//  safe diagnostics
+	_, sd := pipe.Run(childConductor)
+
+	diags = diags.Extend(sd.Diagnostics())
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("module timed out (%s)", m.Identifier()),
+			Detail:   "the module did not complete within its lifecycle.timeout and was stopped",
+		})
+	}
+	return diags
+}
+
+// child downloads the source of the module, and reads the pipeline of the module
+// along with the conductor it is run with. The stages of the module are stopped
+// once ctx is done.
+func (m *Module) child(conductor *Conductor, ctx context.Context, source string, evalCtx *hcl.EvalContext, options ...runnable.Option) (*Conductor, *Pipeline, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
 	logger := conductor.Logger().WithField("module", m.Id)
 	cfg := runnable.NewConfig(options...)
 
@@ -152,9 +213,11 @@ func (m *Module) run(conductor *Conductor, source string, evalCtx *hcl.EvalConte
 		Pwd: paths.Module,
 		Dir: true,
 	}
+	span := conductor.Tracer().Start(x.RenderBlock(blocks.ModuleBlock, m.Id), "download", fmt.Sprintf("download %s", source))
 	err := get.Get()
+	span.End()
 	if err != nil {
-		return diags.Append(&hcl.Diagnostic{
+		return nil, nil, diags.Append(&hcl.Diagnostic{
 			Severity:    hcl.DiagError,
 			Summary:     "failed to download source",
 			Detail:      err.Error(),
@@ -172,14 +235,17 @@ func (m *Module) run(conductor *Conductor, source string, evalCtx *hcl.EvalConte
 		lifecyclePhases, d := m.Lifecycle.Phase.Value(evalCtx)
 		conductor.Eval().Mutex().RUnlock()
 		diags = diags.Extend(d)
-		for _, phase := range lifecyclePhases.AsValueSlice() {
-			parentLifecycles = append(parentLifecycles, phase.AsString())
+		if !lifecyclePhases.IsNull() {
+			for _, phase := range lifecyclePhases.AsValueSlice() {
+				parentLifecycles = append(parentLifecycles, phase.AsString())
+			}
 		}
 	}
 
 	b := &behavior.Behavior{
 		Unattended: conductor.Config.Behavior.Unattended,
 		Ci:         conductor.Config.Behavior.Ci,
+		KeepGoing:  conductor.Config.Behavior.KeepGoing,
 		Child: behavior.Child{
 			Enabled:          true,
 			Parent:           "",
@@ -202,7 +268,11 @@ func (m *Module) run(conductor *Conductor, source string, evalCtx *hcl.EvalConte
 		Behavior:  b,
 	}
 	childConductor := conductor.Child(ConductorWithConfig(childCfg))
+
 	var conductorOptions []ConductorOption
+	if _, ok := ctx.Deadline(); ok {
+		conductorOptions = append(conductorOptions, ConductorWithContext(ctx))
+	}
 
 	// send the host conductor's parser to the module conductor
 	// this will make hcl.Diagnostics more descriptive
@@ -222,9 +292,12 @@ func (m *Module) run(conductor *Conductor, source string, evalCtx *hcl.EvalConte
 		} else {
 			expr = hcl.StaticExpr(v, attr.Expr.Range())
 		}
+		// strings passed to a variable which is not a string are parsed, as the
+		// values set on the command line are
 		variable := &Variable{
 			Id:    attr.Name,
 			Value: expr,
+			raw:   true,
 		}
 		conductorOptions = append(conductorOptions, ConductorWithVariable(variable))
 	}
@@ -232,6 +305,16 @@ func (m *Module) run(conductor *Conductor, source string, evalCtx *hcl.EvalConte
 	// update the child conductor's logger with the parent's logger
 	conductorOptions = append(conductorOptions, ConductorWithLogger(logger))
 
+	// the results, spans, metrics and logs of the stages of the module are kept along with those of the parent
+	moduleId := x.RenderBlock(blocks.ModuleBlock, m.Id)
+	conductorOptions = append(conductorOptions,
+		ConductorWithResults(conductor.Results().Module(moduleId)),
+		ConductorWithTracer(conductor.Tracer().Module(moduleId)),
+		ConductorWithMetrics(conductor.Metrics().Module(moduleId)),
+		ConductorWithRunLogs(conductor.RunLogs().Module(moduleId)),
+		ConductorWithModule(conductor.ModuleId(moduleId)),
+	)
+
 	childConductor.Update(conductorOptions...)
 
 	// parse the config file
@@ -248,11 +331,7 @@ func (m *Module) run(conductor *Conductor, source string, evalCtx *hcl.EvalConte
 		evalCtx.Variables[EachBlock] = cty.ObjectVal(cfg.Each)
 	}
 	childConductor.Update(ConductorWithEvalContext(evalCtx))
-	//  safe diagnostics
-	_, sd := pipe.Run(childConductor)
-
-	diags = diags.Extend(sd.Diagnostics())
-	return diags
+	return childConductor, pipe, diags
 }
 
 func (m *Module) CanRun(conductor *Conductor, options ...runnable.Option) (ok bool, diags hcl.Diagnostics) {
@@ -277,6 +356,7 @@ func (m *Module) CanRun(conductor *Conductor, options ...runnable.Option) (ok bo
 	if d.HasErrors() {
 		return false, diags.Extend(d)
 	}
+	v = unmarkSensitive(v)
 
 	if v.Equals(cty.False).True() {
 		// this stage has been explicitly evaluated to false
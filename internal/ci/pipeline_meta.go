@@ -52,6 +52,12 @@ func Merge(pipelines MetaList) (*Pipeline, hcl.Diagnostics) {
 			pipe.Builder.Version = p.pipe.Builder.Version
 			versionDefinedFromFilename = p.filename
 		}
+		if pipe.Builder.Behavior == nil && p.pipe.Builder.Behavior != nil {
+			pipe.Builder.Behavior = p.pipe.Builder.Behavior
+		}
+		if pipe.Builder.Cache == nil && p.pipe.Builder.Cache != nil {
+			pipe.Builder.Cache = p.pipe.Builder.Cache
+		}
 		if p.pipe.Builder.Version != pipe.Builder.Version && p.pipe.Builder.Version != 0 {
 			// when overriding and using multiple pipelines, the version of the togomak pipeline schema is
 			// required to be the same
@@ -3,6 +3,7 @@ package ci
 import (
 	"github.com/hashicorp/go-envparse"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/global"
 	"github.com/srevinsaju/togomak/v1/internal/meta"
 	"github.com/srevinsaju/togomak/v1/internal/x"
 	"github.com/zclconf/go-cty/cty"
@@ -15,6 +16,10 @@ func ExpandOutputs(conductor *Conductor) hcl.Diagnostics {
 	logger := conductor.Logger().WithField("orchestra", "outputs")
 	togomakEnvFile := filepath.Join(conductor.Process.TempDir, meta.OutputEnvFile)
 	logger.Tracef("%s will be stored and exported here: %s", meta.OutputEnvVar, togomakEnvFile)
+	// the stages write to a file of their own, which is appended to this one
+	// once they have finished, see publishOutputs
+	global.OutputsFileMutex.Lock()
+	defer global.OutputsFileMutex.Unlock()
 	envFile, err := os.OpenFile(togomakEnvFile, os.O_RDONLY|os.O_CREATE, 0644)
 	if err == nil {
 		e, err := envparse.Parse(envFile)
@@ -0,0 +1,47 @@
+package ci
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandOutputs_WhileStagesWrite(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPipeline(t, dir, `
+togomak {
+  version = 2
+}
+
+stage "writer" {
+  script = <<-EOT
+  printf 'RELEASE="v1' >> $TOGOMAK_OUTPUTS
+  sleep 1
+  printf '.2"\n' >> $TOGOMAK_OUTPUTS
+  EOT
+}
+
+stage "quick" {
+  script = "true"
+}
+
+stage "next" {
+  depends_on = [stage.quick]
+  script     = "true"
+}
+
+stage "release" {
+  depends_on = [stage.writer]
+  script     = "echo ${output.RELEASE} > release"
+}
+`)
+
+	// the outputs are expanded when stage.next is ready, while stage.writer has
+	// only written a part of its output
+	diags := runTestPipeline(t, dir)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	release, err := os.ReadFile(filepath.Join(dir, "release"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.2\n", string(release))
+}
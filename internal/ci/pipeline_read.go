@@ -62,6 +62,10 @@ func ReadDirFromPath(conductor *Conductor, dir string) (*Pipeline, hcl.Diagnosti
 			// we will not process .lock.hcl files
 			continue
 		}
+		if strings.HasSuffix(file.Name(), meta.VarFileSuffix) {
+			// the values of variables are read by ReadVariableFiles
+			continue
+		}
 
 		f, d := conductor.Parser.ParseHCLFile(filepath.Join(dir, file.Name()))
 		diags = diags.Extend(d)
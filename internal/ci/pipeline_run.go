@@ -3,19 +3,32 @@ package ci
 import (
 	"context"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
 	"github.com/srevinsaju/togomak/v1/internal/c"
+	"github.com/srevinsaju/togomak/v1/internal/cache"
 	"github.com/srevinsaju/togomak/v1/internal/dg"
+	"github.com/srevinsaju/togomak/v1/internal/pool"
 	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/srevinsaju/togomak/v1/internal/x"
+	"github.com/zclconf/go-cty/cty"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 func StartHandlers(conductor *Conductor) *Handler {
 
-	h := NewHandler(
+	opts := []HandlerOption{
 		WithContext(conductor.Context()),
 		WithLogger(conductor.RootLogger),
+		WithConductor(conductor),
 		WithDiagnosticWriter(conductor.DiagWriter),
 		WithProcessBootTime(conductor.Process.BootTime),
-	)
+	}
+	if conductor.Tracker() != nil {
+		opts = append(opts, WithTracker(conductor.Tracker()))
+	}
+	h := NewHandler(opts...)
 	go h.Interrupt()
 	go h.Kill()
 	go h.Daemons()
@@ -26,7 +39,10 @@ func (pipe *Pipeline) Run(conductor *Conductor) (*Handler, dg.AbstractDiagnostic
 	var d hcl.Diagnostics
 	logger := conductor.Logger().WithField("orchestra", "run")
 	cfg := conductor.Config
-	ctx, cancel := context.WithCancel(conductor.Context())
+	parent := conductor.Context()
+	ctx, cancel := context.WithCancel(parent)
+	// the handlers stop once the run is over
+	conductor.Update(ConductorWithContext(ctx))
 	h := StartHandlers(conductor)
 
 	defer cancel()
@@ -38,6 +54,11 @@ func (pipe *Pipeline) Run(conductor *Conductor) (*Handler, dg.AbstractDiagnostic
 	if h.Diags.HasErrors() {
 		return h, h.Diags
 	}
+	// the modules are given the variable files of the root pipeline too, only
+	// the root pipeline is expected to declare their variables
+	if conductor.Parent() == nil {
+		h.Diags.Extend(undeclaredVariables(conductor, pipe))
+	}
 
 	/// we will first expand all local blocks
 	logger.Debugf("expanding local blocks")
@@ -52,6 +73,10 @@ func (pipe *Pipeline) Run(conductor *Conductor) (*Handler, dg.AbstractDiagnostic
 	ctx = context.WithValue(ctx, c.TogomakContextPipeline, pipe)
 	h = h.Update(WithContext(ctx))
 	conductor.Update(ConductorWithContext(ctx))
+	if cfg.Pipeline.Watch {
+		// in watch mode, the daemons keep running once the run is over, until togomak watch stops them
+		conductor.Update(ConductorWithDaemonContext(context.WithValue(parent, c.TogomakContextPipeline, pipe)))
+	}
 
 	// --> generate a dependency graph
 	// we will now generate a dependency graph from the pipeline
@@ -63,38 +88,167 @@ func (pipe *Pipeline) Run(conductor *Conductor) (*Handler, dg.AbstractDiagnostic
 		return h, h.Diags
 	}
 
+	// the identifiers of the stages, and of the stages of the modules, are aligned
+	// on the longest one, when their output is prefixed with them
+	if conductor.Parent() == nil && conductor.Output().mode != OutputStream {
+		conductor.Output().Align(pipe.outputIds(conductor, cfg.Paths.Module, ""))
+	}
+
 	// endregion: interrupt h
 	opts := []runnable.Option{
 		runnable.WithBehavior(conductor.Config.Behavior),
 		runnable.WithPaths(conductor.Config.Paths),
 	}
+	// withReason returns opts, along with the reason recorded in the result of the runnable
+	withReason := func(reason string) []runnable.Option {
+		return append(opts[:len(opts):len(opts)], runnable.WithReason(reason))
+	}
+
+	// the max_parallel behavior of the root pipeline applies to all modules,
+	// unless it was already overridden from the command line
+	if pipe.Builder.Behavior != nil && pipe.Builder.Behavior.MaxParallel > 0 &&
+		conductor.Parent() == nil && cfg.Behavior.MaxParallel == 0 {
+		logger.Debugf("limiting concurrency to %d stages", pipe.Builder.Behavior.MaxParallel)
+		conductor.Update(ConductorWithPool(pool.New(pipe.Builder.Behavior.MaxParallel)))
+	}
+
+	// the artifact cache of the root pipeline is shared with all modules,
+	// unless it was already configured from the command line
+	if pipe.Builder.Cache != nil && conductor.Parent() == nil && !cfg.Cache.Enabled() {
+		dir := pipe.Builder.Cache.Dir
+		if dir != "" && !filepath.IsAbs(dir) {
+			dir = filepath.Join(cfg.Paths.Cwd, dir)
+		}
+		store := cache.NewStore(cache.Config{Dir: dir, URL: pipe.Builder.Cache.URL})
+		if store != nil {
+			logger.Debugf("using artifact cache %s", store)
+			conductor.Update(ConductorWithArtifacts(store))
+		}
+	}
+
+	// the state of the root pipeline is stored as runnables complete, so that it
+	// can be resumed. When resuming, the runnables which succeeded are restored.
+	var state, previous *RunState
+	if conductor.Parent() == nil && !cfg.Behavior.Child.Enabled && !cfg.Pipeline.DryRun {
+		if cfg.Pipeline.Resume {
+			previous, d = LoadRunState(cfg.Paths.Cwd, cfg.Pipeline.ResumeRunId)
+			h.Diags.Extend(d)
+			if h.Diags.HasErrors() {
+				return h, h.Diags
+			}
+			logger.Infof("resuming run %s", previous.Id)
+			h.Diags.Extend(previous.Resume(conductor))
+			if h.Diags.HasErrors() {
+				return h, h.Diags
+			}
+		}
+		if err := PruneRunStates(cfg.Paths.Cwd, cfg.Logging.KeepRuns); err != nil {
+			logger.Warnf("could not remove the state of previous runs: %s", err)
+		}
+		state = NewRunState(conductor, depGraph)
+		if previous != nil {
+			state.ResumedFrom = previous.Id
+		}
+		defer state.Finish(conductor)
+
+		// the output of each runnable is written to its own file, see togomak logs
+		logs, err := NewRunLogs(cfg.Paths.Cwd, conductor.Process.Id.String(), cfg.Logging.KeepRuns)
+		if err != nil {
+			logger.Warnf("could not create the logs of the runnables: %s", err)
+		}
+		conductor.Update(ConductorWithRunLogs(logs))
+		defer func() {
+			if err := logs.Close(); err != nil {
+				logger.Warn(err)
+			}
+		}()
+	}
+
+	scheduler := NewScheduler(depGraph)
+	sequential := cfg.Pipeline.DryRun || cfg.Behavior.DisableConcurrency ||
+		(pipe.Builder.Behavior != nil && pipe.Builder.Behavior.DisableConcurrency)
+
+	// when keeping going, a failing runnable does not stop the pipeline,
+	// only the runnables which depend on it are skipped. Otherwise, the pipeline
+	// is stopping, and only the runnables in runsAfterFailure are still run.
+	keepGoing := cfg.Behavior.KeepGoing
+	runsAfterFailure := pipe.RunsAfterFailure(depGraph)
+	stopping := false
+	stop := func(runnableId string) {
+		scheduler.Fail(runnableId)
+		stopping = !keepGoing
+	}
 
 	logger.Debugf("starting runnables")
-	for _, layer := range depGraph.TopoSortedLayers() {
-		// we parse the TOGOMAK_ENV file at the beginning of every layer
-		// this allows us to have different environments for different layers
+	for {
+		ready := scheduler.Ready()
+		if len(ready) == 0 {
+			if scheduler.Running() == 0 {
+				break
+			}
+			// nothing can be scheduled until one of the running runnables completes
+			scheduler.Wait()
+			stopping = stopping || (!keepGoing && h.Diags.HasErrors())
+			continue
+		}
 
+		// we parse the TOGOMAK_ENV file every time new runnables are ready to be run
+		// this allows runnables to see the outputs of the runnables they depend on
 		d = ExpandOutputs(conductor)
 		h.Diags.Extend(d)
-		if h.Diags.HasErrors() {
+		if d.HasErrors() {
 			break
 		}
 
-		for _, runnableId := range layer {
+		for _, runnableId := range ready {
 
 			runnable, skip, d := pipe.Resolve(runnableId)
 			if skip {
+				scheduler.Complete(runnableId)
 				continue
 			}
 			if d.HasErrors() {
 				h.Diags.Extend(d)
-				break
+				state.Fail(conductor, runnableId, nil)
+				stop(runnableId)
+				continue
 			}
 
-			ok, overridden, d := BlockCanRun(runnable, conductor, runnableId, depGraph, opts...)
+			if previous != nil && previous.Restore(conductor, runnableId, runnable) {
+				logger.Infof("%s succeeded in run %s, skipping", runnableId, previous.Id)
+				state.Complete(conductor, runnableId, runnable, true)
+				scheduler.Complete(runnableId)
+				continue
+			}
+
+			if cfg.Pipeline.Rerun != nil && !cfg.Pipeline.Rerun[runnableId] &&
+				(runnable.Type() == blocks.StageBlock || runnable.Type() == blocks.ModuleBlock) {
+				logger.Debugf("%s is not affected by the changes, skipping", runnableId)
+				scheduler.Complete(runnableId)
+				continue
+			}
+
+			failedUpstream := scheduler.FailedUpstream(runnableId)
+			if (stopping || len(failedUpstream) > 0) && !runsAfterFailure[runnableId] {
+				logger.Debugf("skipping runnable %s, since the pipeline has failed", runnableId)
+				reason := ReasonPipelineFailed
+				if len(failedUpstream) > 0 {
+					reason = ReasonUpstreamFailed(failedUpstream)
+				}
+				PublishSkipped(conductor, runnable, reason)
+				state.Skip(conductor, runnableId, runnable)
+				scheduler.Skip(runnableId)
+				continue
+			}
+			runnable.Set(StageContextFailedUpstream, failedUpstream)
+
+			ok, overridden, reason, d := BlockCanRun(runnable, conductor, runnableId, depGraph, opts...)
 			h.Diags.Extend(d)
 			if d.HasErrors() {
-				break
+				PublishFailed(conductor, runnable, d)
+				state.Fail(conductor, runnableId, runnable)
+				stop(runnableId)
+				continue
 			}
 
 			// prepare step needs to pipeline.Run before the runnable is pipeline.Run
@@ -102,53 +256,131 @@ func (pipe *Pipeline) Run(conductor *Conductor) (*Handler, dg.AbstractDiagnostic
 			d = runnable.Prepare(conductor, !ok, overridden)
 			h.Diags.Extend(d)
 			if d.HasErrors() {
-				break
+				PublishFailed(conductor, runnable, d)
+				state.Fail(conductor, runnableId, runnable)
+				stop(runnableId)
+				continue
 			}
 
 			if !ok {
-				logger.Debugf("skipping runnable %s, condition evaluated to false", runnableId)
+				logger.Debugf("skipping runnable %s, %s", runnableId, reason)
+				PublishSkipped(conductor, runnable, reason)
+				state.Skip(conductor, runnableId, runnable)
+				scheduler.Complete(runnableId)
 				continue
 			}
 
 			logger.Debugf("runnable %s is %T", runnableId, runnable)
+			runOpts := withReason(reason)
 
+			state.Dispatch(runnableId)
 			if runnable.IsDaemon() {
 				h.Tracker.AppendDaemon(runnable)
+				// daemons run until they are stopped, their dependents
+				// only need them to be started
+				scheduler.Complete(runnableId)
+				go func(runnableId string, runnable Block) {
+					d := BlockRunWithRetries(conductor, runnableId, runnable, h, conductor.Logger(), runOpts...)
+					state.Complete(conductor, runnableId, runnable, !d.HasErrors())
+				}(runnableId, runnable)
 			} else {
 				h.Tracker.AppendRunnable(runnable)
+				scheduler.Dispatch(runnableId)
+				go func(runnableId string, runnable Block) {
+					d := BlockRunWithRetries(conductor, runnableId, runnable, h, conductor.Logger(), runOpts...)
+					state.Complete(conductor, runnableId, runnable, !d.HasErrors())
+					scheduler.Done(runnableId, !d.HasErrors())
+				}(runnableId, runnable)
 			}
 
-			go BlockRunWithRetries(conductor, runnableId, runnable, h, conductor.Logger(), opts...)
-
-			if cfg.Pipeline.DryRun {
-				// TODO: implement --concurrency option
+			if sequential {
 				// wait for the runnable to finish
 				// disable concurrency
-				h.Tracker.RunnableWait()
-				h.Tracker.DaemonWait()
-			}
-			if pipe.Builder.Behavior != nil && pipe.Builder.Behavior.DisableConcurrency {
-				h.Tracker.RunnableWait()
+				if !runnable.IsDaemon() {
+					scheduler.Wait()
+				}
 				h.Tracker.DaemonWait()
+				stopping = stopping || (!keepGoing && h.Diags.HasErrors())
 			}
 		}
-		h.Tracker.RunnableWait()
+	}
 
-		if h.Diags.HasErrors() {
-			if h.Tracker.HasDaemons() && !cfg.Pipeline.DryRun && !cfg.Behavior.Unattended {
-				logger.Info("pipeline failed, waiting for daemons to shut down")
-				logger.Info("hit Ctrl+C to force stop them")
-				// wait for daemons to stop
-				h.Tracker.DaemonWait()
-			} else if h.Tracker.HasDaemons() && !cfg.Pipeline.DryRun {
-				logger.Info("pipeline failed, waiting for daemons to shut down...")
-				// wait for daemons to stop
-				cancel()
+	// let the runnables which are already running complete
+	for scheduler.Running() > 0 {
+		scheduler.Wait()
+	}
+
+	if keepGoing && len(scheduler.Failures()) > 0 {
+		logger.Errorf("%d runnable(s) failed: %s", len(scheduler.Failures()), strings.Join(scheduler.Failures(), ", "))
+		var skipped []string
+		for _, runnableId := range scheduler.Skipped() {
+			// togomak.pre and togomak.post are part of the graph even if they are not defined
+			if _, skip, _ := pipe.Resolve(runnableId); !skip {
+				skipped = append(skipped, runnableId)
 			}
-			break
+		}
+		if len(skipped) > 0 {
+			logger.Warnf("%d runnable(s) were skipped, since they depend on a runnable which failed: %s",
+				len(skipped), strings.Join(skipped, ", "))
+		}
+	}
+
+	if cfg.Pipeline.Watch {
+		// the signals are handled by togomak watch, which stops the daemons
+		h.IgnoreSignals()
+		return h, h.Diags
+	}
+
+	if h.Diags.HasErrors() {
+		if h.Tracker.HasDaemons() && !cfg.Pipeline.DryRun && !cfg.Behavior.Unattended {
+			logger.Info("pipeline failed, waiting for daemons to shut down")
+			logger.Info("hit Ctrl+C to force stop them")
+			// wait for daemons to stop
+			h.Tracker.DaemonWait()
+		} else if h.Tracker.HasDaemons() && !cfg.Pipeline.DryRun {
+			logger.Info("pipeline failed, waiting for daemons to shut down...")
+			// wait for daemons to stop
+			cancel()
 		}
 	}
 
 	h.Tracker.DaemonWait()
 	return h, h.Diags
 }
+
+// outputIds returns the identifiers of the stages of the pipeline, preceded by
+// module, and of the stages of its modules, as they are printed by Output, such
+// as module.deploy/stage.build. Only the modules whose source is a directory
+// and which do not use for_each are read, the stages of the other modules are
+// aligned once they run. The sources of the modules are relative to dir.
+func (pipe *Pipeline) outputIds(conductor *Conductor, dir string, module string) []string {
+	var ids []string
+	for _, stage := range pipe.Stages {
+		ids = append(ids, module+x.RenderBlock(blocks.StageBlock, stage.Id))
+	}
+	for _, m := range pipe.Modules {
+		if m.ForEach != nil {
+			if forEach, d := m.ForEach.Value(nil); d.HasErrors() || !forEach.IsNull() {
+				continue
+			}
+		}
+		source, d := m.Source.Value(nil)
+		if d.HasErrors() || !source.IsKnown() || source.IsNull() || source.Type() != cty.String {
+			continue
+		}
+		path := source.AsString()
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			continue
+		}
+		child, d := ReadDirFromPath(conductor, path)
+		if d.HasErrors() {
+			continue
+		}
+		// the stages of a module are identified along with the module they are directly within
+		ids = append(ids, child.outputIds(conductor, path, x.RenderBlock(blocks.ModuleBlock, m.Id)+"/")...)
+	}
+	return ids
+}
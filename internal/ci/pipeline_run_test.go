@@ -0,0 +1,122 @@
+package ci
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/srevinsaju/togomak/v1/internal/behavior"
+	"github.com/srevinsaju/togomak/v1/internal/path"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestConductor creates the conductor of the pipeline in dir/togomak.hcl, as
+// togomak does
+func newTestConductor(dir string) *Conductor {
+	return NewConductor(ConductorConfig{
+		Paths: &path.Path{
+			Pipeline: filepath.Join(dir, "togomak.hcl"),
+			Cwd:      dir,
+			Owd:      dir,
+		},
+		Behavior: &behavior.Behavior{Unattended: true},
+	})
+}
+
+// runTestPipeline runs the pipeline in dir/togomak.hcl, as togomak does, and
+// returns the diagnostics of the run. The working directory is restored once
+// the pipeline has finished.
+func runTestPipeline(t *testing.T, dir string) hcl.Diagnostics {
+	t.Helper()
+	owd, err := os.Getwd()
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.Chdir(owd))
+	}()
+
+	conductor := newTestConductor(dir)
+	defer conductor.Destroy()
+
+	pipe, diags := Read(conductor)
+	if diags.HasErrors() {
+		return diags
+	}
+	_, d := pipe.Run(conductor)
+	return d.Diagnostics()
+}
+
+// readTestPipeline reads the pipeline src, and creates its dependency graph, as
+// the commands which inspect a pipeline without running it do. The filters of
+// the returned conductor may be changed before the pipeline is inspected. The
+// working directory is restored once the test has finished.
+func readTestPipeline(t *testing.T, src string) (*Conductor, *Pipeline, *depgraph.Graph) {
+	t.Helper()
+	owd, err := os.Getwd()
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, os.Chdir(owd))
+	})
+
+	dir := t.TempDir()
+	writeTestPipeline(t, dir, src)
+	conductor := newTestConductor(dir)
+	t.Cleanup(conductor.Destroy)
+
+	pipe, diags := Read(conductor)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	pipe, depGraph, diags := ExpandGraph(conductor, pipe)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	return conductor, pipe, depGraph
+}
+
+// writeTestPipeline writes src to dir/togomak.hcl
+func writeTestPipeline(t *testing.T, dir string, src string) {
+	t.Helper()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "togomak.hcl"), []byte(src), 0644))
+}
+
+func TestPipeline_OutputIds(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPipeline(t, dir, `
+togomak {
+  version = 2
+}
+
+stage "a" {
+  script = "echo a"
+}
+
+module "deploy" {
+  source = "./deploy"
+}
+
+module "regions" {
+  for_each = toset(["eu", "us"])
+  source   = "./deploy"
+}
+
+module "remote" {
+  source = "git::https://example.com/pipeline.git"
+}
+`)
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "deploy"), 0755))
+	writeTestPipeline(t, filepath.Join(dir, "deploy"), `
+togomak {
+  version = 2
+}
+
+stage "inner" {
+  script = "echo inner"
+}
+`)
+
+	conductor := newRunStateTestConductor(t, dir, time.Now())
+	conductor.Parser = NewParser()
+	pipe, diags := ReadDirFromPath(conductor, dir)
+	assert.False(t, diags.HasErrors(), diags.Error())
+
+	// the modules using for_each, or whose source is not a directory, are aligned once they run
+	assert.Equal(t, []string{"stage.a", "module.deploy/stage.inner"}, pipe.outputIds(conductor, dir, ""))
+}
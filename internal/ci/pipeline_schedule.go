@@ -0,0 +1,163 @@
+package ci
+
+import (
+	"github.com/kendru/darwin/go/depgraph"
+)
+
+// Scheduler hands out runnables from the dependency graph as soon as all the
+// runnables they depend on have completed. Unlike iterating over
+// depgraph.Graph.TopoSortedLayers, a slow runnable only holds back the
+// runnables which depend on it, and not every runnable in the next layer.
+//
+// Runnables which depend on a runnable that has failed are still handed out
+// once their dependencies have completed, the caller decides whether they run,
+// see FailedUpstream and Skip.
+//
+// The Scheduler is not safe for concurrent use, with the exception of Done,
+// which is called by the goroutines executing the runnables.
+type Scheduler struct {
+	order        []string
+	dependencies map[string][]string
+
+	scheduled map[string]bool
+	completed map[string]bool
+	failed    map[string]bool
+	running   int
+
+	// failures are the runnables which failed, and skipped are the runnables
+	// which were not run because one of their dependencies failed
+	failures []string
+	skipped  []string
+
+	done chan completion
+}
+
+// completion is sent by the goroutine executing a runnable once it has finished
+type completion struct {
+	runnableId string
+	ok         bool
+}
+
+// NewScheduler creates a Scheduler for every node in depGraph
+func NewScheduler(depGraph *depgraph.Graph) *Scheduler {
+	s := &Scheduler{
+		dependencies: make(map[string][]string),
+		scheduled:    make(map[string]bool),
+		completed:    make(map[string]bool),
+		failed:       make(map[string]bool),
+	}
+	for _, layer := range depGraph.TopoSortedLayers() {
+		for _, runnableId := range layer {
+			s.order = append(s.order, runnableId)
+			for dependency := range depGraph.Dependencies(runnableId) {
+				s.dependencies[runnableId] = append(s.dependencies[runnableId], dependency)
+			}
+		}
+	}
+	s.done = make(chan completion, len(s.order))
+	return s
+}
+
+// Ready returns the runnables which were not handed out before, and whose
+// dependencies have all completed. Every runnable returned by Ready must be
+// passed to Complete or Fail, or to Dispatch followed by Done once it has finished.
+func (s *Scheduler) Ready() []string {
+	var ready []string
+	for _, runnableId := range s.order {
+		if s.scheduled[runnableId] {
+			continue
+		}
+		if !s.satisfied(runnableId) {
+			continue
+		}
+		s.scheduled[runnableId] = true
+		ready = append(ready, runnableId)
+	}
+	return ready
+}
+
+// FailedUpstream returns the runnables which runnableId depends on, directly
+// or indirectly, and which have failed, in topological order
+func (s *Scheduler) FailedUpstream(runnableId string) []string {
+	var failed []string
+	for _, dependency := range s.order {
+		if !s.failed[dependency] {
+			continue
+		}
+		for _, d := range s.dependencies[runnableId] {
+			if d == dependency {
+				failed = append(failed, dependency)
+				break
+			}
+		}
+	}
+	return failed
+}
+
+func (s *Scheduler) satisfied(runnableId string) bool {
+	for _, dependency := range s.dependencies[runnableId] {
+		if !s.completed[dependency] {
+			return false
+		}
+	}
+	return true
+}
+
+// Dispatch marks runnableId as running. The goroutine executing the runnable
+// must call Done exactly once when it finishes.
+func (s *Scheduler) Dispatch(runnableId string) {
+	s.running++
+}
+
+// Done signals that a dispatched runnable has finished, ok is false if it failed.
+func (s *Scheduler) Done(runnableId string, ok bool) {
+	s.done <- completion{runnableId: runnableId, ok: ok}
+}
+
+// Complete marks runnableId as completed right away. This is used for runnables
+// which are skipped, and for daemons, which do not block their dependents.
+func (s *Scheduler) Complete(runnableId string) {
+	s.completed[runnableId] = true
+}
+
+// Skip marks runnableId as completed right away, and records that it was not
+// run because the pipeline failed, or one of its dependencies failed.
+func (s *Scheduler) Skip(runnableId string) {
+	s.completed[runnableId] = true
+	s.skipped = append(s.skipped, runnableId)
+}
+
+// Fail marks runnableId as failed right away, without it being dispatched.
+func (s *Scheduler) Fail(runnableId string) {
+	s.completed[runnableId] = true
+	s.failed[runnableId] = true
+	s.failures = append(s.failures, runnableId)
+}
+
+// Wait blocks until one of the dispatched runnables is done, marks it as
+// completed, or failed, and returns its identifier.
+func (s *Scheduler) Wait() string {
+	c := <-s.done
+	s.running--
+	if c.ok {
+		s.Complete(c.runnableId)
+	} else {
+		s.Fail(c.runnableId)
+	}
+	return c.runnableId
+}
+
+// Running returns the number of dispatched runnables which are not done yet
+func (s *Scheduler) Running() int {
+	return s.running
+}
+
+// Failures returns the runnables which have failed, in the order they failed
+func (s *Scheduler) Failures() []string {
+	return s.failures
+}
+
+// Skipped returns the runnables which were passed to Skip
+func (s *Scheduler) Skipped() []string {
+	return s.skipped
+}
@@ -0,0 +1,76 @@
+package ci
+
+import (
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestScheduler_Ready(t *testing.T) {
+	g := depgraph.New()
+	assert.NoError(t, g.DependOn("stage.slow", "togomak.root"))
+	assert.NoError(t, g.DependOn("stage.fast", "togomak.root"))
+	assert.NoError(t, g.DependOn("stage.after_fast", "stage.fast"))
+	assert.NoError(t, g.DependOn("stage.after_both", "stage.fast"))
+	assert.NoError(t, g.DependOn("stage.after_both", "stage.slow"))
+
+	s := NewScheduler(g)
+	assert.Equal(t, []string{"togomak.root"}, s.Ready())
+	assert.Empty(t, s.Ready())
+	s.Complete("togomak.root")
+
+	ready := s.Ready()
+	assert.ElementsMatch(t, []string{"stage.slow", "stage.fast"}, ready)
+	for _, runnableId := range ready {
+		s.Dispatch(runnableId)
+	}
+	assert.Equal(t, 2, s.Running())
+
+	// stage.after_fast must not wait for stage.slow, which is still running
+	s.Done("stage.fast", true)
+	assert.Equal(t, "stage.fast", s.Wait())
+	assert.Equal(t, []string{"stage.after_fast"}, s.Ready())
+	assert.Equal(t, 1, s.Running())
+
+	s.Done("stage.slow", true)
+	assert.Equal(t, "stage.slow", s.Wait())
+	assert.Equal(t, []string{"stage.after_both"}, s.Ready())
+	assert.Equal(t, 0, s.Running())
+}
+
+func TestScheduler_Fail(t *testing.T) {
+	g := depgraph.New()
+	assert.NoError(t, g.DependOn("stage.lint", "togomak.root"))
+	assert.NoError(t, g.DependOn("stage.test", "togomak.root"))
+	assert.NoError(t, g.DependOn("stage.build", "stage.test"))
+	assert.NoError(t, g.DependOn("stage.deploy", "stage.build"))
+
+	s := NewScheduler(g)
+	s.Complete(s.Ready()[0])
+
+	ready := s.Ready()
+	assert.ElementsMatch(t, []string{"stage.lint", "stage.test"}, ready)
+	for _, runnableId := range ready {
+		s.Dispatch(runnableId)
+	}
+	s.Done("stage.test", false)
+	s.Done("stage.lint", true)
+	s.Wait()
+	s.Wait()
+
+	// stage.build is still handed out, the caller decides if it runs
+	assert.Equal(t, []string{"stage.build"}, s.Ready())
+	assert.Equal(t, []string{"stage.test"}, s.FailedUpstream("stage.build"))
+	assert.Empty(t, s.FailedUpstream("stage.lint"))
+	s.Skip("stage.build")
+
+	// stage.deploy depends on stage.test through stage.build
+	assert.Equal(t, []string{"stage.deploy"}, s.Ready())
+	assert.Equal(t, []string{"stage.test"}, s.FailedUpstream("stage.deploy"))
+	s.Skip("stage.deploy")
+
+	assert.Empty(t, s.Ready())
+	assert.Equal(t, 0, s.Running())
+	assert.Equal(t, []string{"stage.test"}, s.Failures())
+	assert.Equal(t, []string{"stage.build", "stage.deploy"}, s.Skipped())
+}
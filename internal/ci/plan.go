@@ -0,0 +1,199 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/zclconf/go-cty/cty"
+	"sort"
+	"strings"
+)
+
+// PlanDecision is what a run of the pipeline would do with a runnable
+type PlanDecision string
+
+const (
+	// PlanRun is a runnable which would be run
+	PlanRun PlanDecision = "run"
+
+	// PlanSkip is a runnable which would be skipped, by its condition, its
+	// run_when or the lifecycle phases
+	PlanSkip PlanDecision = "skip"
+
+	// PlanOverridden is a runnable which the filters select or exclude,
+	// regardless of its condition
+	PlanOverridden PlanDecision = "overridden"
+
+	// PlanUnknown is a runnable whose condition depends on values which are
+	// only known once the pipeline runs, such as the outputs of stages
+	PlanUnknown PlanDecision = "unknown"
+)
+
+// planProviders are the data providers which are evaluated by togomak plan, since
+// they have no side effects. The other providers are not evaluated, as they clone
+// repositories, prompt for input or run other programs.
+var planProviders = map[string]bool{
+	"env": true,
+}
+
+// PlanEntry is the decision taken for a runnable of the pipeline
+type PlanEntry struct {
+	Id       string       `json:"id"`
+	Type     string       `json:"type"`
+	Decision PlanDecision `json:"decision"`
+
+	// Run is true if the runnable would be run, which is useful when
+	// the decision is overridden
+	Run bool `json:"run"`
+
+	// Reason explains the decision, it is empty for runnables which run
+	Reason string `json:"reason,omitempty"`
+
+	// Phases are the lifecycle phases of a stage or a module
+	Phases []string `json:"phases,omitempty"`
+
+	// Instances are the identifiers of the instances of a stage or a module using for_each
+	Instances []string `json:"instances,omitempty"`
+}
+
+// Plan is the list of runnables of a pipeline in the order they would be run,
+// along with the decision taken for each of them
+type Plan struct {
+	Runnables []PlanEntry `json:"runnables"`
+}
+
+// NewPlan evaluates the variables, locals, data and macro blocks of pipe, and
+// decides which stages and modules would be run, without running them. depGraph
+// is the graph created by GraphTopoSort. Failures to evaluate a runnable are
+// recorded in its entry.
+func NewPlan(conductor *Conductor, pipe *Pipeline, depGraph *depgraph.Graph) *Plan {
+	opts := []runnable.Option{
+		runnable.WithBehavior(conductor.Config.Behavior),
+		runnable.WithPaths(conductor.Config.Paths),
+	}
+
+	plan := &Plan{Runnables: []PlanEntry{}}
+	for _, layer := range depGraph.TopoSortedLayers() {
+		sort.Strings(layer)
+		for _, runnableId := range layer {
+			r, skip, d := pipe.Resolve(runnableId)
+			if skip {
+				continue
+			}
+			blockType, _, _ := strings.Cut(runnableId, ".")
+			entry := PlanEntry{Id: runnableId, Type: blockType}
+			if d.HasErrors() {
+				entry.Decision, entry.Reason = PlanUnknown, planReason(d)
+			} else {
+				entry = planEntry(conductor, r, runnableId, depGraph, entry, opts...)
+			}
+			plan.Runnables = append(plan.Runnables, entry)
+		}
+	}
+	return plan
+}
+
+// planEntry decides what would be done with r. Stages and modules are never run,
+// the other blocks are evaluated so that the runnables which depend on them can be planned.
+func planEntry(conductor *Conductor, r Block, runnableId string, depGraph *depgraph.Graph, entry PlanEntry, opts ...runnable.Option) PlanEntry {
+	if r.Type() != blocks.StageBlock && r.Type() != blocks.ModuleBlock {
+		entry.Decision, entry.Run = PlanRun, true
+		if data, ok := r.(*Data); ok && !planProviders[data.Provider] {
+			entry.Decision, entry.Run = PlanUnknown, false
+			entry.Reason = fmt.Sprintf("the %s provider is not evaluated by plan", data.Provider)
+			return entry
+		}
+		if d := r.Run(conductor, opts...); d.HasErrors() {
+			entry.Decision, entry.Run, entry.Reason = PlanUnknown, false, planReason(d)
+		}
+		return entry
+	}
+
+	entry.Phases = blockPhases(conductor, r)
+	r.Set(StageContextFailedUpstream, []string{})
+	ok, overridden, reason, d := BlockCanRun(r, conductor, runnableId, depGraph, opts...)
+	if d.HasErrors() {
+		entry.Decision, entry.Reason = PlanUnknown, planReason(d)
+		return entry
+	}
+	entry.Run, entry.Reason = ok, reason
+	switch {
+	case overridden:
+		entry.Decision = PlanOverridden
+	case ok:
+		entry.Decision = PlanRun
+	default:
+		entry.Decision = PlanSkip
+	}
+
+	instances, d := planInstances(conductor, r, runnableId)
+	if d.HasErrors() {
+		entry.Decision, entry.Run, entry.Reason = PlanUnknown, false, planReason(d)
+		return entry
+	}
+	entry.Instances = instances
+	return entry
+}
+
+// planInstances returns the identifiers of the instances of a stage or a
+// module using for_each, named like the instances created when it runs
+func planInstances(conductor *Conductor, r Block, runnableId string) ([]string, hcl.Diagnostics) {
+	var forEach hcl.Expression
+	switch block := r.(type) {
+	case *Stage:
+		forEach = block.ForEach
+	case *Module:
+		forEach = block.ForEach
+	}
+	if forEach == nil {
+		return nil, nil
+	}
+
+	conductor.Eval().Mutex().RLock()
+	items, diags := forEach.Value(conductor.Eval().Context())
+	conductor.Eval().Mutex().RUnlock()
+	if diags.HasErrors() || items.IsNull() {
+		return nil, diags
+	}
+	if diag := sensitiveForEach(forEach, items); diag != nil {
+		return nil, diags.Append(diag)
+	}
+	if !items.IsWhollyKnown() || !items.CanIterateElements() {
+		return nil, diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "invalid type for for_each",
+			Detail:   "for_each must be a set or map of objects",
+			Subject:  forEach.Range().Ptr(),
+		})
+	}
+
+	var instances []string
+	var counter int
+	items.ForEachElement(func(k cty.Value, v cty.Value) bool {
+		key := fmt.Sprintf("%d", counter)
+		if k.Type() == cty.String {
+			key = fmt.Sprintf("\"%s\"", k.AsString())
+		}
+		counter++
+		instances = append(instances, fmt.Sprintf("%s[%s]", runnableId, key))
+		return false
+	})
+	return instances, diags
+}
+
+// planReason summarizes the errors which prevented a runnable from being planned
+func planReason(diags hcl.Diagnostics) string {
+	var reasons []string
+	for _, err := range diags.Errs() {
+		reasons = append(reasons, err.Error())
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// JSON returns the plan as JSON
+func (p *Plan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
@@ -0,0 +1,117 @@
+package ci
+
+import (
+	"fmt"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"github.com/srevinsaju/togomak/v1/internal/rules"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+const planTestPipeline = `
+togomak {
+  version = 2
+}
+
+locals {
+  env     = "dev"
+  targets = ["linux", "darwin"]
+}
+
+stage "build" {
+  script = "true"
+}
+
+stage "release" {
+  if     = local.env == "prod"
+  script = "true"
+}
+
+stage "matrix" {
+  for_each = { for target in local.targets : target => target }
+  script   = "true"
+}
+
+stage "notify" {
+  if     = stage.build.status == "success"
+  script = "true"
+}
+`
+
+func TestNewPlan(t *testing.T) {
+	conductor, pipe, g := readTestPipeline(t, planTestPipeline)
+
+	plan := NewPlan(conductor, pipe, g)
+	entries := make(map[string]PlanEntry)
+	for _, entry := range plan.Runnables {
+		entries[entry.Id] = entry
+	}
+	assert.Len(t, entries, 6)
+	assert.NotContains(t, entries, meta.RootStage)
+
+	assert.Equal(t, PlanRun, entries["local.env"].Decision)
+	assert.Equal(t, PlanRun, entries["stage.build"].Decision)
+	assert.True(t, entries["stage.build"].Run)
+
+	assert.Equal(t, PlanSkip, entries["stage.release"].Decision)
+	assert.Equal(t, ReasonCondition, entries["stage.release"].Reason)
+
+	assert.Equal(t, PlanRun, entries["stage.matrix"].Decision)
+	assert.Equal(t, []string{`stage.matrix["darwin"]`, `stage.matrix["linux"]`}, entries["stage.matrix"].Instances)
+
+	// the status of a stage is only known once it has run
+	assert.Equal(t, PlanUnknown, entries["stage.notify"].Decision)
+	assert.False(t, entries["stage.notify"].Run)
+	assert.Contains(t, entries["stage.notify"].Reason, "stage")
+}
+
+func TestNewPlan_Overridden(t *testing.T) {
+	conductor, pipe, g := readTestPipeline(t, planTestPipeline)
+	conductor.Config.Pipeline.Filtered = rules.Operations{
+		rules.NewOperation(rules.OperationTypeAdd, "stage.release"),
+		rules.NewOperation(rules.OperationTypeSub, "stage.build"),
+	}
+
+	plan := NewPlan(conductor, pipe, g)
+	entries := make(map[string]PlanEntry)
+	for _, entry := range plan.Runnables {
+		entries[entry.Id] = entry
+	}
+
+	assert.Equal(t, PlanOverridden, entries["stage.release"].Decision)
+	assert.True(t, entries["stage.release"].Run)
+	assert.Equal(t, ReasonIncluded, entries["stage.release"].Reason)
+
+	assert.Equal(t, PlanOverridden, entries["stage.build"].Decision)
+	assert.False(t, entries["stage.build"].Run)
+	assert.Equal(t, ReasonExcluded, entries["stage.build"].Reason)
+
+	content, err := plan.JSON()
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `"decision": "overridden"`)
+}
+
+func TestNewPlan_SideEffects(t *testing.T) {
+	destination := filepath.Join(t.TempDir(), "repo")
+	conductor, pipe, g := readTestPipeline(t, fmt.Sprintf(`
+togomak {
+  version = 2
+}
+
+data "git" "repo" {
+  url         = "https://github.com/srevinsaju/togomak"
+  destination = %q
+}
+`, destination))
+
+	plan := NewPlan(conductor, pipe, g)
+	assert.Len(t, plan.Runnables, 1)
+	entry := plan.Runnables[0]
+	assert.Equal(t, PlanUnknown, entry.Decision)
+	assert.False(t, entry.Run)
+	assert.Equal(t, "the git provider is not evaluated by plan", entry.Reason)
+
+	// the repository is not cloned
+	assert.NoDirExists(t, destination)
+}
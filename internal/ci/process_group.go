@@ -0,0 +1,38 @@
+//go:build !windows
+
+package ci
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the process of cmd in a process group of its own, so
+// that the processes it starts are stopped along with it
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// terminateProcessGroup sends SIGTERM to p and to the processes in its process group
+func terminateProcessGroup(p *os.Process) error {
+	return signalProcessGroup(p, syscall.SIGTERM)
+}
+
+// killProcessGroup kills p and the processes in its process group
+func killProcessGroup(p *os.Process) error {
+	return signalProcessGroup(p, syscall.SIGKILL)
+}
+
+func signalProcessGroup(p *os.Process, sig syscall.Signal) error {
+	err := syscall.Kill(-p.Pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		// every process of the group has already exited
+		return os.ErrProcessDone
+	}
+	return err
+}
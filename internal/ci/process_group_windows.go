@@ -0,0 +1,20 @@
+package ci
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing on windows, where there are no process groups
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup sends SIGTERM to p, the processes it started are not signalled on windows
+func terminateProcessGroup(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
+
+// killProcessGroup kills p, the processes it started are not killed on windows
+func killProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
@@ -36,3 +36,12 @@ func (e QueryEngines) Eval(conductor *Conductor, ok bool, stage PhasedBlock) (bo
 	}
 	return resultOk, overridden, diags
 }
+
+// Marshall returns the queries the engines were created from, see NewSlice
+func (e QueryEngines) Marshall() []string {
+	var queries []string
+	for _, engine := range e {
+		queries = append(queries, engine.rule)
+	}
+	return queries
+}
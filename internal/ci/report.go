@@ -0,0 +1,117 @@
+package ci
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/dg"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Report is the machine-readable summary of a run, written with --report
+type Report struct {
+	// Id is the identifier of the process which ran the pipeline
+	Id       string    `json:"id"`
+	Pipeline string    `json:"pipeline"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+
+	// Duration is the duration of the run in seconds
+	Duration float64             `json:"duration"`
+	Status   runnable.StatusType `json:"status"`
+
+	Runnables []ReportRunnable `json:"runnables"`
+	Modules   []ReportModule   `json:"modules,omitempty"`
+
+	// Diagnostics has the diagnostics of the whole run
+	Diagnostics []dg.JSONDiagnostic `json:"diagnostics"`
+}
+
+// ReportModule has the runnables of a module
+type ReportModule struct {
+	Id        string           `json:"id"`
+	Runnables []ReportRunnable `json:"runnables"`
+	Modules   []ReportModule   `json:"modules,omitempty"`
+}
+
+// ReportRunnable is the result of a stage, module, data, variable or local block
+type ReportRunnable struct {
+	runnable.Result
+
+	// Type is the type of the block, for example stage
+	Type string `json:"type"`
+
+	// Duration is how long the runnable ran for in seconds, including retries
+	Duration float64 `json:"duration"`
+
+	Diagnostics []dg.JSONDiagnostic `json:"diagnostics"`
+}
+
+// NewReport creates the report of the run of conductor, diags are the diagnostics of the whole run
+func NewReport(conductor *Conductor, diags hcl.Diagnostics) Report {
+	finished := time.Now()
+	status := runnable.StatusSuccess
+	if diags.HasErrors() {
+		status = runnable.StatusFailure
+	}
+	runnables, modules := reportResults(conductor.Results())
+	return Report{
+		Id:          conductor.Process.Id.String(),
+		Pipeline:    conductor.Config.Paths.Pipeline,
+		Started:     conductor.Process.BootTime,
+		Finished:    finished,
+		Duration:    finished.Sub(conductor.Process.BootTime).Seconds(),
+		Status:      status,
+		Runnables:   runnables,
+		Modules:     modules,
+		Diagnostics: redactDiagnostics(dg.JSON(diags)),
+	}
+}
+
+func reportResults(results *Results) ([]ReportRunnable, []ReportModule) {
+	runnables := []ReportRunnable{}
+	for _, result := range results.List() {
+		blockType, _, _ := strings.Cut(result.Id, ".")
+		result.Output = logging.Secrets().Redact(result.Output)
+		result.Reason = logging.Secrets().Redact(result.Reason)
+		runnables = append(runnables, ReportRunnable{
+			Result:      result,
+			Type:        blockType,
+			Duration:    result.Duration().Seconds(),
+			Diagnostics: redactDiagnostics(dg.JSON(result.Diags)),
+		})
+	}
+
+	var modules []ReportModule
+	for _, id := range results.Modules() {
+		module := ReportModule{Id: id}
+		module.Runnables, module.Modules = reportResults(results.Module(id))
+		modules = append(modules, module)
+	}
+	return runnables, modules
+}
+
+// redactDiagnostics masks the secrets in the summary and the detail of diags
+func redactDiagnostics(diags []dg.JSONDiagnostic) []dg.JSONDiagnostic {
+	for i := range diags {
+		diags[i].Summary = logging.Secrets().Redact(diags[i].Summary)
+		diags[i].Detail = logging.Secrets().Redact(diags[i].Detail)
+	}
+	return diags
+}
+
+// Write writes the report as JSON to path
+func (r Report) Write(path string) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
@@ -0,0 +1,159 @@
+package ci
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// JUnitTestSuites is the root element of a JUnit XML report, with a test suite
+// for the pipeline, and one for each module
+type JUnitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []JUnitTestSuite `xml:"testsuite"`
+}
+
+type JUnitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []JUnitTestCase `xml:"testcase"`
+}
+
+type JUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *JUnitMessage `xml:"failure,omitempty"`
+	Skipped   *JUnitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type JUnitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Content string `xml:",chardata"`
+}
+
+func junitTime(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+// JUnit converts the report to JUnit XML test suites. Every stage and module is a
+// test case, other runnables are only included when they have failed.
+func (r Report) JUnit() JUnitTestSuites {
+	suites := JUnitTestSuites{
+		Name: meta.AppName,
+		Time: junitTime(r.Duration),
+	}
+	name := filepath.Base(filepath.Dir(r.Pipeline))
+	if r.Pipeline == "" {
+		name = meta.AppName
+	}
+	suites.Suites = junitSuites(name, r.Runnables, r.Modules)
+	if len(suites.Suites) > 0 && !r.Started.IsZero() {
+		suites.Suites[0].Timestamp = r.Started.Format("2006-01-02T15:04:05")
+	}
+	for _, suite := range suites.Suites {
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+	}
+	return suites
+}
+
+// junitSuites returns the test suite named name for runnables, followed by the test suites of modules
+func junitSuites(name string, runnables []ReportRunnable, modules []ReportModule) []JUnitTestSuite {
+	suite := JUnitTestSuite{Name: name, Cases: []JUnitTestCase{}}
+	var duration float64
+	for _, result := range runnables {
+		if result.Type != blocks.StageBlock && result.Type != blocks.ModuleBlock && result.Status != runnable.StatusFailure {
+			continue
+		}
+		testCase := junitTestCase(name, result)
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+		duration += result.Duration
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+		if testCase.Skipped != nil {
+			suite.Skipped++
+		}
+	}
+	suite.Time = junitTime(duration)
+
+	suites := []JUnitTestSuite{suite}
+	for _, module := range modules {
+		suites = append(suites, junitSuites(name+"/"+module.Id, module.Runnables, module.Modules)...)
+	}
+	return suites
+}
+
+func junitTestCase(className string, result ReportRunnable) JUnitTestCase {
+	testCase := JUnitTestCase{
+		Name:      result.Id,
+		ClassName: className,
+		Time:      junitTime(result.Duration),
+		SystemOut: result.Output,
+	}
+
+	var errors, warnings []string
+	for _, diag := range result.Diagnostics {
+		message := diag.Summary
+		if diag.Detail != "" {
+			message = fmt.Sprintf("%s: %s", message, diag.Detail)
+		}
+		if diag.Range != nil {
+			message = fmt.Sprintf("%s:%d,%d: %s", diag.Range.Filename, diag.Range.Start.Line, diag.Range.Start.Column, message)
+		}
+		if diag.Severity == "warning" {
+			warnings = append(warnings, message)
+		} else {
+			errors = append(errors, message)
+		}
+	}
+
+	switch result.Status {
+	case runnable.StatusSkipped:
+		testCase.Skipped = &JUnitMessage{Message: result.Reason}
+	case runnable.StatusSuccess, runnable.StatusCached:
+	default:
+		// stages which are allowed to fail only have warnings, and do not fail the pipeline
+		if len(errors) > 0 || len(warnings) == 0 {
+			testCase.Failure = &JUnitMessage{
+				Message: fmt.Sprintf("%s %s", result.Id, result.Status),
+				Type:    result.Status.String(),
+				Content: strings.Join(errors, "\n"),
+			}
+		}
+	}
+	testCase.SystemErr = strings.Join(warnings, "\n")
+	return testCase
+}
+
+// WriteJUnit writes the report as JUnit XML to path
+func (r Report) WriteJUnit(path string) error {
+	content, err := xml.MarshalIndent(r.JUnit(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), content...), 0644)
+}
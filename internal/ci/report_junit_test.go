@@ -0,0 +1,70 @@
+package ci
+
+import (
+	"github.com/srevinsaju/togomak/v1/internal/dg"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReport_JUnit(t *testing.T) {
+	result := func(id string, blockType string, status runnable.StatusType, diags ...dg.JSONDiagnostic) ReportRunnable {
+		return ReportRunnable{
+			Result:      runnable.Result{Id: id, Status: status, Output: "hello\n"},
+			Type:        blockType,
+			Duration:    1.5,
+			Diagnostics: diags,
+		}
+	}
+	skipped := result("stage.deploy", "stage", runnable.StatusSkipped)
+	skipped.Reason = "stage.build failed"
+
+	report := Report{
+		Pipeline: "/src/app/togomak.hcl",
+		Runnables: []ReportRunnable{
+			result("var.name", "var", runnable.StatusSuccess),
+			result("data.env.home", "data", runnable.StatusFailure, dg.JSONDiagnostic{Severity: "error", Summary: "HOME is not set"}),
+			result("stage.build", "stage", runnable.StatusFailure, dg.JSONDiagnostic{
+				Severity: "error",
+				Summary:  "failed to run command (build)",
+				Detail:   "exit status 1",
+				Range:    &dg.JSONRange{Filename: "togomak.hcl", Start: dg.JSONPos{Line: 5, Column: 1}},
+			}),
+			result("stage.lint", "stage", runnable.StatusFailure, dg.JSONDiagnostic{Severity: "warning", Summary: "lint failed"}),
+			skipped,
+		},
+		Modules: []ReportModule{
+			{Id: "module.docs", Runnables: []ReportRunnable{result("stage.render", "stage", runnable.StatusCached)}},
+		},
+	}
+
+	suites := report.JUnit()
+	assert.Equal(t, 5, suites.Tests)
+	assert.Equal(t, 2, suites.Failures)
+	assert.Equal(t, 1, suites.Skipped)
+	assert.Len(t, suites.Suites, 2)
+
+	app := suites.Suites[0]
+	assert.Equal(t, "app", app.Name)
+	assert.Equal(t, "data.env.home", app.Cases[0].Name)
+	assert.Equal(t, "togomak.hcl:5,1: failed to run command (build): exit status 1", app.Cases[1].Failure.Content)
+	assert.Equal(t, "hello\n", app.Cases[1].SystemOut)
+	assert.Nil(t, app.Cases[2].Failure)
+	assert.Equal(t, "lint failed", app.Cases[2].SystemErr)
+	assert.Equal(t, "stage.build failed", app.Cases[3].Skipped.Message)
+
+	docs := suites.Suites[1]
+	assert.Equal(t, "app/module.docs", docs.Name)
+	assert.Equal(t, "app/module.docs", docs.Cases[0].ClassName)
+	assert.Nil(t, docs.Cases[0].Failure)
+
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	assert.NoError(t, report.WriteJUnit(path))
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "<?xml"))
+	assert.Contains(t, string(content), `<testsuite name="app/module.docs" tests="1" failures="0" skipped="0" time="1.500">`)
+}
@@ -0,0 +1,61 @@
+package ci
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewReport(t *testing.T) {
+	dir := t.TempDir()
+	conductor := newRunStateTestConductor(t, dir, time.Now())
+	started := time.Now()
+	failure := &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  "failed to run command (build)",
+		Subject:  &hcl.Range{Filename: "togomak.hcl", Start: hcl.Pos{Line: 3, Column: 1}, End: hcl.Pos{Line: 3, Column: 6}},
+	}
+
+	conductor.Results().Record(runnable.Result{Id: "var.name", Status: runnable.StatusSuccess, Attempts: 1})
+	conductor.Results().Record(runnable.Result{
+		Id:       "stage.build",
+		Status:   runnable.StatusFailure,
+		ExitCode: 2,
+		Attempts: 3,
+		Started:  started,
+		Finished: started.Add(2 * time.Second),
+		Diags:    hcl.Diagnostics{failure},
+	})
+	conductor.Results().Record(runnable.Result{Id: "stage.deploy", Status: runnable.StatusSkipped, Reason: ReasonUpstreamFailed([]string{"stage.build"})})
+	conductor.Results().Module("module.lint").Record(runnable.Result{Id: "stage.vet", Status: runnable.StatusSuccess})
+
+	report := NewReport(conductor, hcl.Diagnostics{failure})
+	assert.Equal(t, runnable.StatusFailure, report.Status)
+	assert.Len(t, report.Runnables, 3)
+	assert.Len(t, report.Diagnostics, 1)
+
+	build := report.Runnables[1]
+	assert.Equal(t, "stage", build.Type)
+	assert.Equal(t, 2.0, build.Duration)
+	assert.Equal(t, 3, build.Diagnostics[0].Range.Start.Line)
+	assert.Equal(t, "var", report.Runnables[0].Type)
+	assert.Equal(t, "stage.build failed", report.Runnables[2].Reason)
+
+	assert.Len(t, report.Modules, 1)
+	assert.Equal(t, "module.lint", report.Modules[0].Id)
+	assert.Equal(t, "stage.vet", report.Modules[0].Runnables[0].Id)
+
+	path := filepath.Join(dir, "reports", "report.json")
+	assert.NoError(t, report.Write(path))
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var decoded map[string]any
+	assert.NoError(t, json.Unmarshal(content, &decoded))
+	assert.Equal(t, "failure", decoded["status"])
+	assert.Equal(t, 2.0, decoded["runnables"].([]any)[1].(map[string]any)["exit_code"])
+}
@@ -0,0 +1,201 @@
+package ci
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/global"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/srevinsaju/togomak/v1/internal/x"
+	"github.com/zclconf/go-cty/cty"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reasons why a runnable was skipped, or why it was run although its
+// condition evaluated to false, see runnable.Result
+const (
+	ReasonCondition      = "condition evaluated to false"
+	ReasonRunWhen        = "run_when is not satisfied"
+	ReasonNotSelected    = "not selected by the filters or the lifecycle phases"
+	ReasonExcluded       = "excluded by the filters"
+	ReasonIncluded       = "selected by the filters"
+	ReasonPipelineFailed = "the pipeline has failed"
+)
+
+// ReasonUpstreamFailed is the reason a runnable is skipped when the runnables it depends on have failed
+func ReasonUpstreamFailed(failedUpstream []string) string {
+	return fmt.Sprintf("%s failed", strings.Join(failedUpstream, ", "))
+}
+
+// Results keeps the results of the runnables which have finished running, in
+// the order they finished. Results are keyed by the rendered block identifier,
+// for example stage.build, or stage.build["linux"] for a single instance of
+// a for_each stage. The results of the runnables within each module are kept
+// separately, see Module.
+type Results struct {
+	mu      sync.Mutex
+	order   []string
+	results map[string]runnable.Result
+
+	modules  []string
+	children map[string]*Results
+}
+
+func NewResults() *Results {
+	return &Results{
+		results:  make(map[string]runnable.Result),
+		children: make(map[string]*Results),
+	}
+}
+
+// Record stores result, replacing the previous result of the same runnable
+func (r *Results) Record(result runnable.Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.results[result.Id]; !ok {
+		r.order = append(r.order, result.Id)
+	}
+	r.results[result.Id] = result
+}
+
+// Get returns the result of the runnable identified by id, if it has finished
+func (r *Results) Get(id string) (runnable.Result, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result, ok := r.results[id]
+	return result, ok
+}
+
+// List returns all the results in the order the runnables finished
+func (r *Results) List() []runnable.Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	results := make([]runnable.Result, 0, len(r.order))
+	for _, id := range r.order {
+		results = append(results, r.results[id])
+	}
+	return results
+}
+
+// Module returns the results of the runnables within the module identified by
+// id, for example module.deploy or module.deploy["staging"] for a single instance
+// of a for_each module
+func (r *Results) Module(id string) *Results {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	child, ok := r.children[id]
+	if !ok {
+		child = NewResults()
+		r.children[id] = child
+		r.modules = append(r.modules, id)
+	}
+	return child
+}
+
+// Modules returns the identifiers of the modules which have results, in the order they started
+func (r *Results) Modules() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.modules...)
+}
+
+// ResultId returns the identifier of the result of block, which is the
+// identifier of the block in the dependency graph, for example var.name
+// or data.env.home
+func ResultId(block Block) string {
+	switch b := block.(type) {
+	case *Variable:
+		return x.RenderBlock(blocks.VarBlock, b.Id)
+	case *Data:
+		return x.RenderBlock(DataBlock, b.Provider, b.Id)
+	case *Local:
+		return b.Identifier()
+	}
+	return x.RenderBlock(block.Type(), block.Identifier())
+}
+
+// ResultValue is the object exposed as stage.<id> and module.<id> in the
+// evaluation context once the stage or the module has finished running
+func ResultValue(result runnable.Result) cty.Value {
+	return cty.ObjectVal(map[string]cty.Value{
+		"status":    cty.StringVal(result.Status.String()),
+		"exit_code": cty.NumberIntVal(int64(result.ExitCode)),
+		"duration":  cty.NumberFloatVal(result.Duration().Seconds()),
+		"attempts":  cty.NumberIntVal(int64(result.Attempts)),
+		"output":    cty.StringVal(result.Output),
+	})
+}
+
+// PublishResult records the result of a runnable. The results of stages and
+// modules are also exposed to the evaluation context, so that the runnables
+// which depend on them can refer to their status, exit code, duration, attempts
+// and output.
+func PublishResult(conductor *Conductor, block Block, result runnable.Result) {
+	conductor.Results().Record(result)
+	blockType := block.Type()
+	if blockType != blocks.StageBlock && blockType != blocks.ModuleBlock {
+		return
+	}
+
+	global.ResultEvalContextMutex.Lock()
+	defer global.ResultEvalContextMutex.Unlock()
+
+	conductor.Eval().Mutex().Lock()
+	defer conductor.Eval().Mutex().Unlock()
+
+	evalCtx := conductor.Eval().Context()
+	results := evalCtx.Variables[blockType]
+	var resultsMutated map[string]cty.Value
+	if results.IsNull() {
+		resultsMutated = make(map[string]cty.Value)
+	} else {
+		resultsMutated = results.AsValueMap()
+	}
+	resultsMutated[block.Identifier()] = ResultValue(result)
+	evalCtx.Variables[blockType] = cty.ObjectVal(resultsMutated)
+}
+
+// PublishSkipped records a runnable which was not run as skipped, for reason
+func PublishSkipped(conductor *Conductor, block Block, reason string) {
+	PublishResult(conductor, block, runnable.Result{
+		Id:     ResultId(block),
+		Status: runnable.StatusSkipped,
+		Reason: reason,
+	})
+}
+
+// PublishFailed records a runnable which failed before it could run, with diags
+func PublishFailed(conductor *Conductor, block Block, diags hcl.Diagnostics) {
+	now := time.Now()
+	PublishResult(conductor, block, runnable.Result{
+		Id:       ResultId(block),
+		Status:   runnable.StatusFailure,
+		ExitCode: -1,
+		Started:  now,
+		Finished: now,
+		Diags:    diags,
+	})
+}
+
+// attemptsResult completes the result recorded by a block during its last attempt
+// with the details of all of its attempts. A result is created for blocks which
+// do not record one themselves.
+func attemptsResult(conductor *Conductor, block Block, started time.Time, attempts int, success bool, diags hcl.Diagnostics, opts ...runnable.Option) runnable.Result {
+	id := ResultId(block)
+	result, ok := conductor.Results().Get(id)
+	if !ok {
+		result = runnable.Result{Id: id, Status: runnable.StatusSuccess}
+		if !success {
+			result.Status = runnable.StatusFailure
+			result.ExitCode = -1
+		}
+	}
+	result.Attempts = attempts
+	result.Started = started
+	result.Finished = time.Now()
+	result.Diags = diags
+	result.Reason = runnable.NewConfig(opts...).Reason
+	return result
+}
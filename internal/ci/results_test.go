@@ -0,0 +1,78 @@
+package ci
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResults_Record(t *testing.T) {
+	results := NewResults()
+	results.Record(runnable.Result{Id: "stage.b", Status: runnable.StatusFailure})
+	results.Record(runnable.Result{Id: "stage.a", Status: runnable.StatusSuccess})
+	results.Record(runnable.Result{Id: "stage.b", Status: runnable.StatusSuccess})
+
+	result, ok := results.Get("stage.b")
+	assert.True(t, ok)
+	assert.Equal(t, runnable.StatusSuccess, result.Status)
+
+	_, ok = results.Get("stage.c")
+	assert.False(t, ok)
+
+	var ids []string
+	for _, result := range results.List() {
+		ids = append(ids, result.Id)
+	}
+	assert.Equal(t, []string{"stage.b", "stage.a"}, ids)
+}
+
+func TestPublishResult(t *testing.T) {
+	conductor := &Conductor{
+		eval:    &Eval{context: &hcl.EvalContext{Variables: map[string]cty.Value{}}, mu: &sync.RWMutex{}},
+		results: NewResults(),
+	}
+	started := time.Now()
+	PublishResult(conductor, &Stage{Id: "build"}, runnable.Result{
+		Id:       "stage.build",
+		Status:   runnable.StatusSuccess,
+		Attempts: 2,
+		Started:  started,
+		Finished: started.Add(1500 * time.Millisecond),
+		Output:   "hello",
+	})
+	PublishSkipped(conductor, &Stage{Id: "lint"}, ReasonCondition)
+	PublishResult(conductor, &Data{Id: "env"}, runnable.Result{Id: "data.env"})
+
+	stages := conductor.Eval().Context().Variables["stage"]
+	build := stages.GetAttr("build")
+	assert.Equal(t, cty.StringVal("success"), build.GetAttr("status"))
+	assert.Equal(t, cty.NumberIntVal(0), build.GetAttr("exit_code"))
+	assert.Equal(t, cty.NumberIntVal(2), build.GetAttr("attempts"))
+	assert.Equal(t, cty.NumberFloatVal(1.5), build.GetAttr("duration"))
+	assert.Equal(t, cty.StringVal("hello"), build.GetAttr("output"))
+	assert.Equal(t, cty.StringVal("skipped"), stages.GetAttr("lint").GetAttr("status"))
+
+	_, ok := conductor.Eval().Context().Variables["data"]
+	assert.False(t, ok)
+	_, ok = conductor.Results().Get("data.env")
+	assert.True(t, ok)
+
+	result, ok := conductor.Results().Get("stage.lint")
+	assert.True(t, ok)
+	assert.Equal(t, ReasonCondition, result.Reason)
+}
+
+func TestResults_Module(t *testing.T) {
+	results := NewResults()
+	deploy := results.Module(`module.deploy["staging"]`)
+	deploy.Record(runnable.Result{Id: "stage.apply", Status: runnable.StatusSuccess})
+	results.Module("module.lint")
+
+	assert.Same(t, deploy, results.Module(`module.deploy["staging"]`))
+	assert.Equal(t, []string{`module.deploy["staging"]`, "module.lint"}, results.Modules())
+	assert.Len(t, results.List(), 0)
+}
@@ -1,32 +1,60 @@
 package ci
 
 import (
+	"fmt"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/kendru/darwin/go/depgraph"
 	"github.com/sirupsen/logrus"
 	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/dg"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
 	"github.com/srevinsaju/togomak/v1/internal/rules"
 	"github.com/srevinsaju/togomak/v1/internal/runnable"
 	"github.com/zclconf/go-cty/cty"
 	"time"
 )
 
-func BlockRunWithRetries(conductor *Conductor, runnableId string, runnable Block, handler *Handler, togomakLogger logrus.Ext1FieldLogger, opts ...runnable.Option) {
+// BlockRunWithRetries runs the runnable, and retries it if it fails and its retry
+// policy allows it. It returns the diagnostics of all the attempts, the errors
+// are downgraded to warnings when one of the retries succeeds, or when the
+// runnable is allowed to fail.
+func BlockRunWithRetries(conductor *Conductor, runnableId string, runnable Block, handler *Handler, togomakLogger logrus.Ext1FieldLogger, opts ...runnable.Option) hcl.Diagnostics {
 	logger := togomakLogger.WithField("orchestra", "run")
 	logger.Debug("starting runnable with retries ", runnableId)
-	stageDiags := runnable.Run(conductor, opts...)
+	started := time.Now()
+	attempts := 1
+	span := conductor.Tracer().Start(runnableId, runnable.Type(), runnableId).Arg("id", runnableId)
+	var phases []string
+	if span != nil || conductor.Metrics() != nil {
+		phases = blockPhases(conductor, runnable)
+		span.Arg("phase", phases)
+	}
+	defer func() {
+		result, _ := conductor.Results().Get(ResultId(runnable))
+		span.Arg("status", result.Status).Arg("attempts", attempts).Arg("exit_code", result.ExitCode)
+		if result.Status.Failed() {
+			span.Error(fmt.Sprintf("%s %s", runnableId, result.Status))
+		}
+		span.End()
+		if runnable.Type() == blocks.StageBlock || runnable.Type() == blocks.ModuleBlock {
+			conductor.Metrics().Runnable(runnableId, phases, result.Status, attempts, time.Since(started))
+		}
+	}()
+	stageDiags := runAttempt(conductor, runnableId, runnable, attempts, opts...)
 
-	handler.Tracker.AppendCompleted(runnable)
+	handler.Completed(runnable)
 	logger.Tracef("signaling runnable %s", runnableId)
 
 	if !stageDiags.HasErrors() {
+		PublishResult(conductor, runnable, attemptsResult(conductor, runnable, started, attempts, true, stageDiags, opts...))
 		if runnable.IsDaemon() {
-			handler.Tracker.DaemonDone()
+			handler.Tracker.DaemonDone(runnable)
 		} else {
-			handler.Tracker.RunnableDone()
+			handler.Tracker.RunnableDone(runnable)
 		}
-		return
+		return stageDiags
 	}
+	retrySuccess := false
 	if !runnable.CanRetry() {
 		logger.Debug("runnable cannot be retried")
 	} else {
@@ -34,9 +62,9 @@ func BlockRunWithRetries(conductor *Conductor, runnableId string, runnable Block
 		retryCount := 0
 		retryMinBackOff := time.Duration(runnable.MinRetryBackoff()) * time.Second
 		retryMaxBackOff := time.Duration(runnable.MaxRetryBackoff()) * time.Second
-		retrySuccess := false
 		for retryCount < runnable.MaxRetries() {
 			retryCount++
+			attempts++
 			sleepDuration := time.Duration(1) * time.Second
 			if runnable.RetryExponentialBackoff() {
 
@@ -50,7 +78,9 @@ func BlockRunWithRetries(conductor *Conductor, runnableId string, runnable Block
 			}
 			logger.Warnf("runnable %s failed, retrying in %s", runnableId, sleepDuration)
 			time.Sleep(sleepDuration)
-			sDiags := runnable.Run(conductor, opts...)
+			handler.Tracker.Attempt(runnable, attempts)
+			conductor.RunLogs().Retry(runnableId)
+			sDiags := runAttempt(conductor, runnableId, runnable, attempts, opts...)
 			stageDiags = append(stageDiags, sDiags...)
 
 			if !sDiags.HasErrors() {
@@ -61,35 +91,137 @@ func BlockRunWithRetries(conductor *Conductor, runnableId string, runnable Block
 
 		if !retrySuccess {
 			logger.Warnf("runnable %s failed after %d retries", runnableId, retryCount)
+		} else {
+			// the errors of the previous attempts do not fail the pipeline
+			stageDiags = dg.Warnings(stageDiags)
 		}
 
 	}
+	if !retrySuccess && runnable.FailureAllowed() {
+		logger.Warnf("runnable %s failed, continuing since it is allowed to fail", runnableId)
+		stageDiags = dg.Warnings(stageDiags)
+	}
+	PublishResult(conductor, runnable, attemptsResult(conductor, runnable, started, attempts, retrySuccess, stageDiags, opts...))
 	handler.Diags.Extend(stageDiags)
 	if runnable.IsDaemon() {
-		handler.Tracker.DaemonDone()
+		handler.Tracker.DaemonDone(runnable)
 	} else {
-		handler.Tracker.RunnableDone()
+		handler.Tracker.RunnableDone(runnable)
 	}
+	return stageDiags
 }
 
-func BlockCanRun(runnable Block, conductor *Conductor, runnableId string, depGraph *depgraph.Graph, opts ...runnable.Option) (ok bool, overridden bool, diags hcl.Diagnostics) {
+// runAttempt runs a single attempt of runnable
+func runAttempt(conductor *Conductor, runnableId string, runnable Block, attempt int, opts ...runnable.Option) hcl.Diagnostics {
+	span := conductor.Tracer().Start(runnableId, "attempt", fmt.Sprintf("attempt %d", attempt)).Arg("attempt", attempt)
+	diags := runnable.Run(conductor, opts...)
+	if diags.HasErrors() {
+		span.Error(logging.Secrets().Redact(diags.Error()))
+	}
+	span.End()
+	return diags
+}
 
-	filterList := conductor.Config.Pipeline.Filtered
-	filterQuery := conductor.Config.Pipeline.FilterQuery
+// blockPhases returns the lifecycle phases of runnable, which are the default
+// phase when runnable does not declare any
+func blockPhases(conductor *Conductor, runnable Block) []string {
+	phases := []string{"default"}
+	phased, ok := runnable.(PhasedBlock)
+	if !ok || phased.LifecycleConfig() == nil || phased.LifecycleConfig().Phase == nil {
+		return phases
+	}
+	conductor.Eval().Mutex().RLock()
+	v, d := phased.LifecycleConfig().Phase.Value(conductor.Eval().Context())
+	conductor.Eval().Mutex().RUnlock()
+	if d.HasErrors() || v.IsNull() || !v.CanIterateElements() {
+		return phases
+	}
+	phases = nil
+	for _, phase := range v.AsValueSlice() {
+		if phase.Type() == cty.String && phase.IsKnown() && !phase.IsNull() {
+			phases = append(phases, phase.AsString())
+		}
+	}
+	return phases
+}
+
+// BlockCanRun decides if runnable is run, from its condition, its run_when and
+// the filters. overridden is true if the filters select or exclude the runnable,
+// and reason explains why the runnable is skipped, or why it is run although its
+// condition evaluated to false.
+func BlockCanRun(runnable Block, conductor *Conductor, runnableId string, depGraph *depgraph.Graph, opts ...runnable.Option) (ok bool, overridden bool, reason string, diags hcl.Diagnostics) {
+	return blockCanRun(runnable, conductor, runnableId, depGraph, nil, opts...)
+}
+
+// blockCanRun is BlockCanRun, which records each step of the decision in explain, unless it is nil
+func blockCanRun(runnable Block, conductor *Conductor, runnableId string, depGraph *depgraph.Graph, explain *Explanation, opts ...runnable.Option) (ok bool, overridden bool, reason string, diags hcl.Diagnostics) {
 	ok, d := runnable.CanRun(conductor, opts...)
 	if d.HasErrors() {
 		diags = diags.Extend(d)
-		return false, false, diags
+		return false, false, "", diags
 	}
+	explain.record(ExplainCondition, ok, false, "the condition evaluated to %t", ok)
+	conditionReason := ReasonCondition
+
+	// run_when is applied before the filters, so that stages which are
+	// explicitly requested on the command line can still be forced to run
+	if ok {
+		ok, d = RunWhenSatisfied(runnable)
+		if d.HasErrors() {
+			diags = diags.Extend(d)
+			return false, false, "", diags
+		}
+		if stage, isStage := runnable.(*Stage); isStage {
+			runWhen, _ := stage.RunCondition()
+			explain.record(ExplainRunWhen, ok, false, "run_when is %q, with %d failed upstream runnable(s)", runWhen, len(stage.FailedUpstream()))
+		}
+		conditionReason = ReasonRunWhen
+	}
+
+	conditionOk := ok
+	ok, overridden, d = blockFiltered(runnable, conductor, runnableId, depGraph, ok, explain)
+	diags = diags.Extend(d)
+	if diags.HasErrors() {
+		return false, false, "", diags
+	}
+
+	switch {
+	case ok && overridden:
+		reason = ReasonIncluded
+	case !ok && overridden:
+		reason = ReasonExcluded
+	case !ok && !conditionOk:
+		reason = conditionReason
+	case !ok:
+		reason = ReasonNotSelected
+	}
+	return ok, overridden, reason, diags
+}
+
+// blockFiltered applies the filters and the lifecycle phases to runnable, conditionOk
+// is true if the condition and the run_when of the runnable are satisfied. Each
+// step is recorded in explain, unless it is nil.
+func blockFiltered(runnable Block, conductor *Conductor, runnableId string, depGraph *depgraph.Graph, conditionOk bool, explain *Explanation) (ok bool, overridden bool, diags hcl.Diagnostics) {
+	var d hcl.Diagnostics
+	ok = conditionOk
+	filterList := conductor.Config.Pipeline.Filtered
+	filterQuery := conductor.Config.Pipeline.FilterQuery
 
 	if runnable.Type() != blocks.StageBlock && runnable.Type() != blocks.ModuleBlock {
 		// TODO: optimize, PipelineRun only required data blocks
+		explain.record(ExplainFilter, ok, false, "filters only apply to stages and modules")
 		return ok, false, diags
 	}
 
 	runnable.Set(StageContextChildStatuses, filterList.Children(runnableId).Marshall())
 
 	if (runnable.Type() == blocks.StageBlock || runnable.Type() == blocks.ModuleBlock) && len(filterQuery) != 0 {
+		if explain != nil {
+			for _, engine := range filterQuery {
+				v, _, d := engine.Eval(conductor, ok, runnable.(PhasedBlock))
+				explain.record(ExplainQuery, v, true, "query %q evaluated to %t%s", engine.rule, v, explainErrors(d))
+			}
+		}
 		ok, overridden, d = filterQuery.Eval(conductor, ok, runnable.(PhasedBlock))
 		if d.HasErrors() {
 			diags = diags.Extend(d)
@@ -98,12 +230,14 @@ func BlockCanRun(runnable Block, conductor *Conductor, runnableId string, depGra
 	}
 
 	if len(filterList) == 0 {
+		explain.record(ExplainFilter, ok, overridden, "no filters were given, using the %q phase", "default")
 		filterList = append(filterList, rules.NewOperation(rules.OperationTypeAnd, "default"))
 	}
 	runnable.Set(StageContextChildStatuses, filterList.Children(runnableId).Marshall())
 
 	for _, rule := range filterList {
 		if rule.RunnableId() == "all" {
+			explain.record(ExplainFilter, ok, false, "filter %q selects every runnable whose condition is satisfied", rule)
 			return ok, false, diags
 		}
 	}
@@ -120,6 +254,9 @@ func BlockCanRun(runnable Block, conductor *Conductor, runnableId string, depGra
 	for _, phase := range conductor.Config.Behavior.Child.ParentLifecycles {
 		phases = append(phases, cty.StringVal(phase))
 	}
+	if len(phases) > 0 {
+		explain.record(ExplainPhase, oldOk, false, "inherits the phases %s of the parent pipeline", conductor.Config.Behavior.Child.ParentLifecycles)
+	}
 
 	var phasesDefined bool = len(phases) > 0
 	stage := runnable.(PhasedBlock)
@@ -133,31 +270,50 @@ func BlockCanRun(runnable Block, conductor *Conductor, runnableId string, depGra
 			return false, false, diags
 		}
 		phasesDefined = !phaseHcl.IsNull() || len(phases) > 0
-		phases = append(phases, phaseHcl.AsValueSlice()...)
+		if !phaseHcl.IsNull() {
+			phases = append(phases, phaseHcl.AsValueSlice()...)
+		}
+	}
+	if explain != nil {
+		var names []string
+		for _, phase := range phases {
+			names = append(names, phase.AsString())
+		}
+		if phasesDefined {
+			explain.record(ExplainPhase, oldOk, false, "has the phases %s", names)
+		} else {
+			explain.record(ExplainPhase, oldOk, false, "has no phases, and belongs to the %q phase", "default")
+		}
 	}
 
 	if runnable.Type() == blocks.ModuleBlock && len(phases) == 0 && !phasesDefined {
 		ok = oldOk
 		overridden = false
+		explain.record(ExplainPhase, ok, overridden, "modules without phases run when their condition is satisfied, the filters apply to their stages")
 		return ok, overridden, diags
 	}
 
+	recorded := explain.len()
 	for _, rule := range filterList {
 		if rule.RunnableId() == runnableId && rule.Operation() == rules.OperationTypeAdd {
 			ok = true
 			overridden = true
+			explain.record(ExplainFilter, ok, overridden, "filter %q adds the runnable", rule)
 		}
 		if rule.RunnableId() == runnableId && rule.Operation() == rules.OperationTypeSub {
 			ok = false
 			overridden = true
+			explain.record(ExplainFilter, ok, overridden, "filter %q removes the runnable", rule)
 		}
 		if rule.RunnableId() == runnableId && rule.Operation() == rules.OperationTypeAnd {
 			ok = oldOk
 			overridden = true
+			explain.record(ExplainFilter, ok, overridden, "filter %q selects the runnable, if its condition is satisfied", rule)
 		}
 		if rule.Operation() == rules.OperationTypeAnd && depGraph.DependsOn(rule.RunnableId(), runnableId) {
 			ok = oldOk
 			overridden = true
+			explain.record(ExplainFilter, ok, overridden, "filter %q selects %s, which depends on the runnable", rule, rule.RunnableId())
 		}
 		if runnable.Type() == blocks.StageBlock || runnable.Type() == blocks.ModuleBlock {
 			if phasesDefined {
@@ -165,19 +321,25 @@ func BlockCanRun(runnable Block, conductor *Conductor, runnableId string, depGra
 					if rule.RunnableId() == phase.AsString() {
 						overridden = false
 						ok = oldOk
+						explain.record(ExplainFilter, ok, overridden, "filter %q matches the phase %s", rule, phase.AsString())
 					}
 				}
 				if len(phases) == 0 && rule.RunnableId() == "default" {
 					ok = oldOk
 					overridden = false
+					explain.record(ExplainFilter, ok, overridden, "filter %q matches the %q phase", rule, "default")
 				}
 			} else {
 				if rule.RunnableId() == "default" {
 					ok = oldOk
 					overridden = false
+					explain.record(ExplainFilter, ok, overridden, "filter %q matches the %q phase", rule, "default")
 				}
 			}
 		}
 	}
+	if explain.len() == recorded {
+		explain.record(ExplainFilter, ok, overridden, "no filter matches the runnable or its phases")
+	}
 	return ok, overridden, diags
 }
@@ -0,0 +1,367 @@
+package ci
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LogsDir is the directory within meta.BuildDirPrefix where the logs of each run are stored
+	LogsDir = "logs"
+
+	// LogFileSuffix is the suffix of the log file of each runnable
+	LogFileSuffix = ".log"
+)
+
+// RunLogs writes the output of each runnable of a run to its own file, under
+// .togomak/logs/<run id>/<runnable>.log, so that the output of a runnable can be
+// read without the output of the runnables which ran along with it. The logs of
+// the runnables of a module are in a directory named after the module. Like
+// Tracer, the methods of RunLogs do nothing on a nil RunLogs.
+type RunLogs struct {
+	r      *runLogs
+	prefix []string
+}
+
+type runLogs struct {
+	dir string
+
+	mu      sync.Mutex
+	writers map[string]*runLogWriter
+	retries map[string]int
+}
+
+// RunLogsDir returns the directory of the logs of the run identified by id, for the pipeline in dir
+func RunLogsDir(dir string, id string) string {
+	return filepath.Join(dir, meta.BuildDirPrefix, LogsDir, id)
+}
+
+// NewRunLogs creates the logs of the run identified by id, for the pipeline in
+// dir. Only the logs of the keep latest runs, including this one, are kept, the
+// logs are not written when keep is 0.
+func NewRunLogs(dir string, id string, keep int) (*RunLogs, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	if err := pruneRuns(filepath.Join(dir, meta.BuildDirPrefix, LogsDir), keep-1); err != nil {
+		return nil, err
+	}
+	runDir := RunLogsDir(dir, id)
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return nil, err
+	}
+	return &RunLogs{r: &runLogs{
+		dir:     runDir,
+		writers: make(map[string]*runLogWriter),
+		retries: make(map[string]int),
+	}}, nil
+}
+
+// pruneRuns removes the directories of the oldest runs in dir, so that only
+// the keep latest runs are left
+func pruneRuns(dir string, keep int) error {
+	runs, err := runsByAge(dir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(runs); i++ {
+		if err := os.RemoveAll(filepath.Join(dir, runs[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runsByAge returns the identifiers of the runs with a directory in dir,
+// the latest run first
+func runsByAge(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	modified := make(map[string]time.Time)
+	var runs []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		modified[entry.Name()] = info.ModTime()
+		runs = append(runs, entry.Name())
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return modified[runs[i]].After(modified[runs[j]])
+	})
+	return runs, nil
+}
+
+// LatestRunLogs returns the identifier of the latest run with logs, for the
+// pipeline in dir. The logs of a run are created when it starts, before its
+// state is stored.
+func LatestRunLogs(dir string) (string, error) {
+	runs, err := runsByAge(filepath.Join(dir, meta.BuildDirPrefix, LogsDir))
+	if err != nil {
+		return "", err
+	}
+	if len(runs) == 0 {
+		return "", fmt.Errorf("no logs of previous runs were found in %s", filepath.Join(dir, meta.BuildDirPrefix, LogsDir))
+	}
+	return runs[0], nil
+}
+
+// Module returns the logs of the runnables within the module identified by id,
+// which are written in a directory named after the module
+func (l *RunLogs) Module(id string) *RunLogs {
+	if l == nil {
+		return nil
+	}
+	prefix := append(append([]string(nil), l.prefix...), id)
+	return &RunLogs{r: l.r, prefix: prefix}
+}
+
+// segments returns the identifiers of the modules runnableId is within, followed
+// by runnableId, which are joined by key
+func (l *RunLogs) segments(runnableId string) []string {
+	return append(append([]string(nil), l.prefix...), runnableId)
+}
+
+// key returns the identifier of runnableId within the run, such as
+// module.deploy/stage.build
+func (l *RunLogs) key(runnableId string) string {
+	return strings.Join(l.segments(runnableId), "/")
+}
+
+// Retry records that runnableId is run again, after it failed. The output of
+// each attempt after the first one is preceded by the number of the attempt in
+// the log file, for runnableId and for the runnables within it when it is a
+// module.
+func (l *RunLogs) Retry(runnableId string) {
+	if l == nil {
+		return
+	}
+	l.r.mu.Lock()
+	defer l.r.mu.Unlock()
+	l.r.retries[l.key(runnableId)]++
+}
+
+// attempt returns the attempt of the runnable identified by segments which is
+// running, counting the retries of the runnable and of the modules it is within.
+// The instances of a runnable using for_each, such as stage.x["a"], are retried
+// along with it.
+func (r *runLogs) attempt(segments []string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempt := 1
+	for i := range segments {
+		attempt += r.retries[strings.Join(segments[:i+1], "/")]
+		if j := strings.LastIndex(segments[i], "["); j > 0 {
+			instanceOf := append(append([]string(nil), segments[:i]...), segments[i][:j])
+			attempt += r.retries[strings.Join(instanceOf, "/")]
+		}
+	}
+	return attempt
+}
+
+// Writer returns the writer of the log file of runnableId. The secrets are
+// masked in each line written to it.
+func (l *RunLogs) Writer(runnableId string) io.Writer {
+	if l == nil {
+		return io.Discard
+	}
+	key := l.key(runnableId)
+	l.r.mu.Lock()
+	defer l.r.mu.Unlock()
+	w, ok := l.r.writers[key]
+	if !ok {
+		// each module is a directory, the keys of for_each instances may contain slashes too
+		segments := l.segments(runnableId)
+		names := make([]string, len(segments))
+		for i := range segments {
+			names[i] = strings.ReplaceAll(segments[i], "/", "_")
+		}
+		w = &runLogWriter{
+			logs:     l.r,
+			segments: segments,
+			path:     filepath.Join(l.r.dir, filepath.Join(names...)+LogFileSuffix),
+			attempt:  1,
+		}
+		l.r.writers[key] = w
+	}
+	return w
+}
+
+// Close writes the last lines of the log files, which do not end with a newline,
+// and closes them
+func (l *RunLogs) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.r.mu.Lock()
+	writers := make([]*runLogWriter, 0, len(l.r.writers))
+	for _, w := range l.r.writers {
+		writers = append(writers, w)
+	}
+	l.r.mu.Unlock()
+
+	var errs []error
+	for _, w := range writers {
+		if err := w.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not write the logs of the run: %v", errs)
+	}
+	return nil
+}
+
+// runLogWriter writes the output of a runnable to its log file line by line,
+// so that the secrets are masked even when a line is written in several parts.
+// The file is created when the runnable writes its first line.
+type runLogWriter struct {
+	logs     *runLogs
+	segments []string
+	path     string
+
+	mu      sync.Mutex
+	file    *os.File
+	buf     []byte
+	attempt int
+	err     error
+}
+
+func (w *runLogWriter) Write(p []byte) (int, error) {
+	attempt := w.logs.attempt(w.segments)
+
+	// the errors are reported by Close, a stage does not fail when its
+	// output cannot be written to its log file
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return len(p), nil
+	}
+	if attempt != w.attempt {
+		w.flush()
+		w.attempt = attempt
+		w.write([]byte(fmt.Sprintf("--- attempt %d ---\n", attempt)))
+	}
+	w.buf = append(w.buf, p...)
+	if i := bytes.LastIndexByte(w.buf, '\n'); i >= 0 {
+		w.write([]byte(logging.Secrets().Redact(string(w.buf[:i+1]))))
+		w.buf = append(w.buf[:0], w.buf[i+1:]...)
+	}
+	return len(p), nil
+}
+
+// flush writes the last line, which does not end with a newline yet
+func (w *runLogWriter) flush() {
+	if len(w.buf) == 0 {
+		return
+	}
+	w.write([]byte(logging.Secrets().Redact(string(w.buf)) + "\n"))
+	w.buf = w.buf[:0]
+}
+
+func (w *runLogWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	if w.file == nil {
+		if w.err = os.MkdirAll(filepath.Dir(w.path), 0755); w.err != nil {
+			return
+		}
+		w.file, w.err = os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if w.err != nil {
+			return
+		}
+	}
+	_, w.err = w.file.Write(p)
+}
+
+func (w *runLogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush()
+	if w.file != nil {
+		if err := w.file.Close(); err != nil && w.err == nil {
+			w.err = err
+		}
+		w.file = nil
+	}
+	return w.err
+}
+
+// ListRunLogs returns the runnables with a log file in the logs of the run
+// identified by id, for the pipeline in dir, such as stage.build or
+// module.deploy/stage.build, sorted by their identifiers
+func ListRunLogs(dir string, id string) ([]string, error) {
+	runDir := RunLogsDir(dir, id)
+	var runnables []string
+	err := filepath.WalkDir(runDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, LogFileSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(runDir, path)
+		if err != nil {
+			return err
+		}
+		runnables = append(runnables, strings.TrimSuffix(filepath.ToSlash(rel), LogFileSuffix))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(runnables)
+	return runnables, nil
+}
+
+// RunLogPath returns the path of the log file of runnable, as returned by
+// ListRunLogs, in the logs of the run identified by id
+func RunLogPath(dir string, id string, runnable string) string {
+	return filepath.Join(RunLogsDir(dir, id), filepath.FromSlash(runnable)+LogFileSuffix)
+}
+
+// SelectRunLogs returns the runnables in available, as returned by ListRunLogs,
+// which are one of runnables, an instance of one of them, such as stage.x["a"] for
+// stage.x, or a runnable of one of the modules in runnables. All of available is
+// returned when runnables is empty. The runnables which match none of available
+// are returned as missing.
+func SelectRunLogs(available []string, runnables []string) (selected []string, missing []string) {
+	if len(runnables) == 0 {
+		return available, nil
+	}
+	matched := make(map[string]bool)
+	for _, runnable := range available {
+		for _, want := range runnables {
+			if runnable == want || strings.HasPrefix(runnable, want+"[") || strings.HasPrefix(runnable, want+"/") {
+				matched[want] = true
+				selected = append(selected, runnable)
+				break
+			}
+		}
+	}
+	for _, want := range runnables {
+		if !matched[want] {
+			missing = append(missing, want)
+		}
+	}
+	return selected, missing
+}
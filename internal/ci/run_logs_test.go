@@ -0,0 +1,130 @@
+package ci
+
+import (
+	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunLogs(t *testing.T) {
+	dir := t.TempDir()
+	logs, err := NewRunLogs(dir, "run", 10)
+	assert.NoError(t, err)
+	logging.Secrets().Add("run-logs-secret")
+
+	w := logs.Writer("stage.build")
+	_, _ = io.WriteString(w, "building\ntoken=run-logs")
+	_, _ = io.WriteString(w, "-secret\nunfinished")
+
+	logs.Retry("stage.flaky")
+	_, _ = io.WriteString(logs.Writer("stage.flaky"), "second try\n")
+	_, _ = io.WriteString(logs.Module("module.deploy").Writer("stage.apply"), "applied\n")
+	assert.NoError(t, logs.Close())
+
+	runnables, err := ListRunLogs(dir, "run")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"module.deploy/stage.apply", "stage.build", "stage.flaky"}, runnables)
+
+	content, err := os.ReadFile(RunLogPath(dir, "run", "stage.build"))
+	assert.NoError(t, err)
+	assert.Equal(t, "building\ntoken="+logging.Redacted+"\nunfinished\n", string(content))
+
+	content, err = os.ReadFile(RunLogPath(dir, "run", "stage.flaky"))
+	assert.NoError(t, err)
+	assert.Equal(t, "--- attempt 2 ---\nsecond try\n", string(content))
+
+	content, err = os.ReadFile(RunLogPath(dir, "run", "module.deploy/stage.apply"))
+	assert.NoError(t, err)
+	assert.Equal(t, "applied\n", string(content))
+}
+
+func TestRunLogs_ModuleRetry(t *testing.T) {
+	dir := t.TempDir()
+	logs, err := NewRunLogs(dir, "run", 10)
+	assert.NoError(t, err)
+
+	// the stages of a module are retried by themselves, or along with the module
+	deploy := logs.Module("module.deploy")
+	w := deploy.Writer("stage.apply")
+	_, _ = io.WriteString(w, "first\n")
+	deploy.Retry("stage.apply")
+	_, _ = io.WriteString(w, "second\n")
+	logs.Retry("module.deploy")
+	_, _ = io.WriteString(w, "third\n")
+
+	// the instances of a module are retried along with it
+	logs.Retry("module.region")
+	_, _ = io.WriteString(logs.Module(`module.region["eu"]`).Writer("stage.apply"), "applied\n")
+	assert.NoError(t, logs.Close())
+
+	content, err := os.ReadFile(RunLogPath(dir, "run", "module.deploy/stage.apply"))
+	assert.NoError(t, err)
+	assert.Equal(t, "first\n--- attempt 2 ---\nsecond\n--- attempt 3 ---\nthird\n", string(content))
+
+	content, err = os.ReadFile(RunLogPath(dir, "run", `module.region["eu"]/stage.apply`))
+	assert.NoError(t, err)
+	assert.Equal(t, "--- attempt 2 ---\napplied\n", string(content))
+}
+
+func TestRunLogs_Disabled(t *testing.T) {
+	dir := t.TempDir()
+	logs, err := NewRunLogs(dir, "run", 0)
+	assert.NoError(t, err)
+	assert.Nil(t, logs)
+
+	_, err = io.WriteString(logs.Module("module.deploy").Writer("stage.build"), "building\n")
+	assert.NoError(t, err)
+	assert.NoError(t, logs.Close())
+	assert.NoDirExists(t, RunLogsDir(dir, "run"))
+}
+
+func TestRunLogs_Prune(t *testing.T) {
+	dir := t.TempDir()
+	for i, id := range []string{"oldest", "older", "old"} {
+		assert.NoError(t, os.MkdirAll(RunLogsDir(dir, id), 0755))
+		modified := time.Now().Add(-time.Duration(3-i) * time.Hour)
+		assert.NoError(t, os.Chtimes(RunLogsDir(dir, id), modified, modified))
+	}
+
+	_, err := NewRunLogs(dir, "latest", 2)
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(filepath.Dir(RunLogsDir(dir, "latest")))
+	assert.NoError(t, err)
+	var runs []string
+	for _, entry := range entries {
+		runs = append(runs, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"old", "latest"}, runs)
+
+	latest, err := LatestRunLogs(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "latest", latest)
+}
+
+func TestSelectRunLogs(t *testing.T) {
+	available := []string{
+		`module.deploy/stage.apply`,
+		`module.deploy["eu"]/stage.apply`,
+		`stage.build`,
+		`stage.build_docs`,
+		`stage.test["unit"]`,
+	}
+
+	selected, missing := SelectRunLogs(available, nil)
+	assert.Equal(t, available, selected)
+	assert.Empty(t, missing)
+
+	selected, missing = SelectRunLogs(available, []string{"stage.build", "stage.test", "module.deploy", "stage.lint"})
+	assert.Equal(t, []string{
+		`module.deploy/stage.apply`,
+		`module.deploy["eu"]/stage.apply`,
+		`stage.build`,
+		`stage.test["unit"]`,
+	}, selected)
+	assert.Equal(t, []string{"stage.lint"}, missing)
+}
@@ -0,0 +1,98 @@
+package ci
+
+import (
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"sort"
+	"time"
+)
+
+// ProfileEntry is the time a runnable took in a run
+type ProfileEntry struct {
+	Id       string
+	Status   runnable.StatusType
+	Duration time.Duration
+}
+
+// Profile summarises where the time of a run was spent
+type Profile struct {
+	Duration time.Duration
+
+	// Runnables has every runnable of the run, the slowest first
+	Runnables []ProfileEntry
+
+	// CriticalPath is the chain of dependent runnables which took the longest to
+	// complete, in the order they ran. The run cannot be faster than the critical
+	// path, unless one of the runnables on it is made faster.
+	CriticalPath []ProfileEntry
+}
+
+// NewProfile creates the profile of the run described by state
+func NewProfile(state *RunState) Profile {
+	profile := Profile{}
+	if !state.Finished.IsZero() {
+		profile.Duration = state.Finished.Sub(state.Started)
+	}
+
+	entries := make(map[string]ProfileEntry)
+	for id, s := range state.Runnables {
+		entry := ProfileEntry{Id: id, Status: s.Status}
+		if s.Result != nil {
+			entry.Duration = s.Result.Duration()
+		}
+		entries[id] = entry
+		profile.Runnables = append(profile.Runnables, entry)
+	}
+	sort.SliceStable(profile.Runnables, func(i, j int) bool {
+		a, b := profile.Runnables[i], profile.Runnables[j]
+		if a.Duration != b.Duration {
+			return a.Duration > b.Duration
+		}
+		return a.Id < b.Id
+	})
+
+	// the graph has the transitive dependencies of each runnable, a runnable
+	// always has more dependencies than any of the runnables it depends on
+	ids := make([]string, 0, len(state.Graph))
+	for id := range state.Graph {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if len(state.Graph[ids[i]]) != len(state.Graph[ids[j]]) {
+			return len(state.Graph[ids[i]]) < len(state.Graph[ids[j]])
+		}
+		return ids[i] < ids[j]
+	})
+
+	// completed is the earliest time each runnable could complete after the
+	// run started, through the slowest chain of runnables it depends on
+	completed := make(map[string]time.Duration)
+	previous := make(map[string]string)
+	var last string
+	for _, id := range ids {
+		var slowest time.Duration
+		for _, dependency := range state.Graph[id] {
+			c, ok := completed[dependency]
+			if !ok {
+				continue
+			}
+			// on a tie, the dependency which is furthest down the chain is preferred,
+			// so that the runnables which took no time are still on the path
+			p := previous[id]
+			if p == "" || c > slowest || (c == slowest && len(state.Graph[dependency]) > len(state.Graph[p])) {
+				slowest = c
+				previous[id] = dependency
+			}
+		}
+		completed[id] = slowest + entries[id].Duration
+		if last == "" || completed[id] >= completed[last] {
+			last = id
+		}
+	}
+
+	for id := last; id != ""; id = previous[id] {
+		if entry, ok := entries[id]; ok {
+			profile.CriticalPath = append([]ProfileEntry{entry}, profile.CriticalPath...)
+		}
+	}
+	return profile
+}
@@ -0,0 +1,66 @@
+package ci
+
+import (
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewProfile(t *testing.T) {
+	started := time.Now()
+	result := func(id string, took time.Duration) *RunnableState {
+		return &RunnableState{
+			Status: runnable.StatusSuccess,
+			Result: &runnable.Result{Id: id, Status: runnable.StatusSuccess, Started: started, Finished: started.Add(took)},
+		}
+	}
+
+	state := &RunState{
+		Started:  started,
+		Finished: started.Add(10 * time.Second),
+		Graph: map[string][]string{
+			"togomak.pre":  {},
+			"var.name":     {"togomak.pre"},
+			"stage.fetch":  {"togomak.pre", "var.name"},
+			"stage.lint":   {"togomak.pre", "var.name"},
+			"stage.build":  {"togomak.pre", "var.name", "stage.fetch"},
+			"stage.deploy": {"togomak.pre", "var.name", "stage.fetch", "stage.lint", "stage.build"},
+		},
+		Runnables: map[string]*RunnableState{
+			"var.name":     result("var.name", 0),
+			"stage.fetch":  result("stage.fetch", 2*time.Second),
+			"stage.lint":   result("stage.lint", 5*time.Second),
+			"stage.build":  result("stage.build", 4*time.Second),
+			"stage.deploy": result("stage.deploy", time.Second),
+		},
+	}
+
+	profile := NewProfile(state)
+	assert.Equal(t, 10*time.Second, profile.Duration)
+
+	var slowest []string
+	for _, entry := range profile.Runnables {
+		slowest = append(slowest, entry.Id)
+	}
+	assert.Equal(t, []string{"stage.lint", "stage.build", "stage.fetch", "stage.deploy", "var.name"}, slowest)
+
+	// fetch and build take 6 seconds together, which is longer than lint
+	var path []string
+	for _, entry := range profile.CriticalPath {
+		path = append(path, entry.Id)
+	}
+	assert.Equal(t, []string{"var.name", "stage.fetch", "stage.build", "stage.deploy"}, path)
+}
+
+func TestNewProfile_Unfinished(t *testing.T) {
+	state := &RunState{
+		Started:   time.Now(),
+		Graph:     map[string][]string{"stage.build": {}},
+		Runnables: map[string]*RunnableState{"stage.build": {Status: runnable.StatusFailure}},
+	}
+	profile := NewProfile(state)
+	assert.Zero(t, profile.Duration)
+	assert.Len(t, profile.CriticalPath, 1)
+	assert.Equal(t, runnable.StatusFailure, profile.CriticalPath[0].Status)
+}
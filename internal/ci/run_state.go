@@ -0,0 +1,472 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/global"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"github.com/srevinsaju/togomak/v1/internal/rules"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/srevinsaju/togomak/v1/internal/third-party/hashicorp/terraform/lang/marks"
+	"github.com/srevinsaju/togomak/v1/internal/x"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// RunsDir is the directory within meta.BuildDirPrefix where the state of each run is stored
+	RunsDir = "runs"
+
+	// RunStateFile is the name of the file with the state of a run, within the directory of the run
+	RunStateFile = "state.json"
+)
+
+// RunnableState is the state of a single runnable within a run
+type RunnableState struct {
+	Status runnable.StatusType `json:"status"`
+
+	// Result is the result of a stage or a module
+	Result *runnable.Result `json:"result,omitempty"`
+}
+
+// StateValue is a cty.Value along with its type, so that it can be decoded again
+type StateValue struct {
+	Type  json.RawMessage `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// RunState is the state of a run of a pipeline, which is stored under
+// .togomak/runs/<run id>/state.json as the runnables complete, so that
+// a run which failed can be resumed with togomak resume
+type RunState struct {
+	// Id is the identifier of the process which ran the pipeline
+	Id string `json:"id"`
+
+	// ResumedFrom is the identifier of the run this run has resumed, if any
+	ResumedFrom string `json:"resumed_from,omitempty"`
+
+	Pipeline string    `json:"pipeline"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+
+	// Args and Queries are the filters the pipeline was run with
+	Args    []string `json:"args,omitempty"`
+	Queries []string `json:"queries,omitempty"`
+
+	// Graph has the runnables which each runnable depends on
+	Graph map[string][]string `json:"graph"`
+
+	Runnables map[string]*RunnableState `json:"runnables"`
+
+	// Outputs is the content of the TOGOMAK_OUTPUTS file
+	Outputs string `json:"outputs"`
+
+	// Variables and Data are the values of the var and data blocks which were resolved
+	Variables *StateValue `json:"variables,omitempty"`
+	Data      *StateValue `json:"data,omitempty"`
+
+	// Sensitive are the var and data blocks with sensitive values, which are stored
+	// as null. They are run again when the run is resumed.
+	Sensitive []string `json:"sensitive,omitempty"`
+
+	mu   sync.Mutex
+	path string
+}
+
+// RunStatePath returns the path of the state file of the run identified by id, for the pipeline in dir
+func RunStatePath(dir string, id string) string {
+	return filepath.Join(dir, meta.BuildDirPrefix, RunsDir, id, RunStateFile)
+}
+
+// NewRunState creates the state of the run of the current process, which is
+// stored in the directory of the pipeline
+func NewRunState(conductor *Conductor, depGraph *depgraph.Graph) *RunState {
+	state := &RunState{
+		Id:        conductor.Process.Id.String(),
+		Pipeline:  conductor.Config.Paths.Pipeline,
+		Started:   conductor.Process.BootTime,
+		Args:      conductor.Config.Pipeline.Filtered.Marshall(),
+		Queries:   conductor.Config.Pipeline.FilterQuery.Marshall(),
+		Graph:     make(map[string][]string),
+		Runnables: make(map[string]*RunnableState),
+		path:      RunStatePath(conductor.Config.Paths.Cwd, conductor.Process.Id.String()),
+	}
+	for _, layer := range depGraph.TopoSortedLayers() {
+		for _, runnableId := range layer {
+			dependencies := []string{}
+			for dependency := range depGraph.Dependencies(runnableId) {
+				dependencies = append(dependencies, dependency)
+			}
+			sort.Strings(dependencies)
+			state.Graph[runnableId] = dependencies
+		}
+	}
+	return state
+}
+
+// PruneRunStates removes the state of the oldest runs of the pipeline in dir,
+// before a new run is started, so that only the state of the keep latest runs,
+// including the new one, is left as with NewRunLogs. The state of the latest
+// previous run is always kept, so that it can be resumed.
+func PruneRunStates(dir string, keep int) error {
+	previous := keep - 1
+	if previous < 1 {
+		previous = 1
+	}
+	return pruneRuns(filepath.Join(dir, meta.BuildDirPrefix, RunsDir), previous)
+}
+
+// LoadRunState reads the state of the run identified by id for the pipeline in dir.
+// The latest run is loaded when id is empty.
+func LoadRunState(dir string, id string) (*RunState, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	if id == "" {
+		entries, err := os.ReadDir(filepath.Join(dir, meta.BuildDirPrefix, RunsDir))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "could not list previous runs",
+				Detail:   err.Error(),
+			})
+		}
+		var latest *RunState
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			state, d := LoadRunState(dir, entry.Name())
+			if d.HasErrors() {
+				continue
+			}
+			if latest == nil || state.Started.After(latest.Started) {
+				latest = state
+			}
+		}
+		if latest == nil {
+			return nil, diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "no previous runs",
+				Detail:   fmt.Sprintf("no previous runs were found in %s", filepath.Join(dir, meta.BuildDirPrefix, RunsDir)),
+			})
+		}
+		return latest, diags
+	}
+
+	path := RunStatePath(dir, id)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "could not read the state of the run",
+			Detail:   fmt.Sprintf("run %s: %s", id, err.Error()),
+		})
+	}
+	state := &RunState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "could not parse the state of the run",
+			Detail:   fmt.Sprintf("%s: %s", path, err.Error()),
+		})
+	}
+	state.path = path
+	return state, diags
+}
+
+// Dispatch records that runnableId has started running. Like all the methods
+// which record the status of a runnable, it does nothing on a nil RunState.
+func (s *RunState) Dispatch(runnableId string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Runnables[runnableId] = &RunnableState{Status: runnable.StatusRunning}
+}
+
+// Complete records that runnableId has finished, and saves the state
+func (s *RunState) Complete(conductor *Conductor, runnableId string, block Block, ok bool) {
+	status := runnable.StatusSuccess
+	if !ok {
+		status = runnable.StatusFailure
+	}
+	s.record(conductor, runnableId, block, status)
+}
+
+// Fail records that runnableId has failed before it could run, and saves the state
+func (s *RunState) Fail(conductor *Conductor, runnableId string, block Block) {
+	s.record(conductor, runnableId, block, runnable.StatusFailure)
+}
+
+// Skip records that runnableId was not run, and saves the state
+func (s *RunState) Skip(conductor *Conductor, runnableId string, block Block) {
+	s.record(conductor, runnableId, block, runnable.StatusSkipped)
+}
+
+// Finish records that the run has finished, and saves the state
+func (s *RunState) Finish(conductor *Conductor) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Finished = time.Now()
+	s.mu.Unlock()
+	s.save(conductor)
+}
+
+// record records the status of runnableId. The status of stages and
+// modules is taken from their result, when they have one.
+func (s *RunState) record(conductor *Conductor, runnableId string, block Block, status runnable.StatusType) {
+	if s == nil {
+		return
+	}
+	state := &RunnableState{Status: status}
+	if block != nil {
+		if result, ok := conductor.Results().Get(ResultId(block)); ok {
+			result.Output = logging.Secrets().Redact(result.Output)
+			state.Status = result.Status
+			state.Result = &result
+		}
+	}
+
+	s.mu.Lock()
+	s.Runnables[runnableId] = state
+	s.mu.Unlock()
+	s.save(conductor)
+}
+
+func (s *RunState) save(conductor *Conductor) {
+	if err := s.Save(conductor); err != nil {
+		conductor.Logger().Warnf("could not save the state of the run: %s", err)
+	}
+}
+
+// Succeeded returns true if runnableId succeeded in this run, and can be restored when it is resumed
+func (s *RunState) Succeeded(runnableId string) bool {
+	state, ok := s.Runnables[runnableId]
+	return ok && (state.Status == runnable.StatusSuccess || state.Status == runnable.StatusCached)
+}
+
+// Restore makes the outcome of runnableId from this run available to the
+// runnables which depend on it, instead of running it again. Only stages,
+// modules, variables and data blocks are restored, the values of variables
+// and data blocks are restored by Resume. The variables with a value given
+// when resuming, such as with --var, are resolved again so that the new value is used.
+func (s *RunState) Restore(conductor *Conductor, runnableId string, block Block) bool {
+	if block.IsDaemon() || !s.Succeeded(runnableId) {
+		return false
+	}
+	state := s.Runnables[runnableId]
+	switch block.Type() {
+	case blocks.StageBlock, blocks.ModuleBlock:
+		if state.Result == nil {
+			return false
+		}
+	case blocks.VariableBlock, DataBlock:
+		if v, ok := block.(*Variable); ok && v.given(conductor) {
+			return false
+		}
+		for _, sensitive := range s.Sensitive {
+			if sensitive == runnableId {
+				return false
+			}
+		}
+	default:
+		return false
+	}
+	if state.Result != nil {
+		result := *state.Result
+		result.Reason = fmt.Sprintf("succeeded in run %s", s.Id)
+		PublishResult(conductor, block, result)
+	}
+	return true
+}
+
+// Resume prepares conductor to resume this run. The filters this run was started
+// with are used, unless new ones are given, and the TOGOMAK_OUTPUTS file and the
+// values of the variables and data blocks from this run are restored, except
+// the sensitive ones.
+func (s *RunState) Resume(conductor *Conductor) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if len(conductor.Config.Pipeline.Filtered) == 0 && len(conductor.Config.Pipeline.FilterQuery) == 0 {
+		filtered, d := rules.Unmarshal(s.Args)
+		diags = diags.Extend(d)
+		queries, d := NewSlice(s.Queries)
+		diags = diags.Extend(d)
+		conductor.Config.Pipeline.Filtered = filtered
+		conductor.Config.Pipeline.FilterQuery = queries
+	}
+	global.OutputsFileMutex.Lock()
+	err := os.WriteFile(filepath.Join(conductor.Process.TempDir, meta.OutputEnvFile), []byte(s.Outputs), 0644)
+	global.OutputsFileMutex.Unlock()
+	if err != nil {
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "could not restore outputs",
+			Detail:   err.Error(),
+		})
+	}
+
+	values := map[string]*StateValue{blocks.VarBlock: s.Variables, DataBlock: s.Data}
+	for name, value := range values {
+		if value == nil {
+			continue
+		}
+		v, err := value.decode()
+		if err != nil {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("could not restore %s values", name),
+				Detail:   err.Error(),
+			})
+			continue
+		}
+		conductor.Eval().Mutex().Lock()
+		conductor.Eval().Context().Variables[name] = v
+		conductor.Eval().Mutex().Unlock()
+	}
+	return diags
+}
+
+// Save stores the state of the run, along with the current outputs
+// and the values of the variables and data blocks
+func (s *RunState) Save(conductor *Conductor) error {
+	global.OutputsFileMutex.Lock()
+	outputs, err := os.ReadFile(filepath.Join(conductor.Process.TempDir, meta.OutputEnvFile))
+	global.OutputsFileMutex.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	conductor.Eval().Mutex().RLock()
+	variables, variablesOk := conductor.Eval().Context().Variables[blocks.VarBlock]
+	data, dataOk := conductor.Eval().Context().Variables[DataBlock]
+	conductor.Eval().Mutex().RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Outputs = string(outputs)
+	var sensitive, d []string
+	if variablesOk {
+		if s.Variables, sensitive, err = newStateValue(blocks.VarBlock, variables, 1); err != nil {
+			return err
+		}
+	}
+	if dataOk {
+		if s.Data, d, err = newStateValue(DataBlock, data, 2); err != nil {
+			return err
+		}
+		sensitive = append(sensitive, d...)
+	}
+	sort.Strings(sensitive)
+	s.Sensitive = sensitive
+
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	// the state is renamed into place once it is written, so that a crash while
+	// it is written does not corrupt the state a resume depends on
+	f, err := os.CreateTemp(filepath.Dir(s.path), "."+RunStateFile+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+// newStateValue creates the StateValue of v, the values of the name block. The
+// runnables with a sensitive value, identified by the first depth attributes of
+// the path of the value, are stored as null and their identifiers are returned.
+func newStateValue(name string, v cty.Value, depth int) (*StateValue, []string, error) {
+	v, paths := v.UnmarkDeepWithPaths()
+	sensitive := make(map[string]bool)
+	for _, pvm := range paths {
+		if _, ok := pvm.Marks[marks.Sensitive]; !ok {
+			continue
+		}
+		if id, ok := stateValueId(name, pvm.Path, depth); ok {
+			sensitive[id] = true
+		}
+	}
+	ids := make([]string, 0, len(sensitive))
+	if len(sensitive) > 0 {
+		var err error
+		v, err = cty.Transform(v, func(path cty.Path, v cty.Value) (cty.Value, error) {
+			if id, ok := stateValueId(name, path, depth); ok && len(path) == depth && sensitive[id] {
+				return cty.NullVal(v.Type()), nil
+			}
+			return v, nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for id := range sensitive {
+			ids = append(ids, id)
+		}
+	}
+
+	t, err := ctyjson.MarshalType(v.Type())
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err := ctyjson.Marshal(v, v.Type())
+	if err != nil {
+		return nil, nil, err
+	}
+	return &StateValue{Type: t, Value: value}, ids, nil
+}
+
+// stateValueId returns the identifier of the runnable of the name block, whose
+// value has path, for example var.name or data.env.home
+func stateValueId(name string, path cty.Path, depth int) (string, bool) {
+	if len(path) < depth {
+		return "", false
+	}
+	parts := []string{name}
+	for _, step := range path[:depth] {
+		switch step := step.(type) {
+		case cty.GetAttrStep:
+			parts = append(parts, step.Name)
+		case cty.IndexStep:
+			if step.Key.Type() != cty.String {
+				return "", false
+			}
+			parts = append(parts, step.Key.AsString())
+		default:
+			return "", false
+		}
+	}
+	return x.RenderBlock(parts...), true
+}
+
+func (v *StateValue) decode() (cty.Value, error) {
+	t, err := ctyjson.UnmarshalType(v.Type)
+	if err != nil {
+		return cty.NilVal, err
+	}
+	return ctyjson.Unmarshal(v.Value, t)
+}
@@ -0,0 +1,179 @@
+package ci
+
+import (
+	"github.com/google/uuid"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/sirupsen/logrus"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"github.com/srevinsaju/togomak/v1/internal/path"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/srevinsaju/togomak/v1/internal/third-party/hashicorp/terraform/lang/marks"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newRunStateTestConductor(t *testing.T, dir string, started time.Time) *Conductor {
+	return &Conductor{
+		RootLogger: logrus.New(),
+		Config:     ConductorConfig{Paths: &path.Path{Cwd: dir}},
+		Process:    Process{Id: uuid.New(), BootTime: started, TempDir: t.TempDir()},
+		eval:       &Eval{context: &hcl.EvalContext{Variables: map[string]cty.Value{}}, mu: &sync.RWMutex{}},
+		results:    NewResults(),
+	}
+}
+
+func TestRunState(t *testing.T) {
+	dir := t.TempDir()
+	started := time.Now()
+	conductor := newRunStateTestConductor(t, dir, started.Add(-time.Hour))
+
+	g := depgraph.New()
+	assert.NoError(t, g.DependOn("stage.deploy", "stage.build"))
+	assert.NoError(t, g.DependOn("stage.build", "var.name"))
+
+	// an older run, which must not be picked as the latest run
+	older := NewRunState(conductor, g)
+	older.Finish(conductor)
+
+	conductor = newRunStateTestConductor(t, dir, started)
+	state := NewRunState(conductor, g)
+	assert.Equal(t, []string{"stage.build", "var.name"}, state.Graph["stage.deploy"])
+
+	conductor.Eval().Context().Variables[blocks.VarBlock] = cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("bot")})
+	err := os.WriteFile(filepath.Join(conductor.TempDir(), meta.OutputEnvFile), []byte("VERSION=1.2.3\n"), 0644)
+	assert.NoError(t, err)
+
+	build := &Stage{Id: "build"}
+	PublishResult(conductor, build, runnable.Result{Id: "stage.build", Status: runnable.StatusSuccess, Output: "built"})
+	state.Complete(conductor, "var.name", &Variable{Id: "name"}, true)
+	state.Complete(conductor, "stage.build", build, true)
+	state.Dispatch("stage.deploy")
+	state.Finish(conductor)
+
+	previous, diags := LoadRunState(dir, "")
+	assert.False(t, diags.HasErrors())
+	assert.Equal(t, state.Id, previous.Id)
+	assert.Equal(t, runnable.StatusRunning, previous.Runnables["stage.deploy"].Status)
+	assert.True(t, previous.Succeeded("stage.build"))
+	assert.False(t, previous.Succeeded("stage.deploy"))
+
+	_, diags = LoadRunState(dir, "unknown")
+	assert.True(t, diags.HasErrors())
+
+	resumed := newRunStateTestConductor(t, dir, time.Now())
+	assert.False(t, previous.Resume(resumed).HasErrors())
+	outputs, err := os.ReadFile(filepath.Join(resumed.TempDir(), meta.OutputEnvFile))
+	assert.NoError(t, err)
+	assert.Equal(t, "VERSION=1.2.3\n", string(outputs))
+	assert.Equal(t, cty.StringVal("bot"), resumed.Eval().Context().Variables[blocks.VarBlock].GetAttr("name"))
+
+	assert.True(t, previous.Restore(resumed, "var.name", &Variable{Id: "name"}))
+	assert.True(t, previous.Restore(resumed, "stage.build", &Stage{Id: "build"}))
+	assert.False(t, previous.Restore(resumed, "stage.deploy", &Stage{Id: "deploy"}))
+	result, ok := resumed.Results().Get("stage.build")
+	assert.True(t, ok)
+	assert.Equal(t, "built", result.Output)
+}
+
+func TestLoadRunState_NoRuns(t *testing.T) {
+	_, diags := LoadRunState(t.TempDir(), "")
+	assert.True(t, diags.HasErrors())
+}
+
+func TestRunState_Sensitive(t *testing.T) {
+	dir := t.TempDir()
+	conductor := newRunStateTestConductor(t, dir, time.Now())
+	g := depgraph.New()
+	assert.NoError(t, g.DependOn("stage.deploy", "data.env.token"))
+	state := NewRunState(conductor, g)
+
+	conductor.Eval().Context().Variables[DataBlock] = cty.ObjectVal(map[string]cty.Value{
+		"env": cty.ObjectVal(map[string]cty.Value{
+			"token": cty.ObjectVal(map[string]cty.Value{"value": cty.StringVal("run-state-secret").Mark(marks.Sensitive)}),
+			"home":  cty.ObjectVal(map[string]cty.Value{"value": cty.StringVal("/home/bot")}),
+		}),
+	})
+	state.Complete(conductor, "data.env.token", &Data{Provider: "env", Id: "token"}, true)
+	state.Complete(conductor, "data.env.home", &Data{Provider: "env", Id: "home"}, true)
+
+	content, err := os.ReadFile(RunStatePath(dir, state.Id))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "run-state-secret")
+
+	previous, diags := LoadRunState(dir, state.Id)
+	assert.False(t, diags.HasErrors())
+	assert.Equal(t, []string{"data.env.token"}, previous.Sensitive)
+
+	resumed := newRunStateTestConductor(t, dir, time.Now())
+	assert.False(t, previous.Resume(resumed).HasErrors())
+	assert.False(t, previous.Restore(resumed, "data.env.token", &Data{Provider: "env", Id: "token"}))
+	assert.True(t, previous.Restore(resumed, "data.env.home", &Data{Provider: "env", Id: "home"}))
+	env := resumed.Eval().Context().Variables[DataBlock].GetAttr("env")
+	assert.True(t, env.GetAttr("token").IsNull())
+	assert.Equal(t, cty.StringVal("/home/bot"), env.GetAttr("home").GetAttr("value"))
+}
+
+func TestRunState_ResumeWithVar(t *testing.T) {
+	dir := t.TempDir()
+	conductor := newRunStateTestConductor(t, dir, time.Now())
+	g := depgraph.New()
+	assert.NoError(t, g.DependOn("stage.build", "var.name"))
+	assert.NoError(t, g.DependOn("stage.build", "var.region"))
+	state := NewRunState(conductor, g)
+
+	conductor.Eval().Context().Variables[blocks.VarBlock] = cty.ObjectVal(map[string]cty.Value{
+		"name":   cty.StringVal("bot"),
+		"region": cty.StringVal("eu"),
+	})
+	state.Complete(conductor, "var.name", &Variable{Id: "name"}, true)
+	state.Complete(conductor, "var.region", &Variable{Id: "region"}, true)
+
+	// the temporary file the state is written to is renamed into place
+	entries, err := os.ReadDir(filepath.Dir(RunStatePath(dir, state.Id)))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	previous, diags := LoadRunState(dir, state.Id)
+	assert.False(t, diags.HasErrors())
+
+	// the value given with --var when resuming is used instead of the restored one
+	resumed := newRunStateTestConductor(t, dir, time.Now())
+	resumed.Update(ConductorWithVariable(&Variable{Id: "name", Value: hcl.StaticExpr(cty.StringVal("human"), hcl.Range{})}))
+	assert.False(t, previous.Resume(resumed).HasErrors())
+	assert.True(t, previous.Restore(resumed, "var.region", &Variable{Id: "region"}))
+	name := &Variable{Id: "name"}
+	assert.False(t, previous.Restore(resumed, "var.name", name))
+	assert.False(t, name.Run(resumed).HasErrors())
+	variables := resumed.Eval().Context().Variables[blocks.VarBlock]
+	assert.Equal(t, cty.StringVal("human"), variables.GetAttr("name"))
+	assert.Equal(t, cty.StringVal("eu"), variables.GetAttr("region"))
+}
+
+func TestPruneRunStates(t *testing.T) {
+	dir := t.TempDir()
+	for i, id := range []string{"oldest", "older", "old"} {
+		runDir := filepath.Dir(RunStatePath(dir, id))
+		assert.NoError(t, os.MkdirAll(runDir, 0755))
+		modified := time.Now().Add(-time.Duration(3-i) * time.Hour)
+		assert.NoError(t, os.Chtimes(runDir, modified, modified))
+	}
+
+	assert.NoError(t, PruneRunStates(dir, 3))
+	entries, err := os.ReadDir(filepath.Join(dir, meta.BuildDirPrefix, RunsDir))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// the latest run is kept, so that it can be resumed
+	assert.NoError(t, PruneRunStates(dir, 0))
+	entries, err = os.ReadDir(filepath.Join(dir, meta.BuildDirPrefix, RunsDir))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "old", entries[0].Name())
+}
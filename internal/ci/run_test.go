@@ -6,7 +6,9 @@ import (
 	"github.com/srevinsaju/togomak/v1/internal/behavior"
 	"github.com/srevinsaju/togomak/v1/internal/path"
 	"github.com/srevinsaju/togomak/v1/internal/rules"
+	"github.com/stretchr/testify/assert"
 	"github.com/zclconf/go-cty/cty"
+	"path/filepath"
 	"testing"
 )
 
@@ -127,7 +129,7 @@ func TestCanRun(t *testing.T) {
 	}
 	conductor.Config.Pipeline.Filtered = filtered
 
-	ok, overridden, err := BlockCanRun(&stage1, conductor, stage1.Identifier(), depGraph)
+	ok, overridden, _, err := BlockCanRun(&stage1, conductor, stage1.Identifier(), depGraph)
 	if err != nil {
 		t.Errorf("error while running BlockCanRun: %s", err.Error())
 		return
@@ -141,7 +143,7 @@ func TestCanRun(t *testing.T) {
 		return
 	}
 
-	ok, overridden, err = BlockCanRun(&stage3, conductor, stage3.Identifier(), depGraph)
+	ok, overridden, _, err = BlockCanRun(&stage3, conductor, stage3.Identifier(), depGraph)
 	if err != nil {
 		t.Errorf("error while running BlockCanRun: %s", err.Error())
 		return
@@ -151,7 +153,7 @@ func TestCanRun(t *testing.T) {
 		return
 	}
 
-	ok, overridden, err = BlockCanRun(&stage2, conductor, stage2.Identifier(), depGraph)
+	ok, overridden, _, err = BlockCanRun(&stage2, conductor, stage2.Identifier(), depGraph)
 	if err != nil {
 		t.Errorf("error while running BlockCanRun: %s", err.Error())
 		return
@@ -161,7 +163,7 @@ func TestCanRun(t *testing.T) {
 		return
 	}
 
-	ok, overridden, err = BlockCanRun(&stage2, conductor, stage2.Identifier(), depGraph)
+	ok, overridden, _, err = BlockCanRun(&stage2, conductor, stage2.Identifier(), depGraph)
 	if err != nil {
 		t.Errorf("error while running BlockCanRun: %s", err.Error())
 		return
@@ -171,7 +173,7 @@ func TestCanRun(t *testing.T) {
 		return
 	}
 
-	ok, overridden, err = BlockCanRun(&stage1, conductor, stage1.Identifier(), depGraph)
+	ok, overridden, _, err = BlockCanRun(&stage1, conductor, stage1.Identifier(), depGraph)
 	if err != nil {
 		t.Errorf("error while running BlockCanRun: %s", err.Error())
 		return
@@ -188,7 +190,7 @@ func TestCanRun(t *testing.T) {
 	}
 	conductor.Config.Pipeline.Filtered = filtered
 
-	ok, overridden, err = BlockCanRun(&stage1, conductor, stage1.Identifier(), depGraph)
+	ok, overridden, _, err = BlockCanRun(&stage1, conductor, stage1.Identifier(), depGraph)
 	if err != nil {
 		t.Errorf("error while running BlockCanRun: %s", err.Error())
 		return
@@ -197,7 +199,7 @@ func TestCanRun(t *testing.T) {
 		t.Errorf("%s should be runnable", stage1.Identifier())
 		return
 	}
-	ok, overridden, err = BlockCanRun(&stage2, conductor, stage2.Identifier(), depGraph)
+	ok, overridden, _, err = BlockCanRun(&stage2, conductor, stage2.Identifier(), depGraph)
 	if err != nil {
 		t.Errorf("error while running BlockCanRun: %s", err.Error())
 		return
@@ -213,7 +215,7 @@ func TestCanRun(t *testing.T) {
 		return
 	}
 	conductor.Config.Pipeline.Filtered = filtered
-	ok, overridden, err = BlockCanRun(&stage1, conductor, stage1.Identifier(), depGraph)
+	ok, overridden, _, err = BlockCanRun(&stage1, conductor, stage1.Identifier(), depGraph)
 	if err != nil {
 		t.Errorf("error while running BlockCanRun: %s", err.Error())
 		return
@@ -221,7 +223,7 @@ func TestCanRun(t *testing.T) {
 	if ok {
 		t.Errorf("%s should not be runnable", stage1.Identifier())
 	}
-	ok, overridden, err = BlockCanRun(&stage2, conductor, stage2.Identifier(), depGraph)
+	ok, overridden, _, err = BlockCanRun(&stage2, conductor, stage2.Identifier(), depGraph)
 	if err != nil {
 		t.Errorf("error while running BlockCanRun: %s", err.Error())
 		return
@@ -231,7 +233,7 @@ func TestCanRun(t *testing.T) {
 		return
 	}
 
-	//ok, overridden, err = BlockCanRun(&stage1, conductor, nil, nil, stage1.Identifier(), depGraph)
+	//ok, overridden, _, err = BlockCanRun(&stage1, conductor, nil, nil, stage1.Identifier(), depGraph)
 	//if err != nil {
 	//	t.Errorf("error while running BlockCanRun: %s", err.Error())
 	//	return
@@ -245,3 +247,35 @@ func TestCanRun(t *testing.T) {
 	//	return
 	//}
 }
+
+func TestBlockRunWithRetries_RetrySucceeds(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPipeline(t, dir, `
+togomak {
+  version = 2
+}
+
+stage "flaky" {
+  script = "test -f failed || { touch failed; exit 1; }"
+
+  retry {
+    enabled             = true
+    attempts            = 2
+    exponential_backoff = false
+    min_backoff         = 0
+    max_backoff         = 0
+  }
+}
+
+stage "after" {
+  depends_on = [stage.flaky]
+  script     = "touch after"
+}
+`)
+
+	// the error of the first attempt does not fail the pipeline, nor skip the
+	// stages which depend on the stage
+	diags := runTestPipeline(t, dir)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.FileExists(t, filepath.Join(dir, "after"))
+}
@@ -28,6 +28,9 @@ type Retryable interface {
 	// RetryExponentialBackoff returns true if the backoff time should be
 	// exponentially increasing
 	RetryExponentialBackoff() bool
+	// FailureAllowed returns true if the runnable failing, even after all
+	// of its retries, must not fail the pipeline
+	FailureAllowed() bool
 }
 
 type Description struct {
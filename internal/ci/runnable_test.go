@@ -0,0 +1,23 @@
+package ci
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBlock_FailureAllowed(t *testing.T) {
+	for _, tt := range []struct {
+		block    Block
+		expected bool
+	}{
+		{&Data{}, false},
+		{&Local{}, false},
+		{&Macro{}, false},
+		{&Module{}, false},
+		{&Variable{}, false},
+		{&Stage{}, false},
+		{&Stage{CoreStage: CoreStage{AllowFailure: true}}, true},
+	} {
+		assert.Equal(t, tt.expected, tt.block.FailureAllowed(), tt.block.Type())
+	}
+}
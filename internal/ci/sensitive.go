@@ -0,0 +1,68 @@
+package ci
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/srevinsaju/togomak/v1/internal/third-party/hashicorp/terraform/lang/marks"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// unmarkSensitive records the values in v which are marked as sensitive, with
+// sensitive(), so that they are masked in the logs, and returns v without its marks.
+// Values are unmarked right before they are used by a stage, since cty does not
+// allow reading a marked value.
+func unmarkSensitive(v cty.Value) cty.Value {
+	unmarked, paths := v.UnmarkDeepWithPaths()
+	for _, pvm := range paths {
+		if _, ok := pvm.Marks[marks.Sensitive]; !ok {
+			continue
+		}
+		sensitive, err := pvm.Path.Apply(unmarked)
+		if err != nil {
+			continue
+		}
+		recordSensitive(sensitive)
+	}
+	return unmarked
+}
+
+// recordSensitive records the strings in v as secrets, which are masked in the
+// logs. Numbers and booleans are not recorded, since masking them would mask
+// the same digits and words everywhere else in the logs.
+func recordSensitive(v cty.Value) {
+	_ = cty.Walk(v, func(_ cty.Path, v cty.Value) (bool, error) {
+		if v.IsMarked() {
+			v, _ = v.UnmarkDeep()
+		}
+		if v.IsNull() || !v.IsKnown() {
+			return false, nil
+		}
+		if v.Type() == cty.String {
+			logging.Secrets().Add(v.AsString())
+		}
+		return true, nil
+	})
+}
+
+// sensitiveForEach returns the diagnostic of a for_each expression whose value
+// is sensitive, which cannot be used since the keys of the instances are shown
+// in the logs, or nil when it is not sensitive
+func sensitiveForEach(expr hcl.Expression, v cty.Value) *hcl.Diagnostic {
+	if !v.HasMark(marks.Sensitive) {
+		return nil
+	}
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  "sensitive value cannot be used in for_each",
+		Detail:   "Sensitive values, or values derived from sensitive values, cannot be used as for_each arguments, since they would be shown as the keys of the instances of the stage or the module.",
+		Subject:  expr.Range().Ptr(),
+	}
+}
+
+// recordSensitiveValues records the values in v which are marked as sensitive,
+// without unmarking v. It is used for the values of variables, locals and data
+// blocks, which are stored in the evaluation context with their marks, so that
+// their secrets are masked before a stage uses them.
+func recordSensitiveValues(v cty.Value) {
+	_ = unmarkSensitive(v)
+}
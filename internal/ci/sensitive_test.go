@@ -0,0 +1,27 @@
+package ci
+
+import (
+	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/srevinsaju/togomak/v1/internal/third-party/hashicorp/terraform/lang/marks"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+	"testing"
+)
+
+func TestUnmarkSensitive(t *testing.T) {
+	v := cty.ObjectVal(map[string]cty.Value{
+		"user":  cty.StringVal("unmark-sensitive-user"),
+		"token": cty.StringVal("unmark-sensitive-token").Mark(marks.Sensitive),
+		"keys":  cty.ListVal([]cty.Value{cty.StringVal("unmark-sensitive-key")}).Mark(marks.Sensitive),
+		"pin":   cty.NumberIntVal(48213).Mark(marks.Sensitive),
+		"flags": cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}).Mark(marks.Sensitive),
+	})
+
+	unmarked := unmarkSensitive(v)
+	assert.False(t, unmarked.ContainsMarked())
+	assert.Equal(t, cty.StringVal("unmark-sensitive-token"), unmarked.GetAttr("token"))
+
+	// numbers and short strings are not masked, they would mask the same characters everywhere else
+	redacted := logging.Secrets().Redact("unmark-sensitive-user unmark-sensitive-token unmark-sensitive-key 48213 a b")
+	assert.Equal(t, "unmark-sensitive-user (sensitive value) (sensitive value) 48213 a b", redacted)
+}
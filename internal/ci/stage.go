@@ -8,6 +8,7 @@ import (
 )
 
 const StageContextChildStatuses = "child_statuses"
+const StageContextFailedUpstream = "failed_upstream"
 
 func (s *Stage) Description() Description {
 	return Description{
@@ -0,0 +1,375 @@
+package ci
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/cache"
+	"github.com/srevinsaju/togomak/v1/internal/global"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"github.com/srevinsaju/togomak/v1/internal/third-party/hashicorp/terraform/lang/funcs"
+	"github.com/zclconf/go-cty/cty"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// globs evaluates expr, a list of file glob patterns, and returns the patterns.
+// The patterns are nil when expr is not set.
+func (s *Stage) globs(conductor *Conductor, evalCtx *hcl.EvalContext, expr hcl.Expression) ([]string, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	if expr == nil {
+		return nil, diags
+	}
+
+	conductor.Eval().Mutex().RLock()
+	v, d := expr.Value(evalCtx)
+	conductor.Eval().Mutex().RUnlock()
+	diags = diags.Extend(d)
+	if diags.HasErrors() || v.IsNull() {
+		return nil, diags
+	}
+
+	if !v.CanIterateElements() {
+		return nil, diags.Append(&hcl.Diagnostic{
+			Severity:    hcl.DiagError,
+			Summary:     "invalid file globs",
+			Detail:      fmt.Sprintf("expected a list of file globs, received %s", v.Type().FriendlyName()),
+			Subject:     expr.Range().Ptr(),
+			EvalContext: evalCtx,
+		})
+	}
+
+	patterns := []string{}
+	for it := v.ElementIterator(); it.Next(); {
+		_, pattern := it.Element()
+		if pattern.IsNull() || pattern.Type() != cty.String {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity:    hcl.DiagError,
+				Summary:     "invalid file glob",
+				Detail:      fmt.Sprintf("expected a file glob, received %s", pattern.Type().FriendlyName()),
+				Subject:     expr.Range().Ptr(),
+				EvalContext: evalCtx,
+			})
+			continue
+		}
+		patterns = append(patterns, pattern.AsString())
+	}
+	return patterns, diags
+}
+
+// fileset returns the files in dir matching pattern, relative to dir and sorted
+func fileset(dir string, pattern string) ([]string, error) {
+	matches, err := funcs.FileSet(dir, cty.StringVal("."), cty.StringVal(pattern))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for it := matches.ElementIterator(); it.Next(); {
+		_, match := it.Element()
+		files = append(files, match.AsString())
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// fingerprintId returns the identifier the fingerprint of the stage is stored
+// with, which includes the path of its module, so that the stages of different
+// modules, and of different instances of a module, have fingerprints of their own
+func (s *Stage) fingerprintId(conductor *Conductor) string {
+	return conductor.ModuleId(s.String())
+}
+
+// fingerprint computes the fingerprint of the stage from the content of its
+// inputs, the command which would be run and the environment variables it
+// declares, the host environment is not included. The fingerprint
+// is empty if the stage does not declare its inputs.
+func (s *Stage) fingerprint(conductor *Conductor, evalCtx *hcl.EvalContext, cmd *exec.Cmd, envStrings []string) (string, hcl.Diagnostics) {
+	patterns, diags := s.globs(conductor, evalCtx, s.Inputs)
+	if diags.HasErrors() || patterns == nil {
+		return "", diags
+	}
+
+	f := cache.NewFingerprint()
+	f.Add("id", s.fingerprintId(conductor))
+
+	dir, err := filepath.Rel(conductor.Config.Paths.Cwd, cmd.Dir)
+	if err != nil {
+		dir = cmd.Dir
+	}
+	f.Add("dir", dir)
+
+	for _, arg := range cmd.Args {
+		f.Add("arg", arg)
+	}
+
+	if s.Container != nil {
+		image, d := s.hclImage(conductor, evalCtx)
+		diags = diags.Extend(d)
+		f.Add("image", image)
+	}
+
+	// TOGOMAK_OUTPUTS points to the temporary directory of the current run
+	var env []string
+	for _, e := range envStrings {
+		if !strings.HasPrefix(e, meta.OutputEnvVar+"=") {
+			env = append(env, e)
+		}
+	}
+	sort.Strings(env)
+	for _, e := range env {
+		f.Add("env", e)
+	}
+
+	for _, pattern := range patterns {
+		f.Add("input", pattern)
+		files, err := fileset(cmd.Dir, pattern)
+		if err != nil {
+			return "", diags.Append(&hcl.Diagnostic{
+				Severity:    hcl.DiagError,
+				Summary:     "failed to evaluate inputs",
+				Detail:      err.Error(),
+				Subject:     s.Inputs.Range().Ptr(),
+				EvalContext: evalCtx,
+			})
+		}
+		for _, file := range files {
+			if err := f.AddFile(file, filepath.Join(cmd.Dir, file)); err != nil {
+				return "", diags.Append(&hcl.Diagnostic{
+					Severity:    hcl.DiagError,
+					Summary:     "failed to read input",
+					Detail:      err.Error(),
+					Subject:     s.Inputs.Range().Ptr(),
+					EvalContext: evalCtx,
+				})
+			}
+		}
+	}
+	return f.Sum(), diags
+}
+
+// outputsExist returns true if every glob in the outputs of the stage matches at least one file
+func (s *Stage) outputsExist(conductor *Conductor, evalCtx *hcl.EvalContext, dir string) (bool, hcl.Diagnostics) {
+	patterns, diags := s.globs(conductor, evalCtx, s.Outputs)
+	if diags.HasErrors() {
+		return false, diags
+	}
+	for _, pattern := range patterns {
+		files, err := fileset(dir, pattern)
+		if err != nil || len(files) == 0 {
+			return false, diags
+		}
+	}
+	return true, diags
+}
+
+// upToDate returns true if the stage has run successfully before with the same
+// fingerprint, and its outputs still exist. The outputs the stage wrote to
+// TOGOMAK_OUTPUTS are published again when it is up to date.
+func (s *Stage) upToDate(conductor *Conductor, evalCtx *hcl.EvalContext, dir string, fingerprint string) (bool, hcl.Diagnostics) {
+	logger := conductor.Logger().WithField("stage", s.Id)
+	previous, ok := cache.LoadFingerprint(conductor.Config.Paths.Cwd, s.fingerprintId(conductor))
+	if !ok || previous != fingerprint {
+		return false, nil
+	}
+	ok, diags := s.outputsExist(conductor, evalCtx, dir)
+	if !ok {
+		return false, diags
+	}
+
+	outputs, err := cache.LoadFingerprintOutputs(conductor.Config.Paths.Cwd, s.fingerprintId(conductor))
+	if err == nil {
+		err = publishOutputs(conductor, outputs)
+	}
+	if err != nil {
+		logger.Warnf("failed to publish the outputs of the previous run: %s", err)
+		return false, diags
+	}
+	return true, diags
+}
+
+// restoreOutputs restores the outputs of the stage from the artifact cache, and
+// returns true if they were restored. Failing to read from the artifact cache is
+// not an error, the stage is run instead.
+func (s *Stage) restoreOutputs(conductor *Conductor, evalCtx *hcl.EvalContext, dir string, fingerprint string) (bool, hcl.Diagnostics) {
+	logger := conductor.Logger().WithField("stage", s.Id)
+	store := conductor.Artifacts()
+	if store == nil {
+		return false, nil
+	}
+	patterns, diags := s.globs(conductor, evalCtx, s.Outputs)
+	if diags.HasErrors() || len(patterns) == 0 {
+		return false, diags
+	}
+
+	r, ok, err := store.Get(fingerprint)
+	if err != nil {
+		logger.Warnf("failed to read from the artifact cache %s: %s", store, err)
+		return false, diags
+	}
+	if !ok {
+		logger.Debugf("outputs not found in the artifact cache %s", store)
+		return false, diags
+	}
+	defer r.Close()
+
+	outputs, err := cache.Unpack(r, dir)
+	if err != nil {
+		logger.Warnf("failed to restore outputs from the artifact cache %s: %s", store, err)
+		return false, diags
+	}
+	ok, d := s.outputsExist(conductor, evalCtx, dir)
+	diags = diags.Extend(d)
+	if !ok {
+		logger.Warnf("the artifact cache %s does not have all the outputs", store)
+		return false, diags
+	}
+
+	if err := publishOutputs(conductor, outputs); err != nil {
+		logger.Warnf("failed to publish the outputs restored from the artifact cache %s: %s", store, err)
+		return false, diags
+	}
+	if err := cache.SaveFingerprint(conductor.Config.Paths.Cwd, s.fingerprintId(conductor), fingerprint, outputs); err != nil {
+		logger.Warnf("failed to update the fingerprint: %s", err)
+	}
+	logger.Infof("restored outputs from the artifact cache %s", store)
+	return true, diags
+}
+
+// storeOutputs stores the outputs of the stage in the artifact cache, along with
+// exported, the outputs it wrote to TOGOMAK_OUTPUTS. Failing to store them is
+// not an error, since the stage itself has succeeded.
+func (s *Stage) storeOutputs(conductor *Conductor, evalCtx *hcl.EvalContext, dir string, fingerprint string, exported []byte) hcl.Diagnostics {
+	logger := conductor.Logger().WithField("stage", s.Id)
+	store := conductor.Artifacts()
+	if store == nil {
+		return nil
+	}
+	patterns, diags := s.globs(conductor, evalCtx, s.Outputs)
+	if diags.HasErrors() || len(patterns) == 0 {
+		return diags
+	}
+
+	seen := make(map[string]bool)
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := fileset(dir, pattern)
+		if err != nil {
+			logger.Warnf("failed to evaluate outputs: %s", err)
+			return diags
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	if len(files) == 0 {
+		logger.Warnf("the stage did not produce any of its outputs, nothing to store in the artifact cache")
+		return diags
+	}
+	sort.Strings(files)
+
+	f, err := os.CreateTemp(conductor.TempDir(), "artifact-*"+cache.ArtifactExtension)
+	if err != nil {
+		logger.Warnf("failed to store outputs in the artifact cache %s: %s", store, err)
+		return diags
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	err = cache.Pack(f, dir, files, exported)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = store.Put(fingerprint, f)
+	}
+	if err != nil {
+		logger.Warnf("failed to store outputs in the artifact cache %s: %s", store, err)
+		return diags
+	}
+	logger.Debugf("stored %d output(s) in the artifact cache %s", len(files), store)
+	return diags
+}
+
+// exportedOutputs is the TOGOMAK_OUTPUTS file of a stage. Each stage writes to a
+// file of its own, which starts with the outputs of the pipeline so far, so that
+// the file of the pipeline is not read while a stage is writing to it. The
+// outputs a stage exports are published once it has finished, and are stored
+// along with its fingerprint, so that they are published again when it is not run.
+type exportedOutputs struct {
+	path   string
+	offset int
+}
+
+// exportOutputs creates the TOGOMAK_OUTPUTS file of the stage, and returns
+// envStrings with TOGOMAK_OUTPUTS pointing to it
+func (s *Stage) exportOutputs(conductor *Conductor, envStrings []string) (*exportedOutputs, []string, error) {
+	global.OutputsFileMutex.Lock()
+	outputs, err := os.ReadFile(filepath.Join(conductor.TempDir(), meta.OutputEnvFile))
+	global.OutputsFileMutex.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, envStrings, err
+	}
+	f, err := os.CreateTemp(conductor.TempDir(), "outputs-*"+meta.OutputEnvFile)
+	if err != nil {
+		return nil, envStrings, err
+	}
+	_, err = f.Write(outputs)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, envStrings, err
+	}
+
+	env := make([]string, 0, len(envStrings))
+	for _, e := range envStrings {
+		if strings.HasPrefix(e, meta.OutputEnvVar+"=") {
+			e = fmt.Sprintf("%s=%s", meta.OutputEnvVar, f.Name())
+		}
+		env = append(env, e)
+	}
+	return &exportedOutputs{path: f.Name(), offset: len(outputs)}, env, nil
+}
+
+// exported returns the outputs the stage wrote to its TOGOMAK_OUTPUTS file, and
+// removes the file
+func (o *exportedOutputs) exported() ([]byte, error) {
+	outputs, err := os.ReadFile(o.path)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Remove(o.path)
+	// the stage may have replaced the file instead of appending to it
+	if len(outputs) < o.offset {
+		return outputs, nil
+	}
+	return outputs[o.offset:], nil
+}
+
+// publishOutputs appends outputs to the TOGOMAK_OUTPUTS file of the pipeline, so
+// that they are available to the runnables which run next
+func publishOutputs(conductor *Conductor, outputs []byte) error {
+	if len(outputs) == 0 {
+		return nil
+	}
+	if outputs[len(outputs)-1] != '\n' {
+		outputs = append(outputs, '\n')
+	}
+	global.OutputsFileMutex.Lock()
+	defer global.OutputsFileMutex.Unlock()
+	f, err := os.OpenFile(filepath.Join(conductor.TempDir(), meta.OutputEnvFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(outputs)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package ci
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStage_FingerprintId(t *testing.T) {
+	conductor := newRunStateTestConductor(t, t.TempDir(), time.Now())
+	stage := &Stage{Id: "build"}
+	assert.Equal(t, "stage.build", stage.fingerprintId(conductor))
+
+	conductor.Update(ConductorWithModule(`module.deploy["eu"]`))
+	assert.Equal(t, `module.deploy["eu"]/stage.build`, stage.fingerprintId(conductor))
+}
+
+func TestStage_CachedOutputs(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "VERSION"), []byte("1.2.3"), 0644))
+	writeTestPipeline(t, dir, `
+togomak {
+  version = 2
+}
+
+stage "version" {
+  inputs  = ["VERSION"]
+  outputs = ["version.txt"]
+  script  = <<-EOT
+  echo run >> runs.txt
+  cp VERSION version.txt
+  echo "VERSION=$(cat VERSION)" >> $TOGOMAK_OUTPUTS
+  EOT
+}
+
+stage "release" {
+  depends_on = [stage.version]
+  script     = "echo ${output.VERSION} >> released.txt"
+}
+`)
+
+	for i := 0; i < 2; i++ {
+		diags := runTestPipeline(t, dir)
+		assert.False(t, diags.HasErrors(), diags.Error())
+	}
+
+	// the stage ran once, and its outputs were published again when it was cached
+	runs, err := os.ReadFile(filepath.Join(dir, "runs.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "run\n", string(runs))
+	released, err := os.ReadFile(filepath.Join(dir, "released.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3\n1.2.3\n", string(released))
+}
@@ -38,7 +38,17 @@ func (s *CoreStage) Variables() []hcl.Traversal {
 	traversal = append(traversal, s.Dir.Variables()...)
 	traversal = append(traversal, s.DependsOn.Variables()...)
 	traversal = append(traversal, s.Script.Variables()...)
+	traversal = append(traversal, s.Shell.Variables()...)
 	traversal = append(traversal, s.Args.Variables()...)
+	if s.Inputs != nil {
+		traversal = append(traversal, s.Inputs.Variables()...)
+	}
+	if s.Outputs != nil {
+		traversal = append(traversal, s.Outputs.Variables()...)
+	}
+	if s.Watch != nil {
+		traversal = append(traversal, s.Watch.Variables()...)
+	}
 
 	traversal = append(traversal, s.dependsOnVariablesMacro...)
 
@@ -72,8 +82,11 @@ func (s *CoreStage) Variables() []hcl.Traversal {
 func (s *Stage) Variables() []hcl.Traversal {
 	var traversal []hcl.Traversal
 	traversal = append(traversal, s.CoreStage.Variables()...)
+	if s.ForEach != nil {
+		traversal = append(traversal, s.ForEach.Variables()...)
+	}
 	if s.Lifecycle != nil {
-		traversal = append(traversal, s.Lifecycle.Timeout.Variables()...)
+		traversal = append(traversal, s.Lifecycle.Variables()...)
 	}
 	return traversal
 }
@@ -85,3 +98,14 @@ func (s Stages) Variables() []hcl.Traversal {
 	}
 	return traversal
 }
+
+func (l *Lifecycle) Variables() []hcl.Traversal {
+	var traversal []hcl.Traversal
+	if l.Phase != nil {
+		traversal = append(traversal, l.Phase.Variables()...)
+	}
+	if l.Timeout != nil {
+		traversal = append(traversal, l.Timeout.Variables()...)
+	}
+	return traversal
+}
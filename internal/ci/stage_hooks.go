@@ -12,6 +12,8 @@ func (s *Stage) BeforeRun(conductor *Conductor, opts ...runnable.Option) hcl.Dia
 		logger.Debug("no pre-hook defined")
 		return nil
 	}
+	span := conductor.Tracer().Start(s.String(), "hook", "pre hooks")
+	defer span.End()
 	var diags hcl.Diagnostics
 
 	for _, hook := range s.PreHook {
@@ -28,6 +30,8 @@ func (s *Stage) AfterRun(conductor *Conductor, opts ...runnable.Option) hcl.Diag
 		logger.Debug("no post-hook defined")
 		return nil
 	}
+	span := conductor.Tracer().Start(s.String(), "hook", "post hooks")
+	defer span.End()
 	var diags hcl.Diagnostics
 
 	for _, hook := range s.PostHook {
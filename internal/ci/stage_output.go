@@ -0,0 +1,231 @@
+package ci
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/srevinsaju/togomak/v1/internal/ui"
+	"github.com/srevinsaju/togomak/v1/internal/x"
+	"hash/fnv"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutputMode is how the output of the stages is printed
+type OutputMode string
+
+const (
+	// OutputStream logs each line of the output of a stage as soon as it is
+	// written, which is the default
+	OutputStream OutputMode = "stream"
+
+	// OutputGrouped prints the output of a stage as a single block, between a
+	// header and a footer, once the stage has finished, so that the output of
+	// the stages running at the same time is not interleaved
+	OutputGrouped OutputMode = "grouped"
+
+	// OutputPrefix prints each line of the output of a stage as soon as it is
+	// written, preceded by the identifier of the stage, aligned and colored
+	OutputPrefix OutputMode = "prefix"
+)
+
+// ParseOutputMode returns the OutputMode named mode. When mode is empty, the
+// output is grouped in CI, unless the logs are JSON, and streamed otherwise.
+// The output cannot be prefixed when the logs are JSON, since the prefixed lines
+// are not JSON.
+func ParseOutputMode(mode string, ci bool, json bool) (OutputMode, error) {
+	switch OutputMode(mode) {
+	case OutputPrefix:
+		if json {
+			return "", fmt.Errorf("the output of the stages cannot be prefixed with their identifiers when the logs are JSON, use the %s or the %s output mode", OutputStream, OutputGrouped)
+		}
+		return OutputPrefix, nil
+	case OutputStream, OutputGrouped:
+		return OutputMode(mode), nil
+	case "":
+		if ci && !json {
+			return OutputGrouped, nil
+		}
+		return OutputStream, nil
+	}
+	return "", fmt.Errorf("unknown output mode %q, expected one of %s, %s or %s", mode, OutputStream, OutputGrouped, OutputPrefix)
+}
+
+// outputColors are the colors of the identifiers of the stages in OutputPrefix mode
+var outputColors = []func(a ...interface{}) string{ui.Blue, ui.Green, ui.Yellow, ui.HiCyan, ui.HiYellow, ui.HiRed}
+
+// Output prints the output of the stages of a pipeline, and of its modules,
+// in its OutputMode
+type Output struct {
+	mode OutputMode
+
+	mu    sync.Mutex
+	width int
+}
+
+// NewOutput creates the Output of a pipeline, which is streamed when mode is empty
+func NewOutput(mode OutputMode) *Output {
+	if mode == "" {
+		mode = OutputStream
+	}
+	return &Output{mode: mode}
+}
+
+// Align makes the identifiers printed in OutputPrefix mode at least as wide
+// as the longest of ids. The identifiers are aligned on the longest identifier
+// printed so far otherwise.
+func (o *Output) Align(ids []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, id := range ids {
+		if w := ui.Width(id); w > o.width {
+			o.width = w
+		}
+	}
+}
+
+// Stage returns the output of the stage identified by id, whose logs are
+// written by logger. The output is printed in OutputStream mode when o is nil.
+// The output of daemons, which may run until the pipeline has finished, is
+// prefixed instead of grouped.
+func (o *Output) Stage(logger *logrus.Entry, id string, daemon bool) *StageOutput {
+	if o == nil {
+		o = NewOutput(OutputStream)
+	}
+	s := &StageOutput{output: o, mode: o.mode, logger: logger, id: id, started: time.Now()}
+	if s.mode == OutputStream {
+		return s
+	}
+	if daemon && s.mode == OutputGrouped {
+		s.mode = OutputPrefix
+	}
+	// the stages of modules are identified along with their module, as in the dashboard
+	if module, ok := logger.Data["module"]; ok {
+		s.id = x.RenderBlock(blocks.ModuleBlock, fmt.Sprint(module)) + "/" + id
+	}
+	if s.mode == OutputPrefix {
+		o.Align([]string{s.id})
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(s.id))
+		s.color = outputColors[h.Sum32()%uint32(len(outputColors))]
+	}
+	return s
+}
+
+// StageOutput is the output of a single run of a stage. Its Close method must
+// be called once the stage has finished.
+type StageOutput struct {
+	output  *Output
+	mode    OutputMode
+	logger  *logrus.Entry
+	id      string
+	color   func(a ...interface{}) string
+	started time.Time
+
+	mu      sync.Mutex
+	partial []byte
+	lines   []string
+}
+
+// Stdout returns the writer of the standard output of the stage
+func (s *StageOutput) Stdout() io.Writer {
+	if s.mode == OutputStream {
+		return s.logger.Writer()
+	}
+	return stageOutputWriter{s}
+}
+
+// Stderr returns the writer of the standard error of the stage, which is
+// logged as warnings in OutputStream mode
+func (s *StageOutput) Stderr() io.Writer {
+	if s.mode == OutputStream {
+		return s.logger.WriterLevel(logrus.WarnLevel)
+	}
+	return stageOutputWriter{s}
+}
+
+type stageOutputWriter struct {
+	s *StageOutput
+}
+
+func (w stageOutputWriter) Write(p []byte) (int, error) {
+	s := w.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partial = append(s.partial, p...)
+	i := bytes.LastIndexByte(s.partial, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	for _, line := range strings.Split(string(s.partial[:i]), "\n") {
+		s.line(strings.TrimSuffix(line, "\r"))
+	}
+	s.partial = append(s.partial[:0], s.partial[i+1:]...)
+	return len(p), nil
+}
+
+// line prints line, or keeps it until the stage has finished in OutputGrouped
+// mode. Like the lines logged in OutputStream mode, it is passed to the hooks
+// of the logger first, which mask the secrets and send it to the sinks.
+func (s *StageOutput) line(line string) {
+	logger := s.logger.Logger
+	if !logger.IsLevelEnabled(logrus.InfoLevel) {
+		return
+	}
+	// the hooks may change the fields of the entry, which are shared with the logger of the stage
+	entry := s.logger.Dup()
+	entry.Time = time.Now()
+	entry.Level = logrus.InfoLevel
+	entry.Message = line
+	if err := logger.Hooks.Fire(logrus.InfoLevel, entry); err != nil {
+		s.logger.Warnf("failed to fire the hooks of the output: %s", err)
+	}
+
+	if s.mode == OutputGrouped {
+		s.lines = append(s.lines, entry.Message)
+		return
+	}
+	s.output.mu.Lock()
+	defer s.output.mu.Unlock()
+	_, _ = fmt.Fprintf(logger.Out, "%s %s %s\n", s.color(ui.Pad(s.id, s.output.width)), ui.Grey("|"), entry.Message)
+}
+
+// Close prints the last line of the output, which does not end with a newline,
+// and in OutputGrouped mode, prints the whole output of the stage between a
+// header and a footer with its status
+func (s *StageOutput) Close(status runnable.StatusType) {
+	if s.mode == OutputStream {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.partial) > 0 {
+		s.line(string(s.partial))
+		s.partial = nil
+	}
+	if s.mode != OutputGrouped || (len(s.lines) == 0 && !status.Failed()) {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", ui.Grey("┌"), ui.Bold(s.id))
+	for _, line := range s.lines {
+		fmt.Fprintf(&b, "%s %s\n", ui.Grey("│"), line)
+	}
+	statusText := status.String()
+	if status.Failed() {
+		statusText = ui.Red(statusText)
+	} else {
+		statusText = ui.Green(statusText)
+	}
+	fmt.Fprintf(&b, "%s %s %s %s\n", ui.Grey("└"), ui.Bold(s.id), statusText, ui.Grey(fmt.Sprintf("took %s", time.Since(s.started).Round(time.Millisecond))))
+	s.lines = nil
+
+	s.output.mu.Lock()
+	defer s.output.mu.Unlock()
+	_, _ = io.WriteString(s.logger.Logger.Out, b.String())
+}
@@ -0,0 +1,107 @@
+package ci
+
+import (
+	"bytes"
+	"github.com/acarl005/stripansi"
+	"github.com/sirupsen/logrus"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func newStageOutputTestLogger() (*logrus.Logger, *bytes.Buffer) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &out
+	logger.AddHook(logging.Secrets())
+	return logger, &out
+}
+
+func TestParseOutputMode(t *testing.T) {
+	for _, tt := range []struct {
+		mode     string
+		ci       bool
+		json     bool
+		expected OutputMode
+	}{
+		{"", false, false, OutputStream},
+		{"", true, false, OutputGrouped},
+		{"", true, true, OutputStream},
+		{"prefix", true, false, OutputPrefix},
+		{"stream", true, false, OutputStream},
+		{"grouped", false, true, OutputGrouped},
+	} {
+		mode, err := ParseOutputMode(tt.mode, tt.ci, tt.json)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, mode)
+	}
+
+	_, err := ParseOutputMode("lines", false, false)
+	assert.ErrorContains(t, err, `unknown output mode "lines"`)
+
+	// the prefixed lines would be mixed with the JSON logs
+	_, err = ParseOutputMode("prefix", false, true)
+	assert.ErrorContains(t, err, "when the logs are JSON")
+}
+
+func TestStageOutput_Grouped(t *testing.T) {
+	logger, out := newStageOutputTestLogger()
+	logging.Secrets().Add("stage-output-secret")
+	output := NewOutput(OutputGrouped)
+
+	build := output.Stage(logger.WithField("stage", "build"), "stage.build", false)
+	_, _ = io.WriteString(build.Stdout(), "compiling\ntoken=stage-output")
+	_, _ = io.WriteString(build.Stderr(), "-secret\nunfinished")
+	assert.Empty(t, out.String())
+
+	build.Close(runnable.StatusSuccess)
+	lines := strings.Split(strings.TrimSuffix(stripansi.Strip(out.String()), "\n"), "\n")
+	assert.Len(t, lines, 5)
+	assert.Equal(t, "┌ stage.build", lines[0])
+	assert.Equal(t, "│ compiling", lines[1])
+	assert.Equal(t, "│ token="+logging.Redacted, lines[2])
+	assert.Equal(t, "│ unfinished", lines[3])
+	assert.True(t, strings.HasPrefix(lines[4], "└ stage.build success took "))
+
+	// stages without output are only printed when they fail
+	out.Reset()
+	output.Stage(logger.WithField("stage", "lint"), "stage.lint", false).Close(runnable.StatusSuccess)
+	assert.Empty(t, out.String())
+	output.Stage(logger.WithField("stage", "test"), "stage.test", false).Close(runnable.StatusFailure)
+	assert.Contains(t, stripansi.Strip(out.String()), "└ stage.test failure")
+}
+
+func TestStageOutput_Prefix(t *testing.T) {
+	logger, out := newStageOutputTestLogger()
+	output := NewOutput(OutputPrefix)
+	output.Align([]string{"stage.a", "stage.build", "module.deploy/stage.inner"})
+
+	a := output.Stage(logger.WithField("stage", "a"), "stage.a", false)
+	_, _ = io.WriteString(a.Stdout(), "first\n")
+	inner := output.Stage(logger.WithField("stage", "inner").WithField("module", "deploy"), "stage.inner", false)
+	_, _ = io.WriteString(inner.Stdout(), "second\n")
+	a.Close(runnable.StatusSuccess)
+	inner.Close(runnable.StatusSuccess)
+
+	assert.Equal(t, "stage.a                   | first\nmodule.deploy/stage.inner | second\n", stripansi.Strip(out.String()))
+
+	// the daemons are prefixed in grouped mode, their output is not held until the pipeline has finished
+	out.Reset()
+	server := NewOutput(OutputGrouped).Stage(logger.WithField("stage", "server"), "stage.server", true)
+	_, _ = io.WriteString(server.Stdout(), "listening\n")
+	assert.Equal(t, "stage.server | listening\n", stripansi.Strip(out.String()))
+}
+
+func TestStageOutput_Stream(t *testing.T) {
+	logger, out := newStageOutputTestLogger()
+	var output *Output
+	s := output.Stage(logger.WithField("stage", "build"), "stage.build", false)
+	assert.Equal(t, OutputStream, s.mode)
+
+	// the output is logged by the logger of the stage, it is not printed on its own
+	s.Close(runnable.StatusFailure)
+	assert.Empty(t, out.String())
+}
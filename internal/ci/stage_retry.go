@@ -22,3 +22,7 @@ func (s *Stage) RetryExponentialBackoff() bool {
 	return s.Retry.ExponentialBackoff
 
 }
+
+func (s *Stage) FailureAllowed() bool {
+	return s.AllowFailure
+}
@@ -10,7 +10,9 @@ import (
 	dockerContainer "github.com/docker/docker/api/types/container"
 	dockerClient "github.com/docker/docker/client"
 	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/cache"
 	"github.com/srevinsaju/togomak/v1/internal/dg"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
 	"github.com/srevinsaju/togomak/v1/internal/x"
 	"sync"
 
@@ -18,7 +20,6 @@ import (
 	"github.com/google/uuid"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/imdario/mergo"
-	"github.com/sirupsen/logrus"
 	"github.com/srevinsaju/togomak/v1/internal/c"
 	"github.com/srevinsaju/togomak/v1/internal/meta"
 	"github.com/srevinsaju/togomak/v1/internal/runnable"
@@ -30,6 +31,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 const TogomakParamEnvVarPrefix = "TOGOMAK__param__"
@@ -365,6 +367,9 @@ func (s *Stage) Run(conductor *Conductor, options ...runnable.Option) (diags hcl
 		diags = diags.Extend(d)
 		return diags
 	}
+	if diag := sensitiveForEach(s.ForEach, forEachItems); diag != nil {
+		return diags.Append(diag)
+	}
 	if !forEachItems.CanIterateElements() {
 		diags = diags.Append(&hcl.Diagnostic{
 			Severity: hcl.DiagError,
@@ -378,6 +383,9 @@ func (s *Stage) Run(conductor *Conductor, options ...runnable.Option) (diags hcl
 
 	var safeDg dg.SafeDiagnostics
 
+	var instances []*Stage
+	started := time.Now()
+
 	var counter int
 	var key string
 	var keyCty cty.Value
@@ -393,6 +401,7 @@ func (s *Stage) Run(conductor *Conductor, options ...runnable.Option) (diags hcl
 		id := fmt.Sprintf("%s[%s]", s.Id, key)
 		wg.Add(1)
 		stage := &Stage{Id: id, CoreStage: s.CoreStage, Lifecycle: s.Lifecycle}
+		instances = append(instances, stage)
 		go func(keyCty cty.Value, options ...runnable.Option) {
 			options = append(options, runnable.WithEach(keyCty, v))
 			d := stage.Run(conductor, options...)
@@ -405,10 +414,42 @@ func (s *Stage) Run(conductor *Conductor, options ...runnable.Option) (diags hcl
 		return false
 	})
 	wg.Wait()
+	if !cfg.Hook {
+		s.recordInstanceResults(conductor, instances, started)
+	}
 	return safeDg.Diagnostics()
 
 }
 
+// recordInstanceResults records the result of a stage using for_each, which
+// is derived from the results of each of its instances
+func (s *Stage) recordInstanceResults(conductor *Conductor, instances []*Stage, started time.Time) {
+	result := runnable.Result{
+		Id:       s.String(),
+		Status:   runnable.StatusSuccess,
+		Attempts: 1,
+		Started:  started,
+		Finished: time.Now(),
+	}
+	var output strings.Builder
+	for _, instance := range instances {
+		instanceResult, ok := conductor.Results().Get(instance.String())
+		if !ok {
+			continue
+		}
+		output.WriteString(instanceResult.Output)
+		if result.ExitCode == 0 {
+			result.ExitCode = instanceResult.ExitCode
+		}
+		if instanceResult.Status == runnable.StatusTimeout ||
+			(instanceResult.Status == runnable.StatusFailure && result.Status == runnable.StatusSuccess) {
+			result.Status = instanceResult.Status
+		}
+	}
+	result.Output = output.String()
+	conductor.Results().Record(result)
+}
+
 func (s *Stage) run(conductor *Conductor, evalCtx *hcl.EvalContext, options ...runnable.Option) hcl.Diagnostics {
 	var err error
 	logger := conductor.Logger().WithField("stage", s.Id)
@@ -416,16 +457,50 @@ func (s *Stage) run(conductor *Conductor, evalCtx *hcl.EvalContext, options ...r
 	status := runnable.StatusRunning
 	cfg := runnable.NewConfig(options...)
 	stream := conductor.NewOutputMemoryStream(s.String())
+	output := conductor.Output().Stage(logger, s.String(), s.IsDaemon())
 	diags := &dg.Diagnostics{}
+	timedOut := false
+	cached := false
+	exitCode := 0
+	started := time.Now()
+
+	// hooks run within the slot of the stage they belong to, and daemons
+	// are not counted, since they would hold on to their slot forever
+	if !cfg.Hook && !s.IsDaemon() {
+		conductor.Pool().Acquire()
+		defer conductor.Pool().Release()
+		if conductor.Metrics() != nil {
+			conductor.Metrics().QueueWait(s.String(), blockPhases(conductor, s), time.Since(started))
+		}
+	}
 
 	defer func(stream *bytes.Buffer) {
 		logger.Debug("running post hooks")
 		success := !diags.HasErrors()
-		if !success {
+		if timedOut {
+			status = runnable.StatusTimeout
+		} else if !success {
 			status = runnable.StatusFailure
+		} else if cached {
+			status = runnable.StatusCached
 		} else {
 			status = runnable.StatusSuccess
 		}
+		if !success && exitCode == 0 {
+			exitCode = -1
+		}
+		output.Close(status)
+		if !cfg.Hook {
+			conductor.Results().Record(runnable.Result{
+				Id:       s.String(),
+				Status:   status,
+				ExitCode: exitCode,
+				Attempts: 1,
+				Started:  started,
+				Finished: time.Now(),
+				Output:   stream.String(),
+			})
+		}
 		hookOpts := []runnable.Option{
 			runnable.WithStatus(status),
 			runnable.WithHook(),
@@ -437,9 +512,6 @@ func (s *Stage) run(conductor *Conductor, evalCtx *hcl.EvalContext, options ...r
 		logger.Debug("finished running post hooks")
 	}(stream)
 
-	d := s.executePreHooks(conductor, status, options...)
-	diags.Extend(d)
-
 	paramsGo := map[string]cty.Value{}
 
 	logger.Debugf("expanding global macro parameters")
@@ -465,11 +537,12 @@ func (s *Stage) run(conductor *Conductor, evalCtx *hcl.EvalContext, options ...r
 	evalCtx = evalCtx.NewChild()
 	evalCtx.Variables = map[string]cty.Value{
 		ThisBlock: cty.ObjectVal(map[string]cty.Value{
-			"name":   cty.StringVal(name),
-			"id":     cty.StringVal(id),
-			"hook":   cty.BoolVal(cfg.Hook),
-			"status": cty.StringVal(string(cfg.Status.Status)),
-			"output": cty.StringVal(cfg.Status.Output),
+			"name":            cty.StringVal(name),
+			"id":              cty.StringVal(id),
+			"hook":            cty.BoolVal(cfg.Hook),
+			"status":          cty.StringVal(string(cfg.Status.Status)),
+			"output":          cty.StringVal(cfg.Status.Output),
+			"failed_upstream": s.failedUpstreamValue(),
 		}),
 	}
 	if cfg.Each != nil {
@@ -496,9 +569,24 @@ func (s *Stage) run(conductor *Conductor, evalCtx *hcl.EvalContext, options ...r
 		return diags.Diagnostics()
 	}
 
+	ctx := conductor.Context()
+	if s.IsDaemon() {
+		ctx = conductor.DaemonContext()
+	}
+	timeout, d := s.Lifecycle.TimeoutDuration(conductor, evalCtx)
+	diags.Extend(d)
+	if diags.HasErrors() {
+		return diags.Diagnostics()
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	envStrings := s.processEnvironmentVariables(conductor, environment, cfg, tmpDir, paramsGo)
 
-	cmd, d := s.parseExecCommand(conductor, evalCtx, cfg, stream)
+	cmd, d := s.parseExecCommand(ctx, conductor, evalCtx, cfg, output, stream)
 	diags.Extend(d)
 	if diags.HasErrors() {
 		return diags.Diagnostics()
@@ -506,27 +594,98 @@ func (s *Stage) run(conductor *Conductor, evalCtx *hcl.EvalContext, options ...r
 	logger.Trace("command parsed")
 	logger.Tracef("script: %.30s... ", cmd.String())
 
+	// stages which declare their inputs are skipped when nothing has changed
+	// since their last successful run
+	var fingerprint string
+	if !cfg.Hook && !s.IsDaemon() && !cfg.Behavior.DryRun {
+		fingerprint, d = s.fingerprint(conductor, evalCtx, cmd, envStrings)
+		diags.Extend(d)
+		if diags.HasErrors() {
+			return diags.Diagnostics()
+		}
+	}
+	if fingerprint != "" {
+		upToDate, d := s.upToDate(conductor, evalCtx, cmd.Dir, fingerprint)
+		diags.Extend(d)
+		if diags.HasErrors() {
+			return diags.Diagnostics()
+		}
+		if upToDate {
+			logger.Info("inputs and outputs are up to date, skipping")
+			cached = true
+			return diags.Diagnostics()
+		}
+
+		restored, d := s.restoreOutputs(conductor, evalCtx, cmd.Dir, fingerprint)
+		diags.Extend(d)
+		if diags.HasErrors() {
+			return diags.Diagnostics()
+		}
+		if restored {
+			cached = true
+			return diags.Diagnostics()
+		}
+	}
+
+	// the pre hooks are not run when the stage is cached
+	diags.Extend(s.executePreHooks(conductor, status, options...))
+	if diags.HasErrors() {
+		return diags.Diagnostics()
+	}
+
+	// the stage writes its outputs to a TOGOMAK_OUTPUTS file of its own, they are
+	// published once it has finished
+	var exported *exportedOutputs
+	if !cfg.Behavior.DryRun {
+		exported, envStrings, err = s.exportOutputs(conductor, envStrings)
+		if err != nil {
+			diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("failed to create the %s file (%s)", meta.OutputEnvVar, s.Identifier()),
+				Detail:   err.Error(),
+			})
+			return diags.Diagnostics()
+		}
+	}
+
+	// the trace context is not part of the fingerprint, it changes on every run
+	envStrings = append(envStrings, conductor.Tracer().Environ(s.String())...)
+
 	if s.Container == nil {
 		cmd.Env = append(os.Environ(), envStrings...)
 		s.process = cmd
 		logger.Tracef("running command: %.30s...", cmd.String())
 		if !cfg.Behavior.DryRun {
 			err = cmd.Run()
+			if cmd.ProcessState != nil {
+				exitCode = cmd.ProcessState.ExitCode()
+			}
+			if ctx.Err() != nil && cmd.Process != nil {
+				// the processes started by the stage which outlived it are not waited for
+				_ = killProcessGroup(cmd.Process)
+			}
 
 			if err != nil && err.Error() == "signal: terminated" && s.Terminated() {
 				logger.Warnf("command terminated with signal: %s", cmd.ProcessState.String())
 				err = nil
 			}
 		} else {
-			fmt.Println(cmd.String())
+			fmt.Println(logging.Secrets().Redact(cmd.String()))
 		}
 	} else {
 		cmd.Env = envStrings
-		d := s.executeDocker(conductor, evalCtx, cmd, cfg)
+		d := s.executeDocker(ctx, conductor, evalCtx, cmd, cfg, output)
 		diags.Extend(d)
 	}
 
-	if err != nil {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		timedOut = true
+		diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("stage timed out (%s)", s.Identifier()),
+			Detail:   "the stage did not complete within its lifecycle.timeout and was stopped",
+		})
+	} else if err != nil {
 		diags.Append(&hcl.Diagnostic{
 			Severity: hcl.DiagError,
 			Summary:  fmt.Sprintf("failed to run command (%s)", s.Identifier()),
@@ -534,15 +693,43 @@ func (s *Stage) run(conductor *Conductor, evalCtx *hcl.EvalContext, options ...r
 		})
 	}
 
+	// the outputs are published whether the stage succeeded or not
+	var outputs []byte
+	if exported != nil {
+		outputs, err = exported.exported()
+		if err == nil {
+			err = publishOutputs(conductor, outputs)
+		}
+		if err != nil {
+			diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("failed to publish the outputs of the stage (%s)", s.Identifier()),
+				Detail:   err.Error(),
+			})
+		}
+	}
+
+	if fingerprint != "" {
+		if diags.HasErrors() {
+			err = cache.ForgetFingerprint(conductor.Config.Paths.Cwd, s.fingerprintId(conductor))
+		} else {
+			err = cache.SaveFingerprint(conductor.Config.Paths.Cwd, s.fingerprintId(conductor), fingerprint, outputs)
+		}
+		if err != nil {
+			logger.Warnf("failed to update the fingerprint: %s", err)
+		}
+		if !diags.HasErrors() {
+			diags.Extend(s.storeOutputs(conductor, evalCtx, cmd.Dir, fingerprint, outputs))
+		}
+	}
+
 	return diags.Diagnostics()
 }
 
-func (s *Stage) executeDocker(conductor *Conductor, evalCtx *hcl.EvalContext, cmd *exec.Cmd, cfg *runnable.Config) hcl.Diagnostics {
+func (s *Stage) executeDocker(ctx context.Context, conductor *Conductor, evalCtx *hcl.EvalContext, cmd *exec.Cmd, cfg *runnable.Config, output *StageOutput) hcl.Diagnostics {
 	var diags hcl.Diagnostics
 	logger := conductor.Logger().WithField("stage", s.Id)
 
-	ctx := conductor.Context()
-
 	image, d := s.hclImage(conductor, evalCtx)
 	diags = diags.Extend(d)
 
@@ -571,8 +758,10 @@ func (s *Stage) executeDocker(conductor *Conductor, evalCtx *hcl.EvalContext, cm
 	_, _, err = cli.ImageInspectWithRaw(ctx, image)
 	if err != nil {
 		logger.Infof("image %s does not exist, pulling...", image)
+		span := conductor.Tracer().Start(s.String(), "docker", fmt.Sprintf("pull %s", image))
 		reader, err := cli.ImagePull(ctx, image, types.ImagePullOptions{})
 		if err != nil {
+			span.End()
 			return diags.Append(&hcl.Diagnostic{
 				Severity:    hcl.DiagError,
 				Summary:     "could not pull image",
@@ -586,6 +775,7 @@ func (s *Stage) executeDocker(conductor *Conductor, evalCtx *hcl.EvalContext, cm
 		defer pb.Close()
 		defer reader.Close()
 		io.Copy(pb, reader)
+		span.End()
 	}
 
 	logger.Trace("parsing container arguments")
@@ -608,7 +798,7 @@ func (s *Stage) executeDocker(conductor *Conductor, evalCtx *hcl.EvalContext, cm
 		if diags.HasErrors() {
 			continue
 		}
-		binds = append(binds, fmt.Sprintf("%s:%s", source.AsString(), dest.AsString()))
+		binds = append(binds, fmt.Sprintf("%s:%s", unmarkSensitive(source).AsString(), unmarkSensitive(dest).AsString()))
 	}
 	logger.Tracef("%d diagnostic(s) after parsing container volumes", len(diags.Errs()))
 	if diags.HasErrors() {
@@ -617,11 +807,12 @@ func (s *Stage) executeDocker(conductor *Conductor, evalCtx *hcl.EvalContext, cm
 
 	logger.Trace("dry run check")
 	if cfg.Behavior.DryRun {
-		fmt.Println(ui.Blue("# docker:run.image"), ui.Green(image))
+		redact := logging.Secrets().Redact
+		fmt.Println(ui.Blue("# docker:run.image"), ui.Green(redact(image)))
 		fmt.Println(ui.Blue("# docker:run.workdir"), ui.Green("/workspace"))
-		fmt.Println(ui.Blue("# docker:run.volume"), ui.Green(cmd.Dir+":/workspace"))
+		fmt.Println(ui.Blue("# docker:run.volume"), ui.Green(redact(cmd.Dir+":/workspace")))
 		fmt.Println(ui.Blue("# docker:run.stdin"), ui.Green(s.Container.Stdin))
-		fmt.Println(ui.Blue("# docker:run.args"), ui.Green(cmd.String()))
+		fmt.Println(ui.Blue("# docker:run.args"), ui.Green(redact(cmd.String())))
 		return diags
 	}
 
@@ -633,7 +824,7 @@ func (s *Stage) executeDocker(conductor *Conductor, evalCtx *hcl.EvalContext, cm
 	}
 
 	logger.Trace("creating container")
-	resp, err := cli.ContainerCreate(conductor.Context(), &dockerContainer.Config{
+	resp, err := cli.ContainerCreate(ctx, &dockerContainer.Config{
 		Image:        image,
 		WorkingDir:   "/workspace",
 		Cmd:          cmd.Args,
@@ -694,10 +885,27 @@ func (s *Stage) executeDocker(conductor *Conductor, evalCtx *hcl.EvalContext, cm
 	defer responseBody.Close()
 
 	logger.Tracef("copying container logs on container: %s", resp.ID)
+	logFile := conductor.RunLogs().Writer(s.String())
 	if container.Config.Tty {
-		_, err = io.Copy(logger.Writer(), responseBody)
+		_, err = io.Copy(io.MultiWriter(output.Stdout(), logFile), responseBody)
 	} else {
-		_, err = stdcopy.StdCopy(logger.Writer(), logger.WriterLevel(logrus.WarnLevel), responseBody)
+		_, err = stdcopy.StdCopy(io.MultiWriter(output.Stdout(), logFile), io.MultiWriter(output.Stderr(), logFile), responseBody)
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		logger.Warnf("stopping container %s, the stage did not complete within its timeout", resp.ID)
+		// the stage's context has expired, but the container still needs to be stopped and removed
+		ctx = context.Background()
+		gracePeriod := int(TerminationGracePeriod.Seconds())
+		err = cli.ContainerStop(ctx, resp.ID, dockerContainer.StopOptions{Timeout: &gracePeriod})
+		if err != nil {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "failed to stop container",
+				Detail:   fmt.Sprintf("%s: %s", dockerContainerSourceFmt(resp.ID), err.Error()),
+				Subject:  s.Container.Image.Range().Ptr(),
+			})
+		}
 	}
 
 	logger.Trace("waiting for container to finish")
@@ -741,6 +949,7 @@ func (s *Stage) parseEnvironmentVariables(conductor *Conductor, evalCtx *hcl.Eva
 		conductor.Eval().Mutex().RLock()
 		v, d := env.Value.Value(evalCtx)
 		conductor.Eval().Mutex().RUnlock()
+		v = unmarkSensitive(v)
 
 		diags = diags.Extend(d)
 		if v.IsNull() {
@@ -766,7 +975,7 @@ func (s *Stage) parseEnvironmentVariables(conductor *Conductor, evalCtx *hcl.Eva
 	return environment, diags
 }
 
-func (s *Stage) parseExecCommand(conductor *Conductor, evalCtx *hcl.EvalContext, cfg *runnable.Config, outputBuffer io.Writer) (*exec.Cmd, hcl.Diagnostics) {
+func (s *Stage) parseExecCommand(ctx context.Context, conductor *Conductor, evalCtx *hcl.EvalContext, cfg *runnable.Config, output *StageOutput, outputBuffer io.Writer) (*exec.Cmd, hcl.Diagnostics) {
 	var diags hcl.Diagnostics
 	logger := conductor.Logger().WithField("stage", s.Id)
 
@@ -774,6 +983,7 @@ func (s *Stage) parseExecCommand(conductor *Conductor, evalCtx *hcl.EvalContext,
 	conductor.Eval().Mutex().RLock()
 	script, d := s.Script.Value(evalCtx)
 	conductor.Eval().Mutex().RUnlock()
+	script = unmarkSensitive(script)
 
 	if d.HasErrors() && cfg.Behavior.DryRun {
 		script = cty.StringVal(ui.Italic(ui.Yellow("(will be evaluated later)")))
@@ -785,6 +995,7 @@ func (s *Stage) parseExecCommand(conductor *Conductor, evalCtx *hcl.EvalContext,
 	conductor.Eval().Mutex().RLock()
 	shellRaw, d := s.Shell.Value(evalCtx)
 	conductor.Eval().Mutex().RUnlock()
+	shellRaw = unmarkSensitive(shellRaw)
 
 	shell := ""
 	if d.HasErrors() {
@@ -801,6 +1012,7 @@ func (s *Stage) parseExecCommand(conductor *Conductor, evalCtx *hcl.EvalContext,
 	conductor.Eval().Mutex().RLock()
 	args, d := s.Args.Value(evalCtx)
 	conductor.Eval().Mutex().RUnlock()
+	args = unmarkSensitive(args)
 	diags = diags.Extend(d)
 
 	cmdHcl, d := s.parseCommand(evalCtx, shell, script, args)
@@ -814,6 +1026,7 @@ func (s *Stage) parseExecCommand(conductor *Conductor, evalCtx *hcl.EvalContext,
 	conductor.Eval().Mutex().RLock()
 	dirParsed, d := s.Dir.Value(evalCtx)
 	conductor.Eval().Mutex().RUnlock()
+	dirParsed = unmarkSensitive(dirParsed)
 
 	if d.HasErrors() {
 		diags = diags.Extend(d)
@@ -825,13 +1038,24 @@ func (s *Stage) parseExecCommand(conductor *Conductor, evalCtx *hcl.EvalContext,
 			dir = filepath.Join(cfg.Paths.Cwd, dir)
 		}
 		if cfg.Behavior.DryRun {
-			fmt.Println(ui.Blue("cd"), dir)
+			fmt.Println(ui.Blue("cd"), logging.Secrets().Redact(dir))
 		}
 	}
 
-	cmd := exec.CommandContext(conductor.Context(), cmdHcl.command, cmdHcl.args...)
-	cmd.Stdout = io.MultiWriter(logger.Writer(), outputBuffer)
-	cmd.Stderr = io.MultiWriter(logger.Writer(), outputBuffer)
+	cmd := exec.CommandContext(ctx, cmdHcl.command, cmdHcl.args...)
+	setProcessGroup(cmd)
+	if _, ok := ctx.Deadline(); ok {
+		// when the deadline is exceeded, the process and the processes it started
+		// are asked to terminate, and are killed only if they are still running
+		// after the grace period
+		cmd.Cancel = func() error {
+			return terminateProcessGroup(cmd.Process)
+		}
+		cmd.WaitDelay = TerminationGracePeriod
+	}
+	logFile := conductor.RunLogs().Writer(s.String())
+	cmd.Stdout = io.MultiWriter(output.Stdout(), outputBuffer, logFile)
+	cmd.Stderr = io.MultiWriter(output.Stdout(), outputBuffer, logFile)
 	cmd.Dir = dir
 	return cmd, diags
 }
@@ -902,7 +1126,7 @@ func (s *Stage) processEnvironmentVariables(conductor *Conductor, environment ma
 	for k, v := range environment {
 		envParsed := fmt.Sprintf("%s=%s", k, v.AsString())
 		if cfg.Behavior.DryRun {
-			fmt.Println(ui.Blue("export"), envParsed)
+			fmt.Println(ui.Blue("export"), logging.Secrets().Redact(envParsed))
 		}
 
 		envStrings[envCounter] = envParsed
@@ -914,9 +1138,9 @@ func (s *Stage) processEnvironmentVariables(conductor *Conductor, environment ma
 
 	if s.Use != nil && s.Use.Parameters != nil {
 		for k, v := range paramsGo {
-			envParsed := fmt.Sprintf("%s%s=%s", TogomakParamEnvVarPrefix, k, v.AsString())
+			envParsed := fmt.Sprintf("%s%s=%s", TogomakParamEnvVarPrefix, k, unmarkSensitive(v).AsString())
 			if cfg.Behavior.DryRun {
-				fmt.Println(ui.Blue("export"), envParsed)
+				fmt.Println(ui.Blue("export"), logging.Secrets().Redact(envParsed))
 			}
 
 			envStrings = append(envStrings, envParsed)
@@ -944,6 +1168,7 @@ func (s *Stage) hclImage(conductor *Conductor, evalCtx *hcl.EvalContext) (image
 	conductor.Eval().Mutex().RLock()
 	imageRaw, d := s.Container.Image.Value(evalCtx)
 	conductor.Eval().Mutex().RUnlock()
+	imageRaw = unmarkSensitive(imageRaw)
 
 	if d.HasErrors() {
 		diags = diags.Extend(d)
@@ -967,6 +1192,7 @@ func (s *Stage) hclEndpoint(conductor *Conductor, evalCtx *hcl.EvalContext) ([]s
 	conductor.Eval().Mutex().RLock()
 	entrypointRaw, d := s.Container.Entrypoint.Value(evalCtx)
 	conductor.Eval().Mutex().RUnlock()
+	entrypointRaw = unmarkSensitive(entrypointRaw)
 
 	var entrypoint []string
 
@@ -1015,11 +1241,12 @@ func (s *Stage) CanRun(conductor *Conductor, options ...runnable.Option) (ok boo
 
 	evalCtx.Variables = map[string]cty.Value{
 		"this": cty.ObjectVal(map[string]cty.Value{
-			"name":   cty.StringVal(name),
-			"id":     cty.StringVal(id),
-			"hook":   cty.BoolVal(cfg.Hook),
-			"status": cty.StringVal(string(cfg.Status.Status)),
-			"output": cty.StringVal(cfg.Status.Output),
+			"name":            cty.StringVal(name),
+			"id":              cty.StringVal(id),
+			"hook":            cty.BoolVal(cfg.Hook),
+			"status":          cty.StringVal(string(cfg.Status.Status)),
+			"output":          cty.StringVal(cfg.Status.Output),
+			"failed_upstream": s.failedUpstreamValue(),
 		}),
 		"param": cty.ObjectVal(paramsGo),
 	}
@@ -1043,6 +1270,7 @@ func (s *Stage) CanRun(conductor *Conductor, options ...runnable.Option) (ok boo
 	if d.HasErrors() {
 		return false, diags.Extend(d)
 	}
+	v = unmarkSensitive(v)
 
 	if v.Equals(cty.False).True() {
 		// this stage has been explicitly evaluated to false
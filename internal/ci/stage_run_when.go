@@ -0,0 +1,115 @@
+package ci
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const (
+	RunWhenOnSuccess = "on_success"
+	RunWhenOnFailure = "on_failure"
+	RunWhenAlways    = "always"
+)
+
+// RunCondition returns the run_when attribute of the stage, defaulting to
+// RunWhenOnSuccess when it is not set
+func (s *Stage) RunCondition() (string, hcl.Diagnostics) {
+	switch s.RunWhen {
+	case "":
+		return RunWhenOnSuccess, nil
+	case RunWhenOnSuccess, RunWhenOnFailure, RunWhenAlways:
+		return s.RunWhen, nil
+	}
+	return RunWhenOnSuccess, hcl.Diagnostics{
+		{
+			Severity: hcl.DiagError,
+			Summary:  "invalid run_when",
+			Detail: fmt.Sprintf("stage.%s has run_when = %q, expected one of %q, %q or %q",
+				s.Id, s.RunWhen, RunWhenOnSuccess, RunWhenOnFailure, RunWhenAlways),
+		},
+	}
+}
+
+// FailedUpstream returns the identifiers of the runnables this stage
+// depends on which have failed, as set by the pipeline before it runs
+func (s *Stage) FailedUpstream() []string {
+	failed, _ := s.Get(StageContextFailedUpstream).([]string)
+	return failed
+}
+
+func (s *Stage) failedUpstreamValue() cty.Value {
+	failed := s.FailedUpstream()
+	if len(failed) == 0 {
+		return cty.ListValEmpty(cty.String)
+	}
+	var values []cty.Value
+	for _, runnableId := range failed {
+		values = append(values, cty.StringVal(runnableId))
+	}
+	return cty.ListVal(values)
+}
+
+// RunWhenSatisfied reports whether the run_when attribute of runnable allows it
+// to run, given the runnables it depends on which have failed. Blocks other than
+// stages only run when none of their dependencies have failed.
+func RunWhenSatisfied(runnable Block) (bool, hcl.Diagnostics) {
+	stage, isStage := runnable.(*Stage)
+	if !isStage {
+		return true, nil
+	}
+	runWhen, diags := stage.RunCondition()
+	if diags.HasErrors() {
+		return false, diags
+	}
+	failed := len(stage.FailedUpstream()) > 0
+	switch runWhen {
+	case RunWhenOnFailure:
+		return failed, nil
+	case RunWhenAlways:
+		return true, nil
+	}
+	return !failed, nil
+}
+
+// RunsAfterFailure returns the runnables which still run after the pipeline has
+// failed: the stages with a run_when of "always" or "on_failure", and the
+// runnables which they depend on that are not stages or modules themselves,
+// such as locals and data blocks.
+func (pipe *Pipeline) RunsAfterFailure(depGraph *depgraph.Graph) map[string]bool {
+	runs := make(map[string]bool)
+	for _, layer := range depGraph.TopoSortedLayers() {
+		for _, runnableId := range layer {
+			runnable, skip, d := pipe.Resolve(runnableId)
+			if skip || d.HasErrors() {
+				continue
+			}
+			stage, isStage := runnable.(*Stage)
+			if !isStage {
+				continue
+			}
+			if runWhen, _ := stage.RunCondition(); runWhen == RunWhenOnSuccess {
+				continue
+			}
+			runs[runnableId] = true
+			for dependency := range depGraph.Dependencies(runnableId) {
+				if _, ok := runs[dependency]; ok {
+					continue
+				}
+				block, skip, d := pipe.Resolve(dependency)
+				if skip || d.HasErrors() {
+					continue
+				}
+				if _, isStage := block.(*Stage); isStage {
+					continue
+				}
+				if _, isModule := block.(*Module); isModule {
+					continue
+				}
+				runs[dependency] = true
+			}
+		}
+	}
+	return runs
+}
@@ -176,6 +176,18 @@ type CoreStage struct {
 	// Additional documentation on the Retry block is available on the StageRetry block
 	Retry *StageRetry `hcl:"retry,block" json:"retry"`
 
+	// AllowFailure when set to true, records the failure of the stage as a warning
+	// instead of failing the pipeline, and lets the stages which depend on it continue.
+	// The stage is considered failed only after all of its retries have failed.
+	AllowFailure bool `hcl:"allow_failure,optional" json:"allow_failure"`
+
+	// RunWhen decides if the stage runs when a stage it depends on has failed.
+	// "on_success" (the default) skips the stage, "on_failure" runs the stage
+	// only when one of the stages it depends on has failed, and "always" runs
+	// the stage regardless. Stages with "always" or "on_failure" also run once
+	// the pipeline has failed, which makes them useful for cleanup and rollback.
+	RunWhen string `hcl:"run_when,optional" json:"run_when"`
+
 	// Name allows you to set a friendly name for the stage
 	Name string `hcl:"name,optional" json:"name"`
 
@@ -199,6 +211,21 @@ type CoreStage struct {
 	// If Args are executed along a container, it could pass the arguments to the Docker container's StageContainer.Entrypoint
 	Args hcl.Expression `hcl:"args,optional" json:"args"`
 
+	// Inputs accepts a list of file globs, relative to Dir, which the stage reads.
+	// When Inputs is set, the stage is skipped with a "cached" status if the content
+	// of the inputs, the rendered Script or Args and the Environment have not changed
+	// since the last successful run, and every glob in Outputs still matches a file.
+	Inputs hcl.Expression `hcl:"inputs,optional" json:"inputs"`
+
+	// Outputs accepts a list of file globs, relative to Dir, which the stage produces.
+	// See Inputs.
+	Outputs hcl.Expression `hcl:"outputs,optional" json:"outputs"`
+
+	// Watch accepts a list of file globs, relative to Dir. When a matching file
+	// changes, togomak watch runs the stage again, along with the stages which
+	// depend on it. Changes to the files matching Outputs are ignored.
+	Watch hcl.Expression `hcl:"watch,optional" json:"watch"`
+
 	// Container allows you to use a Docker container image as a backend
 	Container *StageContainer `hcl:"container,block" json:"container"`
 
@@ -220,7 +247,8 @@ type Lifecycle struct {
 	// Phase type of the phase needs to be specified
 	Phase hcl.Expression `hcl:"phase,optional" json:"stage" expr:"phase"`
 
-	// Timeout how long the service needs to wait before killing itself
+	// Timeout is the number of seconds the stage, or module, is allowed to run
+	// before it is stopped. See TerminationGracePeriod.
 	Timeout hcl.Expression `hcl:"timeout,optional" json:"timeout"`
 }
 
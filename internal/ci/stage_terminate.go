@@ -8,7 +8,6 @@ import (
 	dockerClient "github.com/docker/docker/client"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/srevinsaju/togomak/v1/internal/runnable"
-	"syscall"
 )
 
 func (s *Stage) Terminate(conductor *Conductor, safe bool) hcl.Diagnostics {
@@ -60,7 +59,7 @@ func (s *Stage) Terminate(conductor *Conductor, safe bool) hcl.Diagnostics {
 				return diags
 			}
 		}
-		err := s.process.Process.Signal(syscall.SIGTERM)
+		err := terminateProcessGroup(s.process.Process)
 		if err != nil {
 			diags = diags.Append(&hcl.Diagnostic{
 				Severity: hcl.DiagError,
@@ -77,7 +76,7 @@ func (s *Stage) Terminate(conductor *Conductor, safe bool) hcl.Diagnostics {
 func (s *Stage) Kill() hcl.Diagnostics {
 	diags := s.Terminate(nil, false)
 	if s.process != nil && !s.process.ProcessState.Exited() {
-		err := s.process.Process.Kill()
+		err := killProcessGroup(s.process.Process)
 		if err != nil {
 			diags = diags.Append(&hcl.Diagnostic{
 				Severity: hcl.DiagError,
@@ -1,7 +1,10 @@
 package ci
 
 import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
 	"testing"
 )
 
@@ -20,3 +23,100 @@ func TestStage_Get(t *testing.T) {
 	stage := Stage{}
 	assert.Equal(t, stage.Get("key"), nil)
 }
+
+func TestStage_Variables(t *testing.T) {
+	forEach, diags := hclsyntax.ParseExpression([]byte("local.m"), "togomak.hcl", hcl.InitialPos)
+	assert.False(t, diags.HasErrors())
+	inputs, diags := hclsyntax.ParseExpression([]byte("local.sources"), "togomak.hcl", hcl.InitialPos)
+	assert.False(t, diags.HasErrors())
+	stage := Stage{
+		Id:      "movie",
+		ForEach: forEach,
+		CoreStage: CoreStage{
+			DependsOn: hcl.StaticExpr(cty.NilVal, hcl.Range{}),
+			Condition: hcl.StaticExpr(cty.NilVal, hcl.Range{}),
+			Dir:       hcl.StaticExpr(cty.NilVal, hcl.Range{}),
+			Script:    hcl.StaticExpr(cty.NilVal, hcl.Range{}),
+			Shell:     hcl.StaticExpr(cty.NilVal, hcl.Range{}),
+			Args:      hcl.StaticExpr(cty.NilVal, hcl.Range{}),
+			Inputs:    inputs,
+			Outputs:   hcl.StaticExpr(cty.NilVal, hcl.Range{}),
+		},
+	}
+
+	var dependencies []string
+	for _, variable := range stage.Variables() {
+		dependency, d := ResolveFromTraversal(variable)
+		assert.False(t, d.HasErrors())
+		dependencies = append(dependencies, dependency)
+	}
+	assert.Contains(t, dependencies, "local.m")
+	assert.Contains(t, dependencies, "local.sources")
+}
+
+func TestStage_RunCondition(t *testing.T) {
+	stage := Stage{Id: "cleanup"}
+	runWhen, diags := stage.RunCondition()
+	assert.False(t, diags.HasErrors())
+	assert.Equal(t, RunWhenOnSuccess, runWhen)
+
+	stage.RunWhen = "sometimes"
+	_, diags = stage.RunCondition()
+	assert.True(t, diags.HasErrors())
+}
+
+func TestRunWhenSatisfied(t *testing.T) {
+	tests := []struct {
+		runWhen        string
+		failedUpstream []string
+		want           bool
+	}{
+		{"", nil, true},
+		{"", []string{"stage.deploy"}, false},
+		{RunWhenOnFailure, nil, false},
+		{RunWhenOnFailure, []string{"stage.deploy"}, true},
+		{RunWhenAlways, nil, true},
+		{RunWhenAlways, []string{"stage.deploy"}, true},
+	}
+	for _, tt := range tests {
+		stage := &Stage{Id: "cleanup"}
+		stage.RunWhen = tt.runWhen
+		stage.Set(StageContextFailedUpstream, tt.failedUpstream)
+		ok, diags := RunWhenSatisfied(stage)
+		assert.False(t, diags.HasErrors())
+		assert.Equal(t, tt.want, ok, "run_when=%q failed_upstream=%v", tt.runWhen, tt.failedUpstream)
+	}
+
+	ok, diags := RunWhenSatisfied(&Local{})
+	assert.False(t, diags.HasErrors())
+	assert.True(t, ok)
+}
+
+func TestStage_ForEachSensitive(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPipeline(t, dir, `
+togomak {
+  version = 2
+}
+
+variable "targets" {
+  type      = list(string)
+  default   = ["linux", "darwin"]
+  sensitive = true
+}
+
+stage "build" {
+  for_each = toset(var.targets)
+  script   = "echo ${each.key}"
+}
+`)
+
+	diags := runTestPipeline(t, dir)
+	assert.True(t, diags.HasErrors())
+	var summaries []string
+	for _, diag := range diags {
+		summaries = append(summaries, diag.Summary)
+	}
+	assert.Contains(t, summaries, "sensitive value cannot be used in for_each")
+	assert.NotContains(t, summaries, "invalid type for for_each")
+}
@@ -0,0 +1,108 @@
+package ci
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AutoVariableFiles returns the files with the values of variables in dir which
+// are loaded without --var-file, the files ending with .auto.vars.hcl or
+// .auto.vars.json, sorted by their names
+func AutoVariableFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(entry.Name(), meta.AutoVarFileSuffix) || strings.HasSuffix(entry.Name(), meta.AutoVarFileJSONSuffix) {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ReadVariableFiles reads the values of variables from the files in paths. Each
+// attribute of a file is the value of the variable with the same name, and may
+// only be a literal value, such as a string, a list or a map. Files ending with
+// .json are read as JSON, and the other files as HCL.
+//
+// The values of a file take precedence over the values of the files before it,
+// the variables returned are ordered by precedence, as expected by resolveVar.
+func ReadVariableFiles(parser *Parser, paths []string) (Variables, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	var variables Variables
+	for i := len(paths) - 1; i >= 0; i-- {
+		var f *hcl.File
+		var d hcl.Diagnostics
+		if strings.HasSuffix(paths[i], ".json") {
+			f, d = parser.ParseJSONFile(paths[i])
+		} else {
+			f, d = parser.ParseHCLFile(paths[i])
+		}
+		diags = diags.Extend(d)
+		if d.HasErrors() {
+			continue
+		}
+
+		attrs, d := f.Body.JustAttributes()
+		diags = diags.Extend(d)
+		names := make([]string, 0, len(attrs))
+		for name := range attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			attr := attrs[name]
+			value, d := attr.Expr.Value(nil)
+			diags = diags.Extend(d)
+			if d.HasErrors() {
+				continue
+			}
+			variables = append(variables, &Variable{
+				Id:    name,
+				Value: hcl.StaticExpr(value, attr.Expr.Range()),
+				file:  paths[i],
+			})
+		}
+	}
+	return variables, diags
+}
+
+// undeclaredVariables warns about the values of variables in variable files,
+// which no variable block of the pipeline declares, since they are ignored
+func undeclaredVariables(conductor *Conductor, pipe *Pipeline) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	declared := map[string]bool{}
+	for _, v := range pipe.Vars {
+		declared[v.Id] = true
+	}
+	for _, v := range conductor.Variables() {
+		if v.file == "" || declared[v.Id] {
+			continue
+		}
+		// the file is parsed again by the parser of the conductor, so that its
+		// source is shown along with the warning
+		if strings.HasSuffix(v.file, ".json") {
+			conductor.Parser.ParseJSONFile(v.file)
+		} else {
+			conductor.Parser.ParseHCLFile(v.file)
+		}
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagWarning,
+			Summary:  "Value for undeclared variable",
+			Detail:   fmt.Sprintf("The variable file %s sets a value for %q, but the pipeline has no variable block named %q, the value is ignored. Declare the variable with a variable block, or remove its value from the file.", v.file, v.Id, v.Id),
+			Subject:  v.Value.Range().Ptr(),
+		})
+	}
+	return diags
+}
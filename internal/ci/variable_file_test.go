@@ -0,0 +1,158 @@
+package ci
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAutoVariableFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.auto.vars.hcl", "a.auto.vars.json", "c.vars.hcl", "togomak.hcl"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	paths := AutoVariableFiles(dir)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "a.auto.vars.json"),
+		filepath.Join(dir, "b.auto.vars.hcl"),
+	}, paths)
+}
+
+func TestReadVariableFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.vars.hcl")
+	second := filepath.Join(dir, "second.vars.json")
+	assert.NoError(t, os.WriteFile(first, []byte(`
+regions = ["eu", "us"]
+replicas = 2
+`), 0644))
+	assert.NoError(t, os.WriteFile(second, []byte(`{"replicas": 3, "labels": {"team": "ci"}}`), 0644))
+
+	variables, diags := ReadVariableFiles(NewParser(), []string{first, second})
+	assert.False(t, diags.HasErrors(), diags.Error())
+
+	conductor := newRunStateTestConductor(t, dir, time.Now())
+	conductor.variables = variables
+
+	regions, diags := (&Variable{Id: "regions", Ty: parseTestExpression(t, "list(string)")}).resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.True(t, regions.Equals(cty.ListVal([]cty.Value{cty.StringVal("eu"), cty.StringVal("us")})).True())
+
+	// the later file takes precedence
+	replicas, diags := (&Variable{Id: "replicas", Ty: parseTestExpression(t, "number")}).resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.True(t, replicas.Equals(cty.NumberIntVal(3)).True())
+
+	labels, diags := (&Variable{Id: "labels", Ty: parseTestExpression(t, "map(string)")}).resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.True(t, labels.Equals(cty.MapVal(map[string]cty.Value{"team": cty.StringVal("ci")})).True())
+}
+
+func TestReadVariableFiles_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "invalid.vars.hcl")
+	assert.NoError(t, os.WriteFile(path, []byte(`replicas = var.count`), 0644))
+
+	_, diags := ReadVariableFiles(NewParser(), []string{path})
+	assert.True(t, diags.HasErrors())
+	assert.Equal(t, path, diags[0].Subject.Filename)
+}
+
+func TestVariable_Precedence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "replicas.vars.hcl")
+	assert.NoError(t, os.WriteFile(path, []byte(`replicas = 2`), 0644))
+	fileVariables, diags := ReadVariableFiles(NewParser(), []string{path})
+	assert.False(t, diags.HasErrors(), diags.Error())
+	flag, diags := ParseVariableShell("replicas=4")
+	assert.False(t, diags.HasErrors(), diags.Error())
+
+	conductor := newRunStateTestConductor(t, dir, time.Now())
+	replicas := &Variable{Id: "replicas", Ty: parseTestExpression(t, "number"), Default: parseTestExpression(t, "1")}
+	tests := []struct {
+		name      string
+		variables Variables
+		env       string
+		want      int64
+	}{
+		{"default", nil, "", 1},
+		{"variable file", fileVariables, "", 2},
+		{"environment", fileVariables, "3", 3},
+		{"flag", append(Variables{flag}, fileVariables...), "3", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TOGOMAK_VAR_replicas", tt.env)
+			conductor.variables = tt.variables
+			value, diags := replicas.resolveVarTypedWithDefaults(conductor)
+			assert.False(t, diags.HasErrors(), diags.Error())
+			assert.True(t, value.Equals(cty.NumberIntVal(tt.want)).True(), value.GoString())
+		})
+	}
+}
+
+func TestVariable_PrecedenceOfSources(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "replicas.vars.hcl")
+	assert.NoError(t, os.WriteFile(path, []byte(`replicas = 2`), 0644))
+	fileVariables, diags := ReadVariableFiles(NewParser(), []string{path})
+	assert.False(t, diags.HasErrors(), diags.Error())
+	flag, diags := ParseVariableShell("replicas=4")
+	assert.False(t, diags.HasErrors(), diags.Error())
+
+	conductor := newRunStateTestConductor(t, dir, time.Now())
+	replicas := &Variable{Id: "replicas", Ty: parseTestExpression(t, "number")}
+	// variable file < TOGOMAK_VAR_<name> < --var, up to v2.0.0-alpha.16,
+	// TOGOMAK_VAR_<name> took precedence over --var
+	tests := []struct {
+		name      string
+		variables Variables
+		env       string
+		want      int64
+	}{
+		{"all sources", append(Variables{flag}, fileVariables...), "3", 4},
+		{"flag over environment", Variables{flag}, "3", 4},
+		{"flag over variable file", append(Variables{flag}, fileVariables...), "", 4},
+		{"environment over variable file", fileVariables, "3", 3},
+		{"variable file", fileVariables, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TOGOMAK_VAR_replicas", tt.env)
+			conductor.variables = tt.variables
+			value, diags := replicas.resolveVarTypedWithDefaults(conductor)
+			assert.False(t, diags.HasErrors(), diags.Error())
+			assert.True(t, value.Equals(cty.NumberIntVal(tt.want)).True(), value.GoString())
+		})
+	}
+}
+
+func TestUndeclaredVariables(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ci.vars.hcl")
+	assert.NoError(t, os.WriteFile(path, []byte("replicas = 2\nreplica = 3\n"), 0644))
+	fileVariables, diags := ReadVariableFiles(NewParser(), []string{path})
+	assert.False(t, diags.HasErrors(), diags.Error())
+	flag, diags := ParseVariableShell("region=eu")
+	assert.False(t, diags.HasErrors(), diags.Error())
+
+	conductor := newRunStateTestConductor(t, dir, time.Now())
+	conductor.variables = append(Variables{flag}, fileVariables...)
+	conductor.Parser = NewParser()
+	pipe := &Pipeline{Vars: Variables{{Id: "replicas"}}}
+
+	// only the values of the variable files are checked
+	diags = undeclaredVariables(conductor, pipe)
+	assert.Len(t, diags, 1)
+	assert.Equal(t, hcl.DiagWarning, diags[0].Severity)
+	assert.Equal(t, "Value for undeclared variable", diags[0].Summary)
+	assert.Contains(t, diags[0].Detail, `"replica"`)
+	assert.Equal(t, path, diags[0].Subject.Filename)
+	// the source of the file is shown along with the warning
+	assert.Contains(t, conductor.Parser.Files(), path)
+}
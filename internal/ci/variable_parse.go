@@ -3,6 +3,8 @@ package ci
 import (
 	"fmt"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/zclconf/go-cty/cty"
 	"strings"
 )
@@ -31,6 +33,75 @@ func ParseVariableShell(raw string) (*Variable, hcl.Diagnostics) {
 	return &Variable{
 		Id:    name,
 		Value: hcl.StaticExpr(cty.StringVal(rawVal), hcl.Range{}),
+		raw:   true,
 	}, diags
 
 }
+
+// typeConstraint returns the type constraint of the variable, and the defaults
+// of its optional attributes. A variable without a type accepts any value, the
+// returned bool is false in that case.
+func (v *Variable) typeConstraint() (cty.Type, *typeexpr.Defaults, bool, hcl.Diagnostics) {
+	if v.Ty == nil {
+		return cty.DynamicPseudoType, nil, false, nil
+	}
+	// a type constraint, such as string, cannot be evaluated as a value, while
+	// a missing type evaluates to null
+	uType, d := v.Ty.Value(nil)
+	if !d.HasErrors() && uType.IsNull() {
+		return cty.DynamicPseudoType, nil, false, nil
+	}
+	ty, def, diags := typeexpr.TypeConstraintWithDefaults(v.Ty)
+	return ty, def, true, diags
+}
+
+// parseRawValue parses raw, a string value of the variable from source, such as
+// the command line or the environment. Like terraform, raw is kept as a string
+// when the variable has no type or a primitive type, such as string or number,
+// which is converted later, and is parsed as a HCL literal expression, such as
+// ["a", "b"], otherwise. The marks of raw are kept.
+func (v *Variable) parseRawValue(raw cty.Value, source string) (cty.Value, hcl.Diagnostics) {
+	unmarked, valueMarks := raw.Unmark()
+	if unmarked.Type() != cty.String || unmarked.IsNull() || !unmarked.IsKnown() {
+		return raw, nil
+	}
+	ty, _, ok, d := v.typeConstraint()
+	if !ok || d.HasErrors() || ty.IsPrimitiveType() {
+		return raw, nil
+	}
+
+	filename := fmt.Sprintf("<value for var.%s>", v.Id)
+	expr, diags := hclsyntax.ParseExpression([]byte(unmarked.AsString()), filename, hcl.InitialPos)
+	if !diags.HasErrors() {
+		var value cty.Value
+		value, diags = expr.Value(nil)
+		if !diags.HasErrors() {
+			return value.WithMarks(valueMarks), nil
+		}
+	}
+
+	// the values of sensitive variables are not shown in the diagnostic
+	shown := ""
+	if !v.Sensitive && !raw.IsMarked() {
+		shown = fmt.Sprintf(" %q", unmarked.AsString())
+	}
+	return cty.NilVal, hcl.Diagnostics{{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid value for variable",
+		Detail:   fmt.Sprintf("The value%s of variable %s from %s must be a HCL literal of type %s, such as [\"a\", \"b\"] or {a = \"b\"}: %s.", shown, v.Id, source, typeexpr.TypeString(ty), diags[0].Summary),
+	}}
+}
+
+// parseRawVariable parses value, the value of raw, a variable from the command
+// line or passed by a module block, with parseRawValue
+func (v *Variable) parseRawVariable(raw *Variable, value cty.Value) (cty.Value, hcl.Diagnostics) {
+	rng := raw.Value.Range()
+	if rng.Filename == "" {
+		return v.parseRawValue(value, "the command line")
+	}
+	value, diags := v.parseRawValue(value, fmt.Sprintf("the module block at %s", rng.String()))
+	for _, diag := range diags {
+		diag.Subject = rng.Ptr()
+	}
+	return value, diags
+}
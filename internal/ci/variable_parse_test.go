@@ -0,0 +1,84 @@
+package ci
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+	"testing"
+	"time"
+)
+
+func TestVariable_ParseRawValue(t *testing.T) {
+	conductor := newRunStateTestConductor(t, t.TempDir(), time.Now())
+	for _, raw := range []string{`targets=["a", "b"]`, `untyped=["a", "b"]`, `name=["a", "b"]`, `replicas=3`, `invalid=a,b`, `secret=a,b`} {
+		variable, diags := ParseVariableShell(raw)
+		assert.False(t, diags.HasErrors())
+		conductor.variables = append(conductor.variables, variable)
+	}
+
+	targets, diags := (&Variable{Id: "targets", Ty: parseTestExpression(t, "list(string)")}).resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.True(t, targets.Equals(cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")})).True())
+
+	// variables without a type, or with a primitive type, are not parsed
+	untyped, diags := (&Variable{Id: "untyped"}).resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.Equal(t, cty.StringVal(`["a", "b"]`), untyped)
+
+	name, diags := (&Variable{Id: "name", Ty: parseTestExpression(t, "string")}).resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.Equal(t, cty.StringVal(`["a", "b"]`), name)
+
+	replicas, diags := (&Variable{Id: "replicas", Ty: parseTestExpression(t, "number")}).resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.True(t, replicas.Equals(cty.NumberIntVal(3)).True())
+
+	_, diags = (&Variable{Id: "invalid", Ty: parseTestExpression(t, "list(string)")}).resolveVarTypedWithDefaults(conductor)
+	assert.True(t, diags.HasErrors())
+	assert.Equal(t, "Invalid value for variable", diags[0].Summary)
+	assert.Contains(t, diags[0].Detail, `"a,b"`)
+	assert.Contains(t, diags[0].Detail, "list(string)")
+
+	// the values of sensitive variables are not shown
+	_, diags = (&Variable{Id: "secret", Ty: parseTestExpression(t, "list(string)"), Sensitive: true}).resolveVarTypedWithDefaults(conductor)
+	assert.True(t, diags.HasErrors())
+	assert.NotContains(t, diags[0].Detail, "a,b")
+}
+
+func TestVariable_ParseRawValue_Environment(t *testing.T) {
+	conductor := newRunStateTestConductor(t, t.TempDir(), time.Now())
+	t.Setenv("TOGOMAK_VAR_labels", `{team = "ci"}`)
+
+	labels, diags := (&Variable{Id: "labels", Ty: parseTestExpression(t, "map(string)")}).resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.True(t, labels.Equals(cty.MapVal(map[string]cty.Value{"team": cty.StringVal("ci")})).True())
+
+	t.Setenv("TOGOMAK_VAR_labels", `team`)
+	_, diags = (&Variable{Id: "labels", Ty: parseTestExpression(t, "map(string)")}).resolveVarTypedWithDefaults(conductor)
+	assert.True(t, diags.HasErrors())
+	assert.Contains(t, diags[0].Detail, "TOGOMAK_VAR_labels")
+}
+
+func TestVariable_ParseRawValue_Module(t *testing.T) {
+	conductor := newRunStateTestConductor(t, t.TempDir(), time.Now())
+	rng := hcl.Range{Filename: "togomak.hcl", Start: hcl.InitialPos, End: hcl.InitialPos}
+	conductor.variables = Variables{
+		{Id: "targets", Value: hcl.StaticExpr(cty.StringVal(`["a"]`), rng), raw: true},
+		{Id: "invalid", Value: hcl.StaticExpr(cty.StringVal(`a`), rng), raw: true},
+		{Id: "replicas", Value: hcl.StaticExpr(cty.StringVal(`two`), rng), raw: true},
+	}
+
+	targets, diags := (&Variable{Id: "targets", Ty: parseTestExpression(t, "list(string)")}).resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.True(t, targets.Equals(cty.ListVal([]cty.Value{cty.StringVal("a")})).True())
+
+	_, diags = (&Variable{Id: "invalid", Ty: parseTestExpression(t, "list(string)")}).resolveVarTypedWithDefaults(conductor)
+	assert.True(t, diags.HasErrors())
+	assert.Equal(t, "togomak.hcl", diags[0].Subject.Filename)
+
+	// a value which cannot be converted to the type of the variable
+	_, diags = (&Variable{Id: "replicas", Ty: parseTestExpression(t, "number")}).resolveVarTypedWithDefaults(conductor)
+	assert.True(t, diags.HasErrors())
+	assert.Equal(t, "Invalid variable value", diags[0].Summary)
+	assert.Contains(t, diags[0].Detail, "number")
+}
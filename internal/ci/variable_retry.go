@@ -19,3 +19,7 @@ func (v *Variable) RetryExponentialBackoff() bool {
 func (v *Variable) MaxRetries() int {
 	return 0
 }
+
+func (v *Variable) FailureAllowed() bool {
+	return false
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/srevinsaju/togomak/v1/internal/blocks"
 	"github.com/srevinsaju/togomak/v1/internal/global"
 	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/srevinsaju/togomak/v1/internal/third-party/hashicorp/terraform/lang/marks"
 	"github.com/zclconf/go-cty/cty"
 	"github.com/zclconf/go-cty/cty/convert"
 	"os"
@@ -18,19 +19,30 @@ func (v *Variable) Prepare(conductor *Conductor, skip bool, overridden bool) hcl
 	return nil // no-op
 }
 
+// resolveVar returns the value of the variable, from the first of these which
+// sets it:
+//   - the --var flags, or the values passed by the module block
+//   - the TOGOMAK_VAR_<id> environment variable
+//   - the --var-file flags, the last file first
+//   - the *.auto.vars.hcl and *.auto.vars.json files next to the pipeline, in
+//     lexical order of their names, the last file first
+//   - the default value of the variable
+//   - a prompt
+//
+// The --var flags, the variable files and the values passed to a module are
+// all in conductor.Variables(), ordered by precedence.
 func (v *Variable) resolveVar(conductor *Conductor) (cty.Value, hcl.Diagnostics) {
 	var diags hcl.Diagnostics
-	osEnvValue := os.Getenv(fmt.Sprintf("TOGOMAK_VAR_%s", v.Id))
+	if value, ok, d := v.resolveGiven(conductor, false); ok {
+		return value, d
+	}
+	envName := fmt.Sprintf("TOGOMAK_VAR_%s", v.Id)
+	osEnvValue := os.Getenv(envName)
 	if osEnvValue != "" {
-		return cty.StringVal(osEnvValue), nil
+		return v.parseRawValue(cty.StringVal(osEnvValue), fmt.Sprintf("the %s environment variable", envName))
 	}
-	for _, cliVariable := range conductor.Variables() {
-		if cliVariable.Id == v.Id {
-			conductor.Eval().Mutex().RLock()
-			b, d := cliVariable.Value.Value(conductor.Eval().Context())
-			conductor.Eval().Mutex().RUnlock()
-			return b, diags.Extend(d)
-		}
+	if value, ok, d := v.resolveGiven(conductor, true); ok {
+		return value, d
 	}
 	if v.Default != nil {
 		conductor.Eval().Mutex().RLock()
@@ -46,42 +58,77 @@ func (v *Variable) resolveVar(conductor *Conductor) (cty.Value, hcl.Diagnostics)
 	var resp string
 	conductor.StdinLock()
 	defer conductor.StdinUnlock()
-	err := survey.AskOne(&survey.Input{
+	var prompt survey.Prompt = &survey.Input{
 		Message: fmt.Sprintf("%s.%s", blocks.VarBlock, v.Id),
 		Default: "",
 		Help:    v.Desc,
-	}, &resp)
+	}
+	if v.Sensitive {
+		prompt = &survey.Password{
+			Message: fmt.Sprintf("%s.%s", blocks.VarBlock, v.Id),
+			Help:    v.Desc,
+		}
+	}
+	err := survey.AskOne(prompt, &resp)
 	if err != nil || resp == "" {
 		return cty.NilVal, diags.Append(&hcl.Diagnostic{
 			Severity: hcl.DiagError,
 			Summary:  "No value for required variable",
-			Detail:   fmt.Sprintf("The root module input variable \"%s\" is not set, and has no default value. Use a -var, -var-file or a TOGOMAK_VAR_%s command line argument to provide a value for this variable.", v.Id, v.Id),
+			Detail:   fmt.Sprintf("The root module input variable \"%s\" is not set, and has no default value. Use --var, --var-file or the TOGOMAK_VAR_%s environment variable to provide a value for this variable.", v.Id, v.Id),
 		})
 	} else {
-		return cty.StringVal(resp), diags
+		return v.parseRawValue(cty.StringVal(resp), "the prompt")
+	}
+}
+
+// given returns true when the value of the variable is given by the
+// TOGOMAK_VAR_<id> environment variable, a --var flag or a variable file
+func (v *Variable) given(conductor *Conductor) bool {
+	if os.Getenv(fmt.Sprintf("TOGOMAK_VAR_%s", v.Id)) != "" {
+		return true
+	}
+	for _, cliVariable := range conductor.Variables() {
+		if cliVariable.Id == v.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveGiven returns the value of the variable from conductor.Variables(),
+// either from the variable files when files is true, or from the --var flags
+// and the module block otherwise. It returns false when none of them sets it.
+func (v *Variable) resolveGiven(conductor *Conductor, files bool) (cty.Value, bool, hcl.Diagnostics) {
+	for _, cliVariable := range conductor.Variables() {
+		if cliVariable.Id != v.Id || (cliVariable.file != "") != files {
+			continue
+		}
+		conductor.Eval().Mutex().RLock()
+		b, d := cliVariable.Value.Value(conductor.Eval().Context())
+		conductor.Eval().Mutex().RUnlock()
+		if !d.HasErrors() && cliVariable.raw {
+			b, d = v.parseRawVariable(cliVariable, b)
+		}
+		// variables which are not nullable use their default value instead of null
+		if d.HasErrors() || !b.IsNull() || v.nullable() {
+			return b, true, d
+		}
 	}
+	return cty.NilVal, false, nil
 }
 
 func (v *Variable) resolveVarTypedWithDefaults(conductor *Conductor) (cty.Value, hcl.Diagnostics) {
 	var diags hcl.Diagnostics
 	value, d := v.resolveVar(conductor)
 	diags = diags.Extend(d)
-
-	// the user specified type, we will check
-	// if this type is null, or undefined, and include
-	// a fallback type
-	conductor.Eval().Mutex().RLock()
-	uType, d := v.Ty.Value(conductor.Eval().Context())
-	conductor.Eval().Mutex().RUnlock()
-
-	ty := cty.DynamicPseudoType
-	var def *typeexpr.Defaults
-
-	if !d.HasErrors() && !uType.IsNull() {
-		ty, def, d = typeexpr.TypeConstraintWithDefaults(v.Ty)
-		diags = diags.Extend(d)
+	if diags.HasErrors() {
+		return cty.NilVal, diags
 	}
 
+	// the user specified type, or any type when the variable has no type
+	ty, def, typed, d := v.typeConstraint()
+	diags = diags.Extend(d)
+
 	// we will first apply the default type constraints
 	// only if the default is not nil and the value inferred from the command line or the
 	// environment variable is not null
@@ -92,11 +139,27 @@ func (v *Variable) resolveVarTypedWithDefaults(conductor *Conductor) (cty.Value,
 
 	value, err := convert.Convert(value, ty)
 	if err != nil {
-		diags = diags.Append(&hcl.Diagnostic{
+		diag := &hcl.Diagnostic{
 			Severity: hcl.DiagError,
 			Summary:  "Invalid variable value",
 			Detail:   fmt.Sprintf("The value of variable %s is invalid: %s", v.Id, err),
-		})
+		}
+		if typed {
+			diag.Detail = fmt.Sprintf("The value of variable %s is not suitable for its type %s: %s.", v.Id, typeexpr.TypeString(ty), err)
+			diag.Subject = v.Ty.Range().Ptr()
+		}
+		return cty.NilVal, diags.Append(diag)
+	}
+
+	if v.Sensitive {
+		value = value.Mark(marks.Sensitive)
+	}
+	// the secrets are recorded before the value is validated, so that they are
+	// masked in the diagnostics of the validation rules
+	recordSensitiveValues(value)
+
+	diags = diags.Extend(v.validate(conductor, value))
+	if diags.HasErrors() {
 		return cty.NilVal, diags
 	}
 	return value, diags
@@ -10,6 +10,31 @@ type Variable struct {
 	Value     hcl.Expression `hcl:"value,optional" json:"value"`
 	Default   hcl.Expression `hcl:"default,optional" json:"default"`
 	Ty        hcl.Expression `hcl:"type,optional" json:"type"`
+
+	// Sensitive marks the value of the variable as sensitive, it is masked in the logs
+	Sensitive bool `hcl:"sensitive,optional" json:"sensitive"`
+
+	// Nullable is true when the variable accepts null, which is the default. A null
+	// value is replaced with the default value of variables which are not nullable.
+	Nullable *bool `hcl:"nullable,optional" json:"nullable"`
+
+	Validations []VariableValidation `hcl:"validation,block" json:"validation"`
+
+	// raw is true when Value is a string from the command line, or a value passed
+	// by a module block, a string which is parsed according to the type of the variable
+	raw bool
+
+	// file is the variable file Value is read from, when it is read from one
+	file string
+}
+
+// VariableValidation is a rule which the value of a variable must satisfy
+type VariableValidation struct {
+	// Condition is an expression of the variable, which is true when its value is valid
+	Condition hcl.Expression `hcl:"condition" json:"condition"`
+
+	// ErrorMessage is the message of the diagnostic when Condition is false
+	ErrorMessage hcl.Expression `hcl:"error_message" json:"error_message"`
 }
 
 type Variables []*Variable
@@ -0,0 +1,80 @@
+package ci
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// nullable returns true if the variable accepts null values, which is the
+// default when nullable is not set
+func (v *Variable) nullable() bool {
+	return v.Nullable == nil || *v.Nullable
+}
+
+// validate evaluates the validation rules of the variable against value. The
+// conditions and error messages can only refer to the variable itself, as
+// var.<id>.
+func (v *Variable) validate(conductor *Conductor, value cty.Value) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if len(v.Validations) == 0 {
+		return diags
+	}
+
+	evalCtx := conductor.Eval().Context().NewChild()
+	evalCtx.Variables = map[string]cty.Value{
+		blocks.VarBlock: cty.ObjectVal(map[string]cty.Value{v.Id: value}),
+	}
+
+	for _, rule := range v.Validations {
+		conductor.Eval().Mutex().RLock()
+		result, d := rule.Condition.Value(evalCtx)
+		conductor.Eval().Mutex().RUnlock()
+		if d.HasErrors() {
+			diags = diags.Extend(d)
+			continue
+		}
+		result, err := convert.Convert(unmarkSensitive(result), cty.Bool)
+		if err != nil || result.IsNull() || !result.IsKnown() {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid variable validation result",
+				Detail:   fmt.Sprintf("The condition of a validation rule of variable %s must be true or false.", v.Id),
+				Subject:  rule.Condition.Range().Ptr(),
+			})
+			continue
+		}
+		if result.True() {
+			continue
+		}
+
+		conductor.Eval().Mutex().RLock()
+		message, d := rule.ErrorMessage.Value(evalCtx)
+		conductor.Eval().Mutex().RUnlock()
+		if !d.HasErrors() && message.ContainsMarked() {
+			// like the value, an error message which refers to a sensitive value is not shown
+			message = cty.StringVal(fmt.Sprintf("The value of variable %s is invalid. The error message of the validation rule is not shown, since it refers to a sensitive value.", v.Id))
+		}
+		message, err = convert.Convert(message, cty.String)
+		if d.HasErrors() || err != nil || message.IsNull() || !message.IsKnown() {
+			diags = diags.Extend(d)
+			message = cty.StringVal(fmt.Sprintf("The value of variable %s is invalid.", v.Id))
+		}
+
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid value for variable",
+			Detail:   fmt.Sprintf("%s\n\nThis was checked by the validation rule at %s.", message.AsString(), rule.Condition.Range().String()),
+			Subject:  rule.Condition.Range().Ptr(),
+		}
+		// the values of sensitive variables are not shown in the diagnostic
+		if !v.Sensitive && !value.ContainsMarked() {
+			diag.Expression = rule.Condition
+			diag.EvalContext = evalCtx
+		}
+		diags = diags.Append(diag)
+	}
+	return diags
+}
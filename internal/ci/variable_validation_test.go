@@ -0,0 +1,111 @@
+package ci
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/srevinsaju/togomak/v1/internal/third-party/hashicorp/terraform/lang/marks"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+	"testing"
+	"time"
+)
+
+func parseTestExpression(t *testing.T, src string) hcl.Expression {
+	expr, diags := hclsyntax.ParseExpression([]byte(src), "togomak.hcl", hcl.InitialPos)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	return expr
+}
+
+func TestVariable_Validate(t *testing.T) {
+	conductor := newRunStateTestConductor(t, t.TempDir(), time.Now())
+	v := &Variable{
+		Id:      "replicas",
+		Ty:      parseTestExpression(t, "number"),
+		Default: parseTestExpression(t, "3"),
+		Validations: []VariableValidation{{
+			Condition:    parseTestExpression(t, "var.replicas > 0"),
+			ErrorMessage: parseTestExpression(t, `"replicas must be positive, got ${var.replicas}"`),
+		}},
+	}
+
+	value, diags := v.resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors())
+	assert.True(t, value.Equals(cty.NumberIntVal(3)).True())
+
+	diags = v.validate(conductor, cty.NumberIntVal(-1))
+	assert.True(t, diags.HasErrors())
+	assert.Equal(t, "Invalid value for variable", diags[0].Summary)
+	assert.Contains(t, diags[0].Detail, "replicas must be positive, got -1")
+	assert.Equal(t, "togomak.hcl", diags[0].Subject.Filename)
+
+	v.Validations[0].Condition = parseTestExpression(t, `"yes"`)
+	diags = v.validate(conductor, cty.NumberIntVal(1))
+	assert.True(t, diags.HasErrors())
+	assert.Equal(t, "Invalid variable validation result", diags[0].Summary)
+}
+
+func TestVariable_TypeConstraint(t *testing.T) {
+	conductor := newRunStateTestConductor(t, t.TempDir(), time.Now())
+	v := &Variable{
+		Id:      "port",
+		Ty:      parseTestExpression(t, "number"),
+		Default: parseTestExpression(t, `"http"`),
+	}
+	_, diags := v.resolveVarTypedWithDefaults(conductor)
+	assert.True(t, diags.HasErrors())
+
+	v.Default = parseTestExpression(t, `"8080"`)
+	value, diags := v.resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors())
+	assert.True(t, value.Equals(cty.NumberIntVal(8080)).True())
+}
+
+func TestVariable_SensitiveAndNullable(t *testing.T) {
+	notNullable := false
+	v := &Variable{
+		Id:        "password",
+		Ty:        parseTestExpression(t, "string"),
+		Default:   parseTestExpression(t, `"variable-default-password"`),
+		Sensitive: true,
+		Nullable:  &notNullable,
+	}
+	conductor := newRunStateTestConductor(t, t.TempDir(), time.Now())
+	conductor.variables = Variables{{Id: "password", Value: hcl.StaticExpr(cty.NullVal(cty.String), hcl.Range{})}}
+
+	value, diags := v.resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors())
+	assert.True(t, value.HasMark(marks.Sensitive))
+	unmarked, _ := value.Unmark()
+	assert.Equal(t, cty.StringVal("variable-default-password"), unmarked)
+
+	v.Nullable = nil
+	value, diags = v.resolveVarTypedWithDefaults(conductor)
+	assert.False(t, diags.HasErrors())
+	unmarked, _ = value.Unmark()
+	assert.True(t, unmarked.IsNull())
+}
+
+func TestVariable_ValidateSensitive(t *testing.T) {
+	conductor := newRunStateTestConductor(t, t.TempDir(), time.Now())
+	v := &Variable{
+		Id:        "token",
+		Ty:        parseTestExpression(t, "string"),
+		Default:   parseTestExpression(t, `"validate-sensitive-token"`),
+		Sensitive: true,
+		Validations: []VariableValidation{{
+			Condition:    parseTestExpression(t, `var.token == "expected"`),
+			ErrorMessage: parseTestExpression(t, `"bad token ${var.token}"`),
+		}},
+	}
+
+	_, diags := v.resolveVarTypedWithDefaults(conductor)
+	assert.True(t, diags.HasErrors())
+	assert.Equal(t, "Invalid value for variable", diags[0].Summary)
+	assert.NotContains(t, diags[0].Detail, "validate-sensitive-token")
+	assert.Contains(t, diags[0].Detail, "refers to a sensitive value")
+	assert.Nil(t, diags[0].EvalContext)
+
+	// the secret is recorded before it is validated
+	assert.Equal(t, logging.Redacted, logging.Secrets().Redact("validate-sensitive-token"))
+}
@@ -0,0 +1,136 @@
+package ci
+
+import (
+	"github.com/bmatcuk/doublestar"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"github.com/srevinsaju/togomak/v1/internal/x"
+	"path/filepath"
+	"strings"
+)
+
+// WatchTarget is a stage which is run again by togomak watch, when a file
+// matching one of its watch globs changes
+type WatchTarget struct {
+	// Id is the identifier of the stage, as in stage.<id>
+	Id string
+
+	// Dir is the absolute path of the directory the globs are relative to
+	Dir string
+
+	// Patterns are the globs of the files the stage watches
+	Patterns []string
+
+	// Ignore are the globs of the outputs of the stage, changes to them
+	// do not run the stage again
+	Ignore []string
+}
+
+// WatchTargets evaluates the watch globs of the stages of pipe. The stages which
+// do not watch any file are not returned.
+func (pipe *Pipeline) WatchTargets(conductor *Conductor) ([]WatchTarget, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	var targets []WatchTarget
+	evalCtx := conductor.Eval().Context()
+	for i := range pipe.Stages {
+		s := &pipe.Stages[i]
+		patterns, d := s.globs(conductor, evalCtx, s.Watch)
+		diags = diags.Extend(d)
+		if d.HasErrors() || len(patterns) == 0 {
+			continue
+		}
+		ignore, d := s.globs(conductor, evalCtx, s.Outputs)
+		diags = diags.Extend(d)
+
+		dir := conductor.Config.Paths.Cwd
+		if s.Dir != nil {
+			conductor.Eval().Mutex().RLock()
+			v, d := s.Dir.Value(evalCtx)
+			conductor.Eval().Mutex().RUnlock()
+			diags = diags.Extend(d)
+			if d.HasErrors() {
+				continue
+			}
+			if !v.IsNull() && v.AsString() != "" {
+				dir = v.AsString()
+			}
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(conductor.Config.Paths.Cwd, dir)
+		}
+
+		targets = append(targets, WatchTarget{
+			Id:       x.RenderBlock(blocks.StageBlock, s.Id),
+			Dir:      dir,
+			Patterns: patterns,
+			Ignore:   ignore,
+		})
+	}
+	return targets, diags
+}
+
+// Roots returns the directories which need to be watched for the files matching
+// the globs of the target, which are the directories before the first wildcard
+func (t WatchTarget) Roots() []string {
+	var roots []string
+	for _, pattern := range t.Patterns {
+		parts := strings.Split(filepath.ToSlash(pattern), "/")
+		static := parts[:len(parts)-1]
+		for i, part := range static {
+			if strings.ContainsAny(part, "*?[{\\") {
+				static = static[:i]
+				break
+			}
+		}
+		roots = append(roots, filepath.Join(append([]string{t.Dir}, static...)...))
+	}
+	return roots
+}
+
+// Match returns true if path matches one of the globs of the target, and is
+// neither one of its outputs nor in the togomak build directory
+func (t WatchTarget) Match(path string) bool {
+	rel, err := filepath.Rel(t.Dir, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == meta.BuildDirPrefix || strings.HasPrefix(rel, meta.BuildDirPrefix+"/") {
+		return false
+	}
+	for _, pattern := range t.Ignore {
+		if ok, _ := doublestar.Match(pattern, rel); ok {
+			return false
+		}
+	}
+	for _, pattern := range t.Patterns {
+		if ok, _ := doublestar.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchAffected returns the identifiers of the stages watching one of the files
+// in paths, along with the stages and modules which depend on them
+func WatchAffected(targets []WatchTarget, depGraph *depgraph.Graph, paths []string) map[string]bool {
+	affected := make(map[string]bool)
+	for _, target := range targets {
+		for _, path := range paths {
+			if !target.Match(path) {
+				continue
+			}
+			affected[target.Id] = true
+			for dependent := range depGraph.Dependents(target.Id) {
+				blockType, _, _ := strings.Cut(dependent, ".")
+				if blockType == blocks.StageBlock || blockType == blocks.ModuleBlock {
+					affected[dependent] = true
+				}
+			}
+			break
+		}
+	}
+	return affected
+}
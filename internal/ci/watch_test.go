@@ -0,0 +1,88 @@
+package ci
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchTarget_Match(t *testing.T) {
+	dir := t.TempDir()
+	target := WatchTarget{
+		Id:       "stage.build",
+		Dir:      dir,
+		Patterns: []string{"src/**/*.go", "go.mod"},
+		Ignore:   []string{"src/gen/*.go"},
+	}
+
+	assert.True(t, target.Match(filepath.Join(dir, "src", "main.go")))
+	assert.True(t, target.Match(filepath.Join(dir, "src", "cmd", "main.go")))
+	assert.True(t, target.Match(filepath.Join(dir, "go.mod")))
+	assert.False(t, target.Match(filepath.Join(dir, "src", "README.md")))
+	assert.False(t, target.Match(filepath.Join(dir, "src", "gen", "types.go")))
+	assert.False(t, target.Match(filepath.Join(filepath.Dir(dir), "go.mod")))
+
+	target.Patterns = []string{"**"}
+	assert.False(t, target.Match(filepath.Join(dir, meta.BuildDirPrefix, "state.json")))
+}
+
+func TestWatchTarget_Roots(t *testing.T) {
+	target := WatchTarget{Dir: "/src", Patterns: []string{"*.go", "cmd/**/*.go", "web/static/app.js", "a/{b,c}/*.css"}}
+	assert.Equal(t, []string{"/src", "/src/cmd", "/src/web/static", "/src/a"}, target.Roots())
+}
+
+func TestWatchAffected(t *testing.T) {
+	g := depgraph.New()
+	assert.NoError(t, g.DependOn("stage.build", meta.RootStage))
+	assert.NoError(t, g.DependOn("stage.test", "stage.build"))
+	assert.NoError(t, g.DependOn("module.deploy", "stage.test"))
+	assert.NoError(t, g.DependOn("local.version", "stage.build"))
+	assert.NoError(t, g.DependOn("stage.docs", meta.RootStage))
+
+	targets := []WatchTarget{
+		{Id: "stage.build", Dir: "/src", Patterns: []string{"*.go"}},
+		{Id: "stage.docs", Dir: "/src", Patterns: []string{"docs/*.md"}},
+	}
+	assert.Equal(t, map[string]bool{"stage.build": true, "stage.test": true, "module.deploy": true},
+		WatchAffected(targets, g, []string{"/src/main.go"}))
+	assert.Equal(t, map[string]bool{"stage.docs": true},
+		WatchAffected(targets, g, []string{"/src/docs/index.md", "/src/README.md"}))
+	assert.Empty(t, WatchAffected(targets, g, []string{"/src/README.md"}))
+}
+
+func TestPipeline_WatchTargets(t *testing.T) {
+	dir := t.TempDir()
+	conductor := newRunStateTestConductor(t, dir, time.Now())
+	globs := func(patterns ...string) hcl.Expression {
+		var values []cty.Value
+		for _, pattern := range patterns {
+			values = append(values, cty.StringVal(pattern))
+		}
+		return hcl.StaticExpr(cty.ListVal(values), hcl.Range{})
+	}
+	pipe := &Pipeline{
+		Stages: Stages{
+			{Id: "build", CoreStage: CoreStage{
+				Watch:   globs("*.go"),
+				Outputs: globs("bin/*"),
+			}},
+			{Id: "web", CoreStage: CoreStage{
+				Watch: globs("src/*.ts"),
+				Dir:   hcl.StaticExpr(cty.StringVal("web"), hcl.Range{}),
+			}},
+			{Id: "lint"},
+		},
+	}
+
+	targets, diags := pipe.WatchTargets(conductor)
+	assert.False(t, diags.HasErrors())
+	assert.Equal(t, []WatchTarget{
+		{Id: "stage.build", Dir: dir, Patterns: []string{"*.go"}, Ignore: []string{"bin/*"}},
+		{Id: "stage.web", Dir: filepath.Join(dir, "web"), Patterns: []string{"src/*.ts"}},
+	}, targets)
+}
@@ -0,0 +1,49 @@
+package dg
+
+import "github.com/hashicorp/hcl/v2"
+
+// JSONDiagnostic is the representation of a hcl.Diagnostic in machine-readable reports
+type JSONDiagnostic struct {
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	Detail   string `json:"detail,omitempty"`
+
+	// Range is the part of the configuration the diagnostic refers to, if any
+	Range *JSONRange `json:"range,omitempty"`
+}
+
+type JSONRange struct {
+	Filename string  `json:"filename"`
+	Start    JSONPos `json:"start"`
+	End      JSONPos `json:"end"`
+}
+
+type JSONPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Byte   int `json:"byte"`
+}
+
+// JSON converts diags to their machine-readable representation
+func JSON(diags hcl.Diagnostics) []JSONDiagnostic {
+	result := make([]JSONDiagnostic, 0, len(diags))
+	for _, diag := range diags {
+		d := JSONDiagnostic{
+			Severity: "error",
+			Summary:  diag.Summary,
+			Detail:   diag.Detail,
+		}
+		if diag.Severity == hcl.DiagWarning {
+			d.Severity = "warning"
+		}
+		if diag.Subject != nil {
+			d.Range = &JSONRange{
+				Filename: diag.Subject.Filename,
+				Start:    JSONPos{Line: diag.Subject.Start.Line, Column: diag.Subject.Start.Column, Byte: diag.Subject.Start.Byte},
+				End:      JSONPos{Line: diag.Subject.End.Line, Column: diag.Subject.End.Column, Byte: diag.Subject.End.Byte},
+			}
+		}
+		result = append(result, d)
+	}
+	return result
+}
@@ -0,0 +1,28 @@
+package dg
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	diags := hcl.Diagnostics{
+		{
+			Severity: hcl.DiagError,
+			Summary:  "invalid run_when",
+			Detail:   "run_when must be one of on_success, on_failure or always",
+			Subject:  &hcl.Range{Filename: "togomak.hcl", Start: hcl.Pos{Line: 4, Column: 3, Byte: 40}, End: hcl.Pos{Line: 4, Column: 20, Byte: 57}},
+		},
+		{Severity: hcl.DiagWarning, Summary: "stage failed"},
+	}
+
+	result := JSON(diags)
+	assert.Len(t, result, 2)
+	assert.Equal(t, "error", result[0].Severity)
+	assert.Equal(t, "togomak.hcl", result[0].Range.Filename)
+	assert.Equal(t, JSONPos{Line: 4, Column: 20, Byte: 57}, result[0].Range.End)
+	assert.Equal(t, "warning", result[1].Severity)
+	assert.Nil(t, result[1].Range)
+	assert.NotNil(t, JSON(nil))
+}
@@ -114,3 +114,14 @@ func (d *Diagnostics) Safe() *SafeDiagnostics {
 func (d *Diagnostics) Unsafe() *Diagnostics {
 	return d
 }
+
+// Warnings returns a copy of diags, with all the errors downgraded to warnings
+func Warnings(diags hcl.Diagnostics) hcl.Diagnostics {
+	var warnings hcl.Diagnostics
+	for _, diag := range diags {
+		warning := *diag
+		warning.Severity = hcl.DiagWarning
+		warnings = append(warnings, &warning)
+	}
+	return warnings
+}
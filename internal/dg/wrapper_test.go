@@ -100,3 +100,20 @@ func TestSafeDiagnostics_Diagnostics(t *testing.T) {
 		t.Errorf("Diagnostics not working")
 	}
 }
+
+func TestWarnings(t *testing.T) {
+	diags := hcl.Diagnostics{
+		{Severity: hcl.DiagError, Summary: "failed"},
+		{Severity: hcl.DiagWarning, Summary: "deprecated"},
+	}
+	warnings := Warnings(diags)
+	if warnings.HasErrors() {
+		t.Errorf("Warnings should not return errors")
+	}
+	if len(warnings) != 2 || warnings[0].Summary != "failed" {
+		t.Errorf("Warnings should keep all the diagnostics")
+	}
+	if !diags.HasErrors() {
+		t.Errorf("Warnings should not modify the original diagnostics")
+	}
+}
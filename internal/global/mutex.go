@@ -7,4 +7,9 @@ var (
 	VariableBlockEvalContextMutex = sync.Mutex{}
 	MacroBlockEvalContextMutex    = sync.Mutex{}
 	LocalBlockEvalContextMutex    = sync.Mutex{}
+	ResultEvalContextMutex        = sync.Mutex{}
+
+	// OutputsFileMutex is held while the TOGOMAK_OUTPUTS file of the pipeline is
+	// read or written
+	OutputsFileMutex = sync.Mutex{}
 )
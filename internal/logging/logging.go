@@ -22,7 +22,16 @@ type Config struct {
 	JSON          bool
 	CorrelationID string
 
+	// Stderr writes the logs to stderr, for commands which print their output to stdout
+	Stderr bool
+
 	Sinks []Sink
+
+	// KeepRuns is the number of the latest runs whose logs are kept in
+	// .togomak/logs, with a file for each runnable. They are not written when it is 0.
+	// The state of the runs in .togomak/runs is pruned the same way, except for the
+	// latest run, which can always be resumed.
+	KeepRuns int
 }
 
 func ParseSinksFromCLI(ctx *cli.Context) []Sink {
@@ -53,6 +62,9 @@ func ParseSinksFromCLI(ctx *cli.Context) []Sink {
 func New(cfg Config) (*logrus.Logger, error) {
 	logger := logrus.New()
 	logger.SetOutput(os.Stdout)
+	if cfg.Stderr {
+		logger.SetOutput(os.Stderr)
+	}
 	logger.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp:    false,
 		DisableTimestamp: cfg.Child,
@@ -90,6 +102,9 @@ func New(cfg Config) (*logrus.Logger, error) {
 		logger.SetFormatter(&logrus.JSONFormatter{})
 	}
 
+	// the secrets are masked before the entries are formatted, or passed to the sinks
+	logger.AddHook(secrets)
+
 	for _, sink := range cfg.Sinks {
 		switch sink.Name {
 		case "file":
@@ -0,0 +1,141 @@
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Redacted replaces the sensitive values in the logs
+const Redacted = "(sensitive value)"
+
+// MinSecretLength is the length of the shortest secret which is masked. Shorter
+// secrets, such as the items of a sensitive list of flags, would mask every
+// occurrence of common words and characters in the logs.
+const MinSecretLength = 4
+
+// Redactor masks the sensitive values of a pipeline in the logs. It is a
+// logrus.Hook, which masks the message and the fields of each entry before it
+// is formatted or passed to the hooks added after it.
+type Redactor struct {
+	mu       sync.RWMutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+func NewRedactor() *Redactor {
+	return &Redactor{secrets: make(map[string]bool)}
+}
+
+// secrets is the Redactor shared by the loggers created by New
+var secrets = NewRedactor()
+
+// Secrets returns the Redactor shared by the loggers created by New, and by
+// the other outputs of togomak
+func Secrets() *Redactor {
+	return secrets
+}
+
+// Add records secret, so that it is masked from now on. Each line of a secret
+// spanning several lines is masked too, since the output of stages is logged
+// line by line. Blank secrets, and the secrets or lines shorter than
+// MinSecretLength, are ignored.
+func (r *Redactor) Add(secret string) {
+	values := []string{secret}
+	if strings.Contains(secret, "\n") {
+		values = append(values, strings.Split(secret, "\n")...)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, value := range values {
+		value = strings.TrimRight(value, "\r")
+		if strings.TrimSpace(value) == "" || utf8.RuneCountInString(value) < MinSecretLength || r.secrets[value] {
+			continue
+		}
+		r.secrets[value] = true
+		r.replacer = nil
+	}
+}
+
+// Redact returns s, with the secrets it contains masked
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	replacer := r.replacer
+	empty := len(r.secrets) == 0
+	r.mu.RUnlock()
+	if empty {
+		return s
+	}
+	if replacer == nil {
+		replacer = r.newReplacer()
+	}
+	return replacer.Replace(s)
+}
+
+// newReplacer creates the replacer of the secrets, the longest secrets are
+// replaced first so that a secret containing another one is masked entirely
+func (r *Redactor) newReplacer() *strings.Replacer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.replacer != nil {
+		return r.replacer
+	}
+	values := make([]string, 0, len(r.secrets))
+	for value := range r.secrets {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	oldnew := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		oldnew = append(oldnew, value, Redacted)
+	}
+	r.replacer = strings.NewReplacer(oldnew...)
+	return r.replacer
+}
+
+// Writer returns a writer which masks the secrets written to w. Secrets split
+// across several writes are not masked.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return redactWriter{redactor: r, w: w}
+}
+
+type redactWriter struct {
+	redactor *Redactor
+	w        io.Writer
+}
+
+func (w redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.redactor.Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Levels implements logrus.Hook
+func (r *Redactor) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook, it masks the secrets in the message and the
+// string fields of entry
+func (r *Redactor) Fire(entry *logrus.Entry) error {
+	entry.Message = r.Redact(entry.Message)
+	for k, v := range entry.Data {
+		switch v := v.(type) {
+		case string:
+			entry.Data[k] = r.Redact(v)
+		case error:
+			entry.Data[k] = r.Redact(v.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	r := NewRedactor()
+	assert.Equal(t, "token is hunter2", r.Redact("token is hunter2"))
+
+	r.Add("hunter2")
+	r.Add("hunter2-admin")
+	r.Add("  ")
+	assert.Equal(t, "token is (sensitive value)", r.Redact("token is hunter2"))
+	assert.Equal(t, "user (sensitive value), token (sensitive value)", r.Redact("user hunter2-admin, token hunter2"))
+	assert.Equal(t, "  ", r.Redact("  "))
+
+	r.Add("-----BEGIN KEY-----\r\nabcdef\r\n-----END KEY-----")
+	assert.Equal(t, "key (sensitive value)", r.Redact("key abcdef"))
+}
+
+func TestRedactor_Fire(t *testing.T) {
+	r := NewRedactor()
+	r.Add("hunter2")
+
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(r)
+	logger.WithField("token", "hunter2").WithError(errors.New("invalid token hunter2")).Info("logging in with hunter2")
+
+	assert.NotContains(t, out.String(), "hunter2")
+	assert.Contains(t, out.String(), `"msg":"logging in with (sensitive value)"`)
+	assert.Contains(t, out.String(), `"token":"(sensitive value)"`)
+	assert.Contains(t, out.String(), `"error":"invalid token (sensitive value)"`)
+}
+
+func TestRedactor_Writer(t *testing.T) {
+	r := NewRedactor()
+	r.Add("hunter2")
+
+	var out bytes.Buffer
+	n, err := r.Writer(&out).Write([]byte("export TOKEN=hunter2\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, len("export TOKEN=hunter2\n"), n)
+	assert.Equal(t, "export TOKEN=(sensitive value)\n", out.String())
+}
+
+func TestRedactor_ShortSecrets(t *testing.T) {
+	r := NewRedactor()
+	r.Add("a")
+	r.Add("b")
+	r.Add("abc")
+	r.Add("abcd")
+	r.Add("ab\nlong-line")
+	assert.Equal(t, "a b abc (sensitive value) ab (sensitive value)", r.Redact("a b abc abcd ab long-line"))
+}
@@ -12,6 +12,14 @@ const (
 	ConfigFileName = "togomak.hcl"
 	BuildDirPrefix = ".togomak"
 
+	// VarFileSuffix and VarFileJSONSuffix are the suffixes of the files with the values of
+	// variables. The files ending with AutoVarFileSuffix or AutoVarFileJSONSuffix next to
+	// the pipeline are loaded automatically.
+	VarFileSuffix         = ".vars.hcl"
+	VarFileJSONSuffix     = ".vars.json"
+	AutoVarFileSuffix     = ".auto" + VarFileSuffix
+	AutoVarFileJSONSuffix = ".auto" + VarFileJSONSuffix
+
 	EnvVarPrefix = "TOGOMAK__"
 
 	OutputEnvFile = ".togomak.env"
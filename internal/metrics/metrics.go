@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/urfave/cli/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// namespace is prepended to the names of all the metrics
+const namespace = "togomak"
+
+// buckets are the upper bounds of the histograms of durations, in seconds
+var buckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// Config configures where the Prometheus metrics of a run are written
+type Config struct {
+	// Textfile is the path of a file where the metrics are written once the run
+	// has finished, in the format of the textfile collector of node_exporter
+	Textfile string
+
+	// Listen is the address where the metrics are served on /metrics while the pipeline runs
+	Listen string
+}
+
+func ParseConfigFromCLI(ctx *cli.Context) Config {
+	textfile := ctx.String("metrics.textfile")
+	if textfile != "" {
+		// togomak changes its working directory to the pipeline's directory later
+		if abs, err := filepath.Abs(textfile); err == nil {
+			textfile = abs
+		}
+	}
+	return Config{
+		Textfile: textfile,
+		Listen:   ctx.String("metrics.listen"),
+	}
+}
+
+// Enabled returns true if the metrics are written or served
+func (c Config) Enabled() bool {
+	return c.Textfile != "" || c.Listen != ""
+}
+
+// collectors are the metrics of a run, which are shared by the root pipeline and its modules
+type collectors struct {
+	runs      *prometheus.CounterVec
+	duration  *prometheus.HistogramVec
+	retries   *prometheus.CounterVec
+	cacheHits *prometheus.CounterVec
+	queueWait *prometheus.HistogramVec
+
+	pipelineDuration prometheus.Gauge
+	pipelineSuccess  prometheus.Gauge
+	pipelineLastRun  prometheus.Gauge
+}
+
+// Metrics has the Prometheus metrics of the stages and modules of a run. A nil
+// Metrics records nothing, so that metrics do not need to be checked for when
+// they are disabled.
+type Metrics struct {
+	registry *prometheus.Registry
+	c        *collectors
+
+	// prefix is prepended to the identifiers of the runnables, for example module.build/
+	prefix string
+}
+
+// New creates the metrics of a run of the pipeline named pipeline, which is added
+// as a label to all the metrics, so that the textfiles of several pipelines can be
+// collected from the same machine
+func New(pipeline string) *Metrics {
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWith(prometheus.Labels{"pipeline": pipeline}, registry)
+	labels := []string{"stage", "phase"}
+	c := &collectors{
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "stage_runs_total",
+			Help:      "Number of stages and modules which have completed, by status",
+		}, append(labels, "status")),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "stage_duration_seconds",
+			Help:      "Time the stages and modules took to complete, including retries",
+			Buckets:   buckets,
+		}, append(labels, "status")),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "stage_retries_total",
+			Help:      "Number of times the stages and modules were retried",
+		}, labels),
+		cacheHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "stage_cache_hits_total",
+			Help:      "Number of stages which were skipped or restored from the artifact cache, since their inputs had not changed",
+		}, labels),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "stage_queue_wait_seconds",
+			Help:      "Time the stages waited for a slot, when the concurrency is limited",
+			Buckets:   buckets,
+		}, labels),
+		pipelineDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pipeline_duration_seconds",
+			Help:      "Time the last run of the pipeline took",
+		}),
+		pipelineSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pipeline_success",
+			Help:      "1 if the last run of the pipeline succeeded, 0 if it failed",
+		}),
+		pipelineLastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pipeline_last_run_timestamp_seconds",
+			Help:      "Time the last run of the pipeline finished, as a unix timestamp",
+		}),
+	}
+	registerer.MustRegister(c.runs, c.duration, c.retries, c.cacheHits, c.queueWait,
+		c.pipelineDuration, c.pipelineSuccess, c.pipelineLastRun)
+	return &Metrics{registry: registry, c: c}
+}
+
+// Module returns the metrics of the runnables of the module identified by id,
+// which are labelled with their identifier prefixed with the identifier of the module
+func (m *Metrics) Module(id string) *Metrics {
+	if m == nil {
+		return nil
+	}
+	return &Metrics{registry: m.registry, c: m.c, prefix: m.prefix + id + "/"}
+}
+
+// Runnable records the outcome of the stage or module identified by id, which
+// ran in phases, with attempts being the number of times it was run
+func (m *Metrics) Runnable(id string, phases []string, status runnable.StatusType, attempts int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	id = m.prefix + id
+	phase := strings.Join(phases, ",")
+	m.c.runs.WithLabelValues(id, phase, status.String()).Inc()
+	m.c.duration.WithLabelValues(id, phase, status.String()).Observe(duration.Seconds())
+	if attempts > 1 {
+		m.c.retries.WithLabelValues(id, phase).Add(float64(attempts - 1))
+	}
+	if status == runnable.StatusCached {
+		m.c.cacheHits.WithLabelValues(id, phase).Inc()
+	}
+}
+
+// QueueWait records the time the stage identified by id waited before it could run
+func (m *Metrics) QueueWait(id string, phases []string, wait time.Duration) {
+	if m == nil {
+		return
+	}
+	m.c.queueWait.WithLabelValues(m.prefix+id, strings.Join(phases, ",")).Observe(wait.Seconds())
+}
+
+// Finish records the outcome of the whole run
+func (m *Metrics) Finish(duration time.Duration, success bool) {
+	if m == nil {
+		return
+	}
+	m.c.pipelineDuration.Set(duration.Seconds())
+	m.c.pipelineLastRun.SetToCurrentTime()
+	if success {
+		m.c.pipelineSuccess.Set(1)
+	} else {
+		m.c.pipelineSuccess.Set(0)
+	}
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile writes the metrics to path, which is replaced atomically so that
+// the textfile collector of node_exporter never reads a partially written file
+func (m *Metrics) WriteTextfile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return prometheus.WriteToTextfile(path, m.registry)
+}
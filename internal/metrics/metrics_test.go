@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Nil(t *testing.T) {
+	var m *Metrics
+	m.Module("module.docs").Runnable("stage.build", []string{"default"}, runnable.StatusSuccess, 1, time.Second)
+	m.QueueWait("stage.build", []string{"default"}, time.Second)
+	m.Finish(time.Second, true)
+	assert.Nil(t, m.Module("module.docs"))
+}
+
+func TestMetrics_Runnable(t *testing.T) {
+	m := New("app")
+	m.Runnable("stage.build", []string{"default"}, runnable.StatusSuccess, 3, 2*time.Second)
+	m.Runnable("stage.lint", []string{"build", "test"}, runnable.StatusCached, 1, 0)
+	m.Module("module.docs").Runnable("stage.build", []string{"default"}, runnable.StatusFailure, 1, time.Second)
+	m.QueueWait("stage.build", []string{"default"}, time.Second)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.c.runs.WithLabelValues("stage.build", "default", "success")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.c.runs.WithLabelValues("module.docs/stage.build", "default", "failure")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.c.retries))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.c.retries.WithLabelValues("stage.build", "default")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.c.cacheHits.WithLabelValues("stage.lint", "build,test")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.c.queueWait))
+}
+
+func TestMetrics_WriteTextfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "textfile", "togomak.prom")
+	m := New("app")
+	m.Runnable("stage.build", []string{"default"}, runnable.StatusSuccess, 1, 2*time.Second)
+	m.Finish(3*time.Second, false)
+	assert.NoError(t, m.WriteTextfile(path))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `togomak_stage_runs_total{phase="default",pipeline="app",stage="stage.build",status="success"} 1`)
+	assert.Contains(t, string(content), `togomak_pipeline_duration_seconds{pipeline="app"} 3`)
+	assert.Contains(t, string(content), `togomak_pipeline_success{pipeline="app"} 0`)
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.True(t, strings.Contains(recorder.Body.String(), "togomak_stage_duration_seconds_bucket"))
+}
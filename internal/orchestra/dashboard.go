@@ -0,0 +1,649 @@
+package orchestra
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/ci"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/srevinsaju/togomak/v1/internal/ui"
+	"github.com/srevinsaju/togomak/v1/internal/x"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dashboardRefresh is how often the dashboard is drawn
+	dashboardRefresh = 100 * time.Millisecond
+
+	// dashboardLogLines is the number of lines of logs kept for each runnable
+	dashboardLogLines = 10000
+
+	// dashboardSummaryLines is the number of lines of logs printed for each
+	// runnable which failed, once the dashboard is closed
+	dashboardSummaryLines = 20
+
+	// dashboardGeneral identifies the logs which are not written by a runnable
+	dashboardGeneral = "togomak"
+)
+
+// dashboardScreen is the terminal the dashboard is drawn on, see ui.Screen
+type dashboardScreen interface {
+	IsTerminal() bool
+	Enter() error
+	Exit()
+	Size() (int, int)
+	Draw(lines []string)
+	Keys() <-chan ui.Key
+}
+
+// Dashboard shows the stages and the modules of a run on a terminal, with their
+// status and the logs of the selected runnable, instead of the logs of all the
+// runnables interleaved. The logs of the run are kept by the dashboard while
+// it is shown.
+type Dashboard struct {
+	conductor *ci.Conductor
+	tracker   *ci.Tracker
+	screen    dashboardScreen
+	started   time.Time
+
+	logger     *logrus.Logger
+	loggerOut  io.Writer
+	hooks      logrus.LevelHooks
+	diagWriter hcl.DiagnosticWriter
+	diags      bytes.Buffer
+
+	// output keeps what is written directly to os.Stdout and os.Stderr while
+	// the dashboard is shown, such as the progress of docker pulls, in the
+	// general logs, since it would be drawn over the dashboard
+	output         io.Writer
+	stdout, stderr *os.File
+	pipe           *os.File
+	redirected     chan struct{}
+
+	mu        sync.Mutex
+	logs      map[string][]string
+	order     map[string]int
+	selected  string
+	full      bool
+	scroll    int
+	message   string
+	cancelled bool
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// dashboardRow is a runnable shown by the dashboard
+type dashboardRow struct {
+	id       string
+	depth    int
+	status   runnable.StatusType
+	elapsed  time.Duration
+	attempts int
+	daemon   bool
+	reason   string
+}
+
+func NewDashboard(conductor *ci.Conductor) *Dashboard {
+	return &Dashboard{
+		conductor: conductor,
+		tracker:   ci.NewTracker(),
+		screen:    ui.NewScreen(os.Stdin, os.Stdout),
+		logs:      make(map[string][]string),
+		order:     make(map[string]int),
+		selected:  dashboardGeneral,
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// Start shows the dashboard, until Stop is called
+func (d *Dashboard) Start() error {
+	logger, ok := d.conductor.RootLogger.(*logrus.Logger)
+	if !ok {
+		return errors.New("the logs cannot be redirected to the dashboard")
+	}
+	if !d.screen.IsTerminal() {
+		return errors.New("the input or the output is not a terminal")
+	}
+	if err := d.screen.Enter(); err != nil {
+		return err
+	}
+	// the terminal is restored when togomak exits on a fatal error
+	logrus.RegisterExitHandler(d.screen.Exit)
+	d.started = time.Now()
+
+	// the logs are only shown by the dashboard
+	d.logger = logger
+	d.loggerOut = logger.Out
+	hooks := make(logrus.LevelHooks)
+	for level, levelHooks := range logger.Hooks {
+		hooks[level] = append(hooks[level], levelHooks...)
+	}
+	hooks.Add(d)
+	d.hooks = logger.ReplaceHooks(hooks)
+	logger.SetOutput(io.Discard)
+	if err := d.redirect(); err != nil {
+		logger.Warnf("the output written to the terminal will be drawn over the dashboard: %s", err)
+	}
+
+	// the diagnostics are written once the dashboard is closed
+	d.diagWriter = d.conductor.DiagWriter
+	d.conductor.Update(
+		ci.ConductorWithTracker(d.tracker),
+		ci.ConductorWithDiagWriter(hcl.NewDiagnosticTextWriter(&d.diags, d.conductor.Parser.Files(), 0, true)),
+	)
+
+	go d.loop()
+	return nil
+}
+
+// Stop closes the dashboard, and prints the summary of the run and its diagnostics.
+// It does nothing when the dashboard is nil.
+func (d *Dashboard) Stop() {
+	if d == nil {
+		return
+	}
+	close(d.done)
+	<-d.stopped
+	d.screen.Exit()
+	d.restore()
+
+	d.logger.ReplaceHooks(d.hooks)
+	d.logger.SetOutput(d.loggerOut)
+	d.conductor.Update(ci.ConductorWithTracker(nil), ci.ConductorWithDiagWriter(d.diagWriter))
+
+	d.printSummary()
+	logging.Secrets().Writer(os.Stdout).Write(d.diags.Bytes())
+}
+
+// redirect replaces os.Stdout and os.Stderr with a pipe, whose lines are
+// written to d.output
+func (d *Dashboard) redirect() error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	d.output = &dashboardWriter{d: d, key: dashboardGeneral}
+	d.stdout, d.stderr, d.pipe = os.Stdout, os.Stderr, w
+	d.redirected = make(chan struct{})
+	os.Stdout, os.Stderr = w, w
+	go func() {
+		defer close(d.redirected)
+		_, _ = io.Copy(d.output, r)
+		_ = r.Close()
+	}()
+	return nil
+}
+
+// restore restores os.Stdout and os.Stderr, once what was written to them
+// has been kept in the logs
+func (d *Dashboard) restore() {
+	if d.pipe == nil {
+		return
+	}
+	os.Stdout, os.Stderr = d.stdout, d.stderr
+	_ = d.pipe.Close()
+	<-d.redirected
+	d.pipe = nil
+}
+
+// dashboardWriter keeps the lines written to it in the logs of key. Only the
+// text after the last carriage return of a line is kept, as a terminal would
+// show it for progress bars.
+type dashboardWriter struct {
+	d       *Dashboard
+	key     string
+	partial []byte
+}
+
+func (w *dashboardWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	i := bytes.LastIndexByte(w.partial, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	var lines []string
+	for _, line := range strings.Split(string(w.partial[:i]), "\n") {
+		if j := strings.LastIndexByte(strings.TrimRight(line, "\r"), '\r'); j >= 0 {
+			line = line[j+1:]
+		}
+		lines = append(lines, strings.TrimRight(line, "\r"))
+	}
+	w.partial = append(w.partial[:0], w.partial[i+1:]...)
+	w.d.mu.Lock()
+	defer w.d.mu.Unlock()
+	w.d.appendLogs(w.key, lines...)
+	return len(p), nil
+}
+
+func (d *Dashboard) loop() {
+	defer close(d.stopped)
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	keys := d.screen.Keys()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+			d.press(key)
+		}
+		d.screen.Draw(d.render())
+	}
+}
+
+// Levels implements logrus.Hook
+func (d *Dashboard) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook, it keeps the logs of each runnable
+func (d *Dashboard) Fire(entry *logrus.Entry) error {
+	key := dashboardKey(entry.Data)
+	prefix := ""
+	switch entry.Level {
+	case logrus.WarnLevel:
+		prefix = ui.Yellow("warn ")
+	case logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel:
+		prefix = ui.Red("error ")
+	}
+	if key == dashboardGeneral {
+		if orchestra, ok := entry.Data["orchestra"]; ok {
+			prefix += ui.Grey(fmt.Sprintf("[%s] ", orchestra))
+		}
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(entry.Message, "\n"), "\n") {
+		lines = append(lines, prefix+line)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.appendLogs(key, lines...)
+	return nil
+}
+
+// appendLogs adds lines to the logs of key, d.mu must be held
+func (d *Dashboard) appendLogs(key string, lines ...string) {
+	logs := append(d.logs[key], lines...)
+	if len(logs) > dashboardLogLines {
+		logs = logs[len(logs)-dashboardLogLines:]
+	}
+	d.logs[key] = logs
+}
+
+// dashboardKey returns the identifier of the runnable which wrote a log entry
+// with fields, for example module.deploy/stage.build
+func dashboardKey(fields logrus.Fields) string {
+	var parts []string
+	if module, ok := fields["module"]; ok {
+		parts = append(parts, x.RenderBlock(blocks.ModuleBlock, fmt.Sprint(module)))
+	}
+	if stage, ok := fields["stage"]; ok {
+		parts = append(parts, x.RenderBlock(blocks.StageBlock, fmt.Sprint(stage)))
+	}
+	if len(parts) == 0 {
+		return dashboardGeneral
+	}
+	return strings.Join(parts, "/")
+}
+
+// rows returns the runnables to show, the stages and the modules are shown in the
+// order they started, followed by their instances and the runnables of modules
+func (d *Dashboard) rows() []dashboardRow {
+	results := d.conductor.Results()
+	rows := []dashboardRow{{id: dashboardGeneral}}
+	seen := map[string]bool{dashboardGeneral: true}
+	add := func(row dashboardRow) {
+		if !seen[row.id] {
+			seen[row.id] = true
+			rows = append(rows, row)
+		}
+	}
+
+	var top []dashboardRow
+	for _, tracked := range d.tracker.Started() {
+		if tracked.Block.Type() != blocks.StageBlock && tracked.Block.Type() != blocks.ModuleBlock {
+			continue
+		}
+		row := dashboardRow{
+			id:       ci.ResultId(tracked.Block),
+			status:   runnable.StatusRunning,
+			elapsed:  time.Since(tracked.Started),
+			attempts: tracked.Attempts,
+			daemon:   tracked.Daemon,
+		}
+		if result, ok := results.Get(row.id); ok && tracked.Done {
+			row.status, row.elapsed, row.reason = result.Status, result.Duration(), result.Reason
+		}
+		top = append(top, row)
+	}
+	for _, result := range results.List() {
+		blockType, _, _ := strings.Cut(result.Id, ".")
+		if (blockType != blocks.StageBlock && blockType != blocks.ModuleBlock) || strings.Contains(result.Id, "[") {
+			continue
+		}
+		top = append(top, dashboardRow{id: result.Id, status: result.Status, elapsed: result.Duration(), reason: result.Reason})
+	}
+
+	d.mu.Lock()
+	logged := make([]string, 0, len(d.logs))
+	for key := range d.logs {
+		logged = append(logged, key)
+	}
+	sort.Strings(logged)
+	// the runnables stay where they were first shown
+	for _, row := range top {
+		if _, ok := d.order[row.id]; !ok {
+			d.order[row.id] = len(d.order)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		return d.order[top[i].id] < d.order[top[j].id]
+	})
+	d.mu.Unlock()
+
+	for _, row := range top {
+		if seen[row.id] {
+			continue
+		}
+		add(row)
+
+		// the instances of for_each, and the runnables of modules
+		var children []dashboardRow
+		for _, result := range results.List() {
+			if strings.HasPrefix(result.Id, row.id+"[") {
+				children = append(children, dashboardRow{id: result.Id, status: result.Status, elapsed: result.Duration(), reason: result.Reason})
+			}
+		}
+		for _, module := range results.Modules() {
+			if module != row.id && !strings.HasPrefix(module, row.id+"[") {
+				continue
+			}
+			for _, result := range results.Module(module).List() {
+				blockType, _, _ := strings.Cut(result.Id, ".")
+				if blockType != blocks.StageBlock && blockType != blocks.ModuleBlock {
+					continue
+				}
+				children = append(children, dashboardRow{
+					id:      fmt.Sprintf("%s/%s", module, result.Id),
+					status:  result.Status,
+					elapsed: result.Duration(),
+					reason:  result.Reason,
+				})
+			}
+		}
+		for _, key := range logged {
+			if strings.HasPrefix(key, row.id+"[") || strings.HasPrefix(key, row.id+"/") {
+				children = append(children, dashboardRow{id: key, status: runnable.StatusRunning})
+			}
+		}
+		for _, child := range children {
+			child.depth = 1
+			add(child)
+		}
+	}
+	return rows
+}
+
+// press handles a key pressed on the dashboard
+func (d *Dashboard) press(key ui.Key) {
+	rows := d.rows()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if key == ui.KeyInterrupt || (!d.full && key == "c") {
+		d.cancel()
+		return
+	}
+	if d.full {
+		_, height := d.screen.Size()
+		switch key {
+		case ui.KeyUp, "k":
+			d.scroll++
+		case ui.KeyDown, "j":
+			d.scroll--
+		case ui.KeyPageUp:
+			d.scroll += height - 2
+		case ui.KeyPageDown:
+			d.scroll -= height - 2
+		case ui.KeyEscape, ui.KeyEnter, "q":
+			d.full = false
+		}
+		if d.scroll < 0 {
+			d.scroll = 0
+		}
+		return
+	}
+
+	selected := 0
+	for i, row := range rows {
+		if row.id == d.selected {
+			selected = i
+		}
+	}
+	switch key {
+	case ui.KeyUp, "k":
+		if selected > 0 {
+			selected--
+		}
+	case ui.KeyDown, "j":
+		if selected < len(rows)-1 {
+			selected++
+		}
+	case ui.KeyEnter, "l":
+		d.full, d.scroll = true, 0
+	case "t":
+		d.terminate(d.selected)
+	}
+	d.selected = rows[selected].id
+}
+
+// cancel cancels the run, as an interrupt would
+func (d *Dashboard) cancel() {
+	if d.cancelled {
+		d.message = "the run is being cancelled"
+		return
+	}
+	d.cancelled = true
+	d.message = "cancelling the run, stopping the running stages"
+	d.tracker.Interrupt()
+}
+
+// terminate stops the stage identified by id, which fails unless it is a daemon
+func (d *Dashboard) terminate(id string) {
+	for _, tracked := range d.tracker.Started() {
+		if ci.ResultId(tracked.Block) != id {
+			continue
+		}
+		if tracked.Block.Type() != blocks.StageBlock {
+			d.message = fmt.Sprintf("%s cannot be terminated, only stages can be terminated", id)
+			return
+		}
+		if tracked.Done {
+			d.message = fmt.Sprintf("%s is not running", id)
+			return
+		}
+		d.message = fmt.Sprintf("terminating %s", id)
+		go func(tracked ci.TrackedRunnable) {
+			diags := tracked.Block.Terminate(d.conductor, tracked.Daemon)
+			if diags.HasErrors() {
+				d.mu.Lock()
+				d.message = fmt.Sprintf("failed to terminate %s: %s", id, diags.Error())
+				d.mu.Unlock()
+			}
+		}(tracked)
+		return
+	}
+	d.message = fmt.Sprintf("%s cannot be terminated, only the stages of the pipeline can be terminated", id)
+}
+
+// render returns the lines of the dashboard
+func (d *Dashboard) render() []string {
+	rows := d.rows()
+	width, height := d.screen.Size()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	logs := d.logs[d.selected]
+	if d.full {
+		lines := []string{ui.Bold(fmt.Sprintf("logs of %s", d.selected))}
+		visible := height - 2
+		end := len(logs) - d.scroll
+		if end < visible {
+			end = visible
+		}
+		if end > len(logs) {
+			end = len(logs)
+		}
+		start := end - visible
+		if start < 0 {
+			start = 0
+		}
+		d.scroll = len(logs) - end
+		lines = append(lines, logs[start:end]...)
+		for len(lines) < height-1 {
+			lines = append(lines, "")
+		}
+		return append(lines, ui.Grey("↑/↓ scroll  pgup/pgdn page  esc back  ctrl+c cancel the run"))
+	}
+
+	var running, failed, done int
+	for _, row := range rows[1:] {
+		switch {
+		case row.status == runnable.StatusRunning:
+			running++
+		case row.status.Failed():
+			failed++
+		default:
+			done++
+		}
+	}
+	header := fmt.Sprintf("%s %s  %s  %s  %s  %s",
+		ui.Bold("togomak"),
+		ui.Grey(d.conductor.Config.Paths.Pipeline),
+		ui.Yellow(fmt.Sprintf("%d running", running)),
+		ui.Green(fmt.Sprintf("%d done", done)),
+		ui.Red(fmt.Sprintf("%d failed", failed)),
+		ui.Grey(time.Since(d.started).Round(time.Second).String()),
+	)
+	lines := []string{header, ""}
+
+	// the runnables take at most half of the screen, scrolled to the selected runnable
+	listHeight := len(rows)
+	if listHeight > (height-6)/2 {
+		listHeight = (height - 6) / 2
+	}
+	first := 0
+	for i, row := range rows {
+		if row.id == d.selected && i >= listHeight {
+			first = i - listHeight + 1
+		}
+	}
+	for i := first; i < len(rows) && i < first+listHeight; i++ {
+		lines = append(lines, dashboardLine(rows[i], rows[i].id == d.selected))
+	}
+
+	lines = append(lines, "", ui.Grey(fmt.Sprintf("── %s %s", d.selected, strings.Repeat("─", width))))
+	tail := height - len(lines) - 2
+	if tail < 0 {
+		tail = 0
+	}
+	if len(logs) > tail {
+		logs = logs[len(logs)-tail:]
+	}
+	lines = append(lines, logs...)
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+	return append(lines, d.message,
+		ui.Grey("↑/↓ select  enter logs  t terminate the stage  c cancel the run"))
+}
+
+// dashboardLine renders a runnable of the dashboard
+func dashboardLine(row dashboardRow, selected bool) string {
+	cursor := "  "
+	if selected {
+		cursor = ui.Bold("› ")
+	}
+	if row.id == dashboardGeneral {
+		return cursor + ui.Bold(row.id)
+	}
+
+	status := row.status.String()
+	if row.daemon && row.status == runnable.StatusRunning {
+		status = "daemon"
+	}
+	line := fmt.Sprintf("%s%s%s %s %s %s",
+		cursor,
+		strings.Repeat("  ", row.depth),
+		dashboardIcon(row),
+		ui.Pad(row.id, 32-2*row.depth),
+		ui.Pad(status, 10),
+		ui.Pad(row.elapsed.Round(100*time.Millisecond).String(), 8),
+	)
+	if row.attempts > 1 {
+		line += ui.Yellow(fmt.Sprintf(" attempt %d", row.attempts))
+	}
+	if row.reason != "" {
+		line += ui.Grey(" " + row.reason)
+	}
+	return line
+}
+
+func dashboardIcon(row dashboardRow) string {
+	switch {
+	case row.daemon && row.status == runnable.StatusRunning:
+		return ui.Blue("◆")
+	case row.status == runnable.StatusRunning:
+		return ui.Yellow("●")
+	case row.status.Failed():
+		return ui.Red("✘")
+	case row.status == runnable.StatusSkipped:
+		return ui.Grey("–")
+	case row.status == runnable.StatusCached:
+		return ui.Grey("✔")
+	default:
+		return ui.Green("✔")
+	}
+}
+
+// printSummary prints the status of each runnable, along with the last lines
+// of the logs of the runnables which failed
+func (d *Dashboard) printSummary() {
+	rows := d.rows()
+	for _, row := range rows[1:] {
+		fmt.Println(dashboardLine(row, false))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, row := range rows[1:] {
+		if !row.status.Failed() {
+			continue
+		}
+		logs := d.logs[row.id]
+		if len(logs) > dashboardSummaryLines {
+			logs = logs[len(logs)-dashboardSummaryLines:]
+		}
+		fmt.Println()
+		fmt.Println(ui.Red(fmt.Sprintf("── %s", row.id)))
+		for _, line := range logs {
+			fmt.Println(line)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package orchestra
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/srevinsaju/togomak/v1/internal/behavior"
+	"github.com/srevinsaju/togomak/v1/internal/ci"
+	"github.com/srevinsaju/togomak/v1/internal/path"
+	"github.com/srevinsaju/togomak/v1/internal/ui"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeScreen is a dashboardScreen of 80x24, whose keys are sent on keys
+type fakeScreen struct {
+	keys chan ui.Key
+
+	mu      sync.Mutex
+	lines   []string
+	entered bool
+	exited  bool
+}
+
+func newFakeScreen() *fakeScreen {
+	return &fakeScreen{keys: make(chan ui.Key)}
+}
+
+func (s *fakeScreen) IsTerminal() bool { return true }
+
+func (s *fakeScreen) Enter() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entered = true
+	return nil
+}
+
+func (s *fakeScreen) Exit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exited = true
+}
+
+func (s *fakeScreen) Size() (int, int) { return 80, 24 }
+
+func (s *fakeScreen) Draw(lines []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = lines
+}
+
+func (s *fakeScreen) Keys() <-chan ui.Key { return s.keys }
+
+func (s *fakeScreen) drawn() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lines
+}
+
+// newTestDashboard creates a dashboard of the pipeline in a temporary
+// directory, which is drawn on a fakeScreen
+func newTestDashboard(t *testing.T) (*Dashboard, *fakeScreen) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "togomak.hcl"), []byte("togomak {\n  version = 2\n}\n"), 0644))
+	owd, err := os.Getwd()
+	assert.NoError(t, err)
+	conductor := ci.NewConductor(ci.ConductorConfig{
+		Paths:    &path.Path{Pipeline: filepath.Join(dir, "togomak.hcl"), Cwd: dir, Owd: dir},
+		Behavior: &behavior.Behavior{Unattended: true},
+	})
+	assert.NoError(t, os.Chdir(owd))
+	t.Cleanup(conductor.Destroy)
+
+	d := NewDashboard(conductor)
+	screen := newFakeScreen()
+	d.screen = screen
+	return d, screen
+}
+
+func TestDashboardKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields logrus.Fields
+		key    string
+	}{
+		{"general", logrus.Fields{"orchestra": "run"}, dashboardGeneral},
+		{"stage", logrus.Fields{"stage": "build"}, "stage.build"},
+		{"instance", logrus.Fields{"stage": `build["linux"]`}, `stage.build["linux"]`},
+		{"module", logrus.Fields{"module": "deploy"}, "module.deploy"},
+		{"stage of a module", logrus.Fields{"module": "deploy", "stage": "build"}, "module.deploy/stage.build"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.key, dashboardKey(tt.fields))
+		})
+	}
+}
+
+func TestDashboard_Press(t *testing.T) {
+	d, _ := newTestDashboard(t)
+	build := &ci.Stage{Id: "build"}
+	d.tracker.AppendRunnable(build)
+	d.tracker.AppendRunnable(&ci.Stage{Id: "deploy"})
+	for i := 0; i < 30; i++ {
+		assert.NoError(t, d.Fire(&logrus.Entry{Level: logrus.InfoLevel, Data: logrus.Fields{"stage": "build"}, Message: fmt.Sprintf("line %d", i)}))
+	}
+
+	// the runnables are selected in the order they started
+	assert.Equal(t, dashboardGeneral, d.selected)
+	for _, step := range []struct {
+		key      ui.Key
+		selected string
+	}{
+		{ui.KeyDown, "stage.build"},
+		{"j", "stage.deploy"},
+		{ui.KeyDown, "stage.deploy"},
+		{ui.KeyUp, "stage.build"},
+	} {
+		d.press(step.key)
+		assert.Equal(t, step.selected, d.selected, step.key)
+	}
+
+	// the logs of the selected runnable are shown, scrolled from the end
+	d.press(ui.KeyEnter)
+	assert.True(t, d.full)
+	lines := d.render()
+	assert.Len(t, lines, 24)
+	assert.Equal(t, ui.Bold("logs of stage.build"), lines[0])
+	assert.Equal(t, "line 29", lines[22])
+
+	d.press(ui.KeyUp)
+	d.press(ui.KeyPageUp)
+	lines = d.render()
+	assert.Equal(t, "line 0", lines[1])
+	assert.Equal(t, 8, d.scroll)
+
+	// c only cancels the run from the list of the runnables
+	d.press("c")
+	assert.False(t, d.cancelled)
+	d.press(ui.KeyPageDown)
+	assert.Equal(t, 0, d.scroll)
+	d.press(ui.KeyEscape)
+	assert.False(t, d.full)
+	assert.Equal(t, "stage.build", d.selected)
+
+	d.press(ui.KeyUp)
+	d.press("t")
+	assert.Equal(t, "togomak cannot be terminated, only the stages of the pipeline can be terminated", d.message)
+	d.tracker.RunnableDone(build)
+	d.press(ui.KeyDown)
+	d.press("t")
+	assert.Equal(t, "stage.build is not running", d.message)
+
+	d.press("c")
+	assert.True(t, d.cancelled)
+	assert.Equal(t, "cancelling the run, stopping the running stages", d.message)
+	d.press(ui.KeyInterrupt)
+	assert.Equal(t, "the run is being cancelled", d.message)
+	assert.Contains(t, d.render()[22], "the run is being cancelled")
+}
+
+func TestDashboard_StartStop(t *testing.T) {
+	d, screen := newTestDashboard(t)
+	stdout, stderr := os.Stdout, os.Stderr
+	logger := d.conductor.RootLogger.(*logrus.Logger)
+	out := logger.Out
+
+	d.tracker.AppendRunnable(&ci.Stage{Id: "build"})
+
+	assert.NoError(t, d.Start())
+	assert.True(t, screen.entered)
+	assert.Equal(t, io.Discard, logger.Out)
+
+	// the logs, and what is written directly to the terminal, are kept by the dashboard
+	d.conductor.Logger().WithField("stage", "build").Info("building")
+	fmt.Fprintln(os.Stdout, "pulling 10%\rpulling 100%")
+	fmt.Fprintln(os.Stderr, "warning")
+	screen.keys <- ui.KeyDown
+	assert.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.selected == "stage.build" && strings.Contains(strings.Join(screen.drawn(), "\n"), "building")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	d.Stop()
+	assert.True(t, screen.exited)
+	assert.Equal(t, stdout, os.Stdout)
+	assert.Equal(t, stderr, os.Stderr)
+	assert.Equal(t, out, logger.Out)
+	assert.Equal(t, []string{"building"}, d.logs["stage.build"])
+	assert.Contains(t, d.logs[dashboardGeneral], "pulling 100%")
+	assert.Contains(t, d.logs[dashboardGeneral], "warning")
+}
@@ -0,0 +1,95 @@
+package orchestra
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/ci"
+	"github.com/srevinsaju/togomak/v1/internal/ui"
+	"os"
+	"strings"
+)
+
+// Explain prints each step of the decision to run the runnable identified by
+// runnableId, in format, which is one of text or json. Stages and modules are not run.
+func Explain(cfg ci.ConductorConfig, runnableId string, format string) error {
+	conductor := ci.NewConductor(cfg)
+	defer conductor.Destroy()
+	logger := conductor.Logger()
+
+	pipe, depGraph, diags := readGraph(conductor)
+	if diags.HasErrors() {
+		logger.Fatal(conductor.DiagWriter.WriteDiagnostics(diags))
+	}
+
+	explanation, d := ci.Explain(conductor, pipe, depGraph, runnableId)
+	diags = diags.Extend(d)
+	if len(diags) > 0 {
+		// the explanation is written to stdout, so that it can be parsed
+		writer := hcl.NewDiagnosticTextWriter(os.Stderr, conductor.Parser.Files(), 0, true)
+		writer.WriteDiagnostics(diags)
+	}
+	if explanation == nil {
+		return fmt.Errorf("failed to explain %s", runnableId)
+	}
+
+	switch format {
+	case "text", "":
+		printExplanation(explanation)
+	case "json":
+		content, err := explanation.JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+	default:
+		return fmt.Errorf("unsupported explain format %s, use text or json", format)
+	}
+	return nil
+}
+
+func printExplanation(e *ci.Explanation) {
+	if e.Module != nil {
+		printExplanation(e.Module)
+		fmt.Println()
+	}
+	fmt.Println(ui.Bold(e.Id))
+	filters := strings.Join(e.Filters, " ")
+	if filters == "" {
+		filters = "none"
+	}
+	fmt.Printf("%s %s\n", ui.Grey("filters"), filters)
+	for _, query := range e.Queries {
+		fmt.Printf("%s %s\n", ui.Grey("query"), query)
+	}
+
+	if len(e.Steps) > 0 {
+		fmt.Println()
+	}
+	for i, step := range e.Steps {
+		decision := explainDecision(step.Ok, step.Overridden)
+		fmt.Printf("%2d. %-10s %s %s\n", i+1, step.Step, step.Detail, ui.Grey("→ "+decision))
+	}
+
+	decision := explainDecision(e.Run, e.Overridden)
+	if e.Run {
+		decision = ui.Green(decision)
+	} else {
+		decision = ui.Grey(decision)
+	}
+	fmt.Printf("\n%s %s", ui.Bold("decision"), decision)
+	if e.Reason != "" {
+		fmt.Printf(", %s", e.Reason)
+	}
+	fmt.Println()
+}
+
+func explainDecision(ok bool, overridden bool) string {
+	decision := string(ci.PlanSkip)
+	if ok {
+		decision = string(ci.PlanRun)
+	}
+	if overridden {
+		decision += " (overridden)"
+	}
+	return decision
+}
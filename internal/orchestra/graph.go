@@ -0,0 +1,55 @@
+package orchestra
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/srevinsaju/togomak/v1/internal/ci"
+	"os"
+)
+
+// Graph prints the dependency graph of the pipeline in format, which is one of dot, mermaid or json
+func Graph(cfg ci.ConductorConfig, format string, opts ci.GraphOptions) error {
+	conductor := ci.NewConductor(cfg)
+	defer conductor.Destroy()
+	logger := conductor.Logger()
+
+	pipe, depGraph, diags := readGraph(conductor)
+	if diags.HasErrors() {
+		logger.Fatal(conductor.DiagWriter.WriteDiagnostics(diags))
+	}
+
+	graph, d := ci.NewPipelineGraph(conductor, pipe, depGraph, opts)
+	diags = diags.Extend(d)
+	if len(diags) > 0 {
+		// the graph is written to stdout, so that it can be piped to dot
+		writer := hcl.NewDiagnosticTextWriter(os.Stderr, conductor.Parser.Files(), 0, true)
+		writer.WriteDiagnostics(diags)
+	}
+
+	switch format {
+	case "dot", "":
+		fmt.Print(graph.DOT())
+	case "mermaid":
+		fmt.Print(graph.Mermaid())
+	case "json":
+		content, err := graph.JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+	default:
+		return fmt.Errorf("unsupported graph format %s, use dot, mermaid or json", format)
+	}
+	return nil
+}
+
+// readGraph reads the pipeline, expands its imports and its locals, and creates its dependency graph
+func readGraph(conductor *ci.Conductor) (*ci.Pipeline, *depgraph.Graph, hcl.Diagnostics) {
+	pipe, diags := ci.Read(conductor)
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+	pipe, depGraph, d := ci.ExpandGraph(conductor, pipe)
+	return pipe, depGraph, diags.Extend(d)
+}
@@ -0,0 +1,173 @@
+package orchestra
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/srevinsaju/togomak/v1/internal/ci"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/srevinsaju/togomak/v1/internal/ui"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// logsFollowInterval is how often the log files are read again by togomak logs --follow
+const logsFollowInterval = 500 * time.Millisecond
+
+// Logs prints the output of runnables in the run identified by id, or in the
+// latest run when id is empty. The output of every runnable of the run is printed
+// when runnables is empty, each preceded by the identifier of the runnable. With
+// follow, the output is printed as it is written, until the run finishes.
+func Logs(cfg ci.ConductorConfig, id string, runnables []string, follow bool) error {
+	conductor := ci.NewConductor(cfg)
+	defer conductor.Destroy()
+	if err := printLogs(conductor.Config.Paths.Cwd, id, runnables, follow); err != nil {
+		conductor.Logger().Fatal(err)
+	}
+	return nil
+}
+
+func printLogs(dir string, id string, runnables []string, follow bool) error {
+	if id == "" {
+		var err error
+		id, err = ci.LatestRunLogs(dir)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(ci.RunLogsDir(dir, id)); err != nil {
+		return fmt.Errorf("no logs of run %s: %w", id, err)
+	}
+
+	if follow {
+		return followLogs(dir, id, runnables)
+	}
+
+	available, err := ci.ListRunLogs(dir, id)
+	if err != nil {
+		return err
+	}
+	selected, missing := ci.SelectRunLogs(available, runnables)
+	if len(missing) > 0 {
+		return fmt.Errorf("no logs of %s in run %s, the runnables with logs are: %s", strings.Join(missing, ", "), id, strings.Join(available, ", "))
+	}
+
+	// the output of a single runnable is printed as it is, so that it can be piped
+	if len(runnables) == 1 && len(selected) == 1 {
+		return printLogFile(ci.RunLogPath(dir, id, selected[0]))
+	}
+	state, _ := ci.LoadRunState(dir, id)
+	for i, runnableId := range selected {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(logsHeader(state, runnableId))
+		if err := printLogFile(ci.RunLogPath(dir, id, runnableId)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printLogFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
+
+// logsHeader returns the line printed before the output of runnableId, with the
+// status of the runnable when it is known
+func logsHeader(state *ci.RunState, runnableId string) string {
+	header := ui.Bold(fmt.Sprintf("==> %s <==", runnableId))
+	if state == nil {
+		return header
+	}
+	runnableState, ok := state.Runnables[runnableId]
+	if !ok {
+		return header
+	}
+	status := runnableState.Status.String()
+	switch runnableState.Status {
+	case runnable.StatusSuccess, runnable.StatusCached:
+		status = ui.Green(status)
+	case runnable.StatusRunning, runnable.StatusSkipped:
+		status = ui.Grey(status)
+	default:
+		status = ui.Red(status)
+	}
+	return fmt.Sprintf("%s %s", header, status)
+}
+
+// followLogs prints the lines written to the log files of runnables in the run
+// identified by id, including the log files created later, until the run has
+// finished. Each line is preceded by the identifier of its runnable, unless the
+// logs of a single runnable are followed.
+func followLogs(dir string, id string, runnables []string) error {
+	offsets := make(map[string]int64)
+	partial := make(map[string][]byte)
+	prefixed := len(runnables) != 1
+
+	read := func() error {
+		available, err := ci.ListRunLogs(dir, id)
+		if err != nil {
+			return err
+		}
+		selected, _ := ci.SelectRunLogs(available, runnables)
+		prefixed = prefixed || len(selected) > 1
+		for _, runnableId := range selected {
+			content, err := readLogFrom(ci.RunLogPath(dir, id, runnableId), offsets[runnableId])
+			if err != nil {
+				return err
+			}
+			offsets[runnableId] += int64(len(content))
+			content = append(partial[runnableId], content...)
+
+			// only complete lines are printed, the rest is printed once its line is complete
+			end := bytes.LastIndexByte(content, '\n')
+			partial[runnableId] = append([]byte(nil), content[end+1:]...)
+			if end < 0 {
+				continue
+			}
+			for _, line := range strings.Split(string(content[:end]), "\n") {
+				if prefixed {
+					fmt.Printf("%s %s\n", ui.Grey(runnableId+" |"), line)
+				} else {
+					fmt.Println(line)
+				}
+			}
+		}
+		return nil
+	}
+
+	for {
+		// the run is checked before the logs are read, so that the lines written
+		// before it finished are printed
+		state, d := ci.LoadRunState(dir, id)
+		finished := !d.HasErrors() && !state.Finished.IsZero()
+		if err := read(); err != nil {
+			return err
+		}
+		if finished {
+			return nil
+		}
+		time.Sleep(logsFollowInterval)
+	}
+}
+
+// readLogFrom returns the content of the log file at path from offset
+func readLogFrom(path string, offset int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f)
+}
@@ -0,0 +1,68 @@
+package orchestra
+
+import (
+	"context"
+	"errors"
+	"github.com/srevinsaju/togomak/v1/internal/ci"
+	"github.com/srevinsaju/togomak/v1/internal/metrics"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// NewMetrics creates the metrics of the run, which is nil when the metrics are neither written nor served
+func NewMetrics(conductor *ci.Conductor) *metrics.Metrics {
+	if !conductor.Config.Metrics.Enabled() {
+		return nil
+	}
+	return metrics.New(filepath.Base(filepath.Dir(conductor.Config.Paths.Pipeline)))
+}
+
+// ServeMetrics serves the metrics of the run on /metrics while the pipeline runs,
+// when --metrics.listen is set. The returned function stops the server.
+func ServeMetrics(conductor *ci.Conductor) func() {
+	logger := conductor.Logger().WithField("orchestra", "metrics")
+	addr := conductor.Config.Metrics.Listen
+	if addr == "" || conductor.Metrics() == nil {
+		return func() {}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Warnf("metrics will not be served: %s", err)
+		return func() {}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", conductor.Metrics().Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warnf("failed to serve metrics: %s", err)
+		}
+	}()
+	logger.Infof("serving metrics on http://%s/metrics", listener.Addr())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(ctx)
+	}
+}
+
+// WriteMetrics records the outcome of the run, and writes the metrics to the
+// textfile configured with --metrics.textfile
+func WriteMetrics(conductor *ci.Conductor, success bool) {
+	logger := conductor.Logger().WithField("orchestra", "metrics")
+	conductor.Metrics().Finish(time.Since(conductor.Process.BootTime), success)
+
+	path := conductor.Config.Metrics.Textfile
+	if path == "" || conductor.Metrics() == nil {
+		return
+	}
+	if err := conductor.Metrics().WriteTextfile(path); err != nil {
+		logger.Warnf("failed to write the metrics to %s: %s", path, err)
+	} else {
+		logger.Debugf("wrote the metrics to %s", path)
+	}
+}
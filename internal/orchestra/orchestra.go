@@ -2,9 +2,14 @@ package orchestra
 
 import (
 	"context"
+	"github.com/hashicorp/hcl/v2"
 	"github.com/srevinsaju/togomak/v1/internal/blocks"
 	"github.com/srevinsaju/togomak/v1/internal/ci"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/srevinsaju/togomak/v1/internal/trace"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/zclconf/go-cty/cty"
 	"os"
@@ -32,10 +37,87 @@ func ExpandGlobalParams(conductor *ci.Conductor) {
 	conductor.Eval().Mutex().Unlock()
 }
 
+// WriteReports writes the reports of the run which were requested, diags are the
+// diagnostics of the whole run
+func WriteReports(conductor *ci.Conductor, diags hcl.Diagnostics) {
+	logger := conductor.Logger().WithField("orchestra", "report")
+	cfg := conductor.Config.Report
+	if cfg.JSON == "" && cfg.JUnit == "" && cfg.Trace == "" {
+		return
+	}
+
+	report := ci.NewReport(conductor, diags)
+	writers := []struct {
+		path  string
+		write func(string) error
+	}{
+		{cfg.JSON, report.Write},
+		{cfg.JUnit, report.WriteJUnit},
+		{cfg.Trace, conductor.Tracer().Write},
+	}
+	for _, w := range writers {
+		if w.path == "" {
+			continue
+		}
+		if err := w.write(w.path); err != nil {
+			logger.Warnf("failed to write the report to %s: %s", w.path, err)
+		} else {
+			logger.Debugf("wrote the report to %s", w.path)
+		}
+	}
+}
+
+// shutdownTimeout is how long the spans which have not been exported yet are waited for
+const shutdownTimeout = 10 * time.Second
+
+// NewTracer creates the tracer of the run, which is nil when neither --trace
+// nor OpenTelemetry are enabled
+func NewTracer(conductor *ci.Conductor) *trace.Tracer {
+	logger := conductor.Logger().WithField("orchestra", "trace")
+	cfg := conductor.Config.Report
+	var opts []trace.Option
+	if cfg.Trace != "" {
+		opts = append(opts, trace.WithEvents())
+	}
+	if cfg.OTLP {
+		exporter, err := trace.NewOTLPExporter(conductor.Context())
+		if err != nil {
+			logger.Warnf("spans will not be exported with OpenTelemetry: %s", err)
+		} else {
+			opts = append(opts, trace.WithExporter(exporter))
+		}
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	return trace.New(opts...)
+}
+
+// EndTrace ends span, the root span of the run, and exports the spans which
+// have not been exported yet. diags are the diagnostics of the whole run.
+func EndTrace(conductor *ci.Conductor, span *trace.Span, diags hcl.Diagnostics) {
+	if diags.HasErrors() {
+		span.Error(logging.Secrets().Redact(diags.Error()))
+	}
+	span.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := conductor.Tracer().Shutdown(ctx); err != nil {
+		conductor.Logger().Warnf("failed to export the spans of the run: %s", err)
+	}
+}
+
 func Perform(conductor *ci.Conductor) int {
 	ctx, cancel := context.WithCancel(conductor.Context())
 	defer cancel()
 	conductor.Update(ci.ConductorWithContext(ctx))
+	conductor.Update(ci.ConductorWithTracer(NewTracer(conductor)), ci.ConductorWithMetrics(NewMetrics(conductor)))
+	stopMetrics := ServeMetrics(conductor)
+	defer stopMetrics()
+	span := conductor.Tracer().Root(filepath.Base(filepath.Dir(conductor.Config.Paths.Pipeline))).
+		Arg("run", conductor.Process.Id.String()).
+		Arg("pipeline", conductor.Config.Paths.Pipeline)
 
 	logger := conductor.Logger().WithField("orchestra", "perform")
 	logger.Debugf("starting watchdogs and signal handlers")
@@ -44,10 +126,24 @@ func Perform(conductor *ci.Conductor) int {
 	// parse the config file
 	pipe, hclDiags := ci.Read(conductor)
 	if hclDiags.HasErrors() {
+		EndTrace(conductor, span, hclDiags)
 		logger.Fatal(conductor.DiagWriter.WriteDiagnostics(hclDiags))
 	}
 
+	var dashboard *Dashboard
+	if conductor.Config.Interface.TUI && !conductor.Config.Pipeline.DryRun && !conductor.Config.Behavior.Child.Enabled {
+		dashboard = NewDashboard(conductor)
+		if err := dashboard.Start(); err != nil {
+			logger.Warnf("cannot show the dashboard, showing the logs instead: %s", err)
+			dashboard = nil
+		}
+	}
+
 	h, d := pipe.Run(conductor)
+	dashboard.Stop()
+	EndTrace(conductor, span, d.Diagnostics())
+	WriteReports(conductor, d.Diagnostics())
+	WriteMetrics(conductor, !d.HasErrors())
 	if d.HasErrors() {
 		return h.Fatal()
 	}
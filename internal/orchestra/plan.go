@@ -0,0 +1,70 @@
+package orchestra
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/blocks"
+	"github.com/srevinsaju/togomak/v1/internal/ci"
+	"github.com/srevinsaju/togomak/v1/internal/ui"
+	"os"
+)
+
+// Plan prints which runnables of the pipeline would be run, and why, in format,
+// which is one of text or json. Stages and modules are not run.
+func Plan(cfg ci.ConductorConfig, format string) error {
+	conductor := ci.NewConductor(cfg)
+	defer conductor.Destroy()
+	logger := conductor.Logger()
+
+	pipe, depGraph, diags := readGraph(conductor)
+	if diags.HasErrors() {
+		logger.Fatal(conductor.DiagWriter.WriteDiagnostics(diags))
+	}
+	if len(diags) > 0 {
+		// the plan is written to stdout, so that it can be parsed
+		writer := hcl.NewDiagnosticTextWriter(os.Stderr, conductor.Parser.Files(), 0, true)
+		writer.WriteDiagnostics(diags)
+	}
+	plan := ci.NewPlan(conductor, pipe, depGraph)
+
+	switch format {
+	case "text", "":
+		printPlan(plan)
+	case "json":
+		content, err := plan.JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+	default:
+		return fmt.Errorf("unsupported plan format %s, use text or json", format)
+	}
+	return nil
+}
+
+func printPlan(plan *ci.Plan) {
+	fmt.Println(ui.Bold(fmt.Sprintf("%-40s %-12s %s", "runnable", "decision", "reason")))
+	runs := 0
+	for _, entry := range plan.Runnables {
+		decision := fmt.Sprintf("%-12s", entry.Decision)
+		switch {
+		case entry.Decision == ci.PlanUnknown:
+			decision = ui.Yellow(decision)
+		case entry.Run:
+			decision = ui.Green(decision)
+		default:
+			decision = ui.Grey(decision)
+		}
+		fmt.Printf("%-40s %s %s\n", entry.Id, decision, entry.Reason)
+		if !entry.Run {
+			continue
+		}
+		for _, instance := range entry.Instances {
+			fmt.Printf("  %s %s\n", ui.Grey(fmt.Sprintf("%-38s", instance)), decision)
+		}
+		if entry.Type == blocks.StageBlock || entry.Type == blocks.ModuleBlock {
+			runs++
+		}
+	}
+	fmt.Printf("\n%d stage(s) and module(s) would be run\n", runs)
+}
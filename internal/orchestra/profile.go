@@ -0,0 +1,73 @@
+package orchestra
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/srevinsaju/togomak/v1/internal/ci"
+	"github.com/srevinsaju/togomak/v1/internal/runnable"
+	"github.com/srevinsaju/togomak/v1/internal/ui"
+	"os"
+	"time"
+)
+
+// profileSlowest is the number of the slowest runnables which are shown
+const profileSlowest = 10
+
+// Profile prints where the time of a previous run was spent, and its critical path.
+// The latest run is profiled when id is empty.
+func Profile(cfg ci.ConductorConfig, id string) error {
+	conductor := ci.NewConductor(cfg)
+	defer conductor.Destroy()
+
+	state, d := ci.LoadRunState(conductor.Config.Paths.Cwd, id)
+	if d.HasErrors() {
+		dgwriter := hcl.NewDiagnosticTextWriter(os.Stdout, nil, 0, true)
+		conductor.Logger().Fatal(dgwriter.WriteDiagnostics(d))
+	}
+	profile := ci.NewProfile(state)
+
+	fmt.Printf("%s %s\n", ui.Bold("run"), state.Id)
+	if state.ResumedFrom != "" {
+		fmt.Printf("%s %s\n", ui.Grey("resumed from"), state.ResumedFrom)
+	}
+	if profile.Duration > 0 {
+		fmt.Printf("%s %s\n", ui.Grey("took"), profileDuration(profile.Duration))
+	} else {
+		fmt.Println(ui.Yellow("the run did not finish"))
+	}
+
+	fmt.Printf("\n%s\n", ui.Bold("slowest runnables"))
+	for i, entry := range profile.Runnables {
+		if i == profileSlowest {
+			break
+		}
+		printProfileEntry(entry)
+	}
+
+	var criticalPath time.Duration
+	for _, entry := range profile.CriticalPath {
+		criticalPath += entry.Duration
+	}
+	fmt.Printf("\n%s %s\n", ui.Bold("critical path"), ui.Grey(profileDuration(criticalPath)))
+	for _, entry := range profile.CriticalPath {
+		printProfileEntry(entry)
+	}
+	return nil
+}
+
+func printProfileEntry(entry ci.ProfileEntry) {
+	status := entry.Status.String()
+	switch entry.Status {
+	case runnable.StatusSuccess, runnable.StatusCached:
+		status = ui.Green(status)
+	case runnable.StatusSkipped:
+		status = ui.Grey(status)
+	default:
+		status = ui.Red(status)
+	}
+	fmt.Printf("  %10s  %-40s %s\n", profileDuration(entry.Duration), entry.Id, status)
+}
+
+func profileDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
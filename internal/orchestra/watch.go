@@ -0,0 +1,229 @@
+package orchestra
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/kendru/darwin/go/depgraph"
+	"github.com/sirupsen/logrus"
+	"github.com/srevinsaju/togomak/v1/internal/ci"
+	"github.com/srevinsaju/togomak/v1/internal/logging"
+	"github.com/srevinsaju/togomak/v1/internal/parse"
+	"github.com/srevinsaju/togomak/v1/internal/ui"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// watchDebounce is how long togomak watch waits for more files to change,
+// before running the stages again
+const watchDebounce = 200 * time.Millisecond
+
+// Watch runs the pipeline, and runs the stages again when the files they watch
+// change, along with the stages and modules which depend on them. Daemons keep
+// running across runs, unless they are run again. The whole pipeline is run
+// again when one of its files changes.
+func Watch(conductor *ci.Conductor) int {
+	ctx, cancel := context.WithCancel(conductor.Context())
+	defer cancel()
+	logger := conductor.Logger().WithField("orchestra", "watch")
+	ExpandGlobalParams(conductor)
+	conductor.Config.Pipeline.Watch = true
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Fatalf("failed to watch for changes: %s", err)
+	}
+	defer watcher.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	pipelineDir := parse.ConfigFileDir(conductor.Config.Paths)
+	if err := watcher.Add(pipelineDir); err != nil {
+		logger.Warnf("changes to the pipeline will not be watched: %s", err)
+	}
+
+	var handlers []*ci.Handler
+	stopDaemons := func(ids map[string]bool) {
+		for _, h := range handlers {
+			if d := h.Tracker.StopDaemons(conductor, ids); d.HasErrors() {
+				conductor.DiagWriter.WriteDiagnostics(d)
+			}
+		}
+	}
+
+	var rerun map[string]bool
+	for {
+		// the pipeline is parsed again, since the parser caches the files it has parsed
+		parser := ci.NewParser()
+		conductor.Update(
+			ci.ConductorWithContext(ctx),
+			ci.ConductorWithParser(parser),
+			ci.ConductorWithDiagWriter(hcl.NewDiagnosticTextWriter(logging.Secrets().Writer(os.Stdout), parser.Files(), 0, true)),
+		)
+		conductor.Config.Pipeline.Rerun = rerun
+
+		var targets []ci.WatchTarget
+		var depGraph *depgraph.Graph
+		h, succeeded := watchRun(conductor, logger)
+		// the context of the run is cancelled once it is over
+		conductor.Update(ci.ConductorWithContext(ctx))
+		if h != nil {
+			handlers = append(handlers, h)
+			targets, depGraph = watchTargets(conductor, watcher, logger)
+		}
+		logger.Info(ui.Grey("watching for changes, hit Ctrl+C to stop"))
+
+		for {
+			changed, ok := waitForChanges(watcher, signals, logger)
+			if !ok {
+				logger.Info("stopping daemons")
+				stopDaemons(nil)
+				if h == nil {
+					return 1
+				}
+				if !succeeded {
+					return h.Fatal()
+				}
+				return h.Ok()
+			}
+
+			if pipelineChanged(pipelineDir, changed) {
+				logger.Info("the pipeline has changed, running all stages")
+				stopDaemons(nil)
+				rerun = nil
+				break
+			}
+			// the graph is built again, so that the stages which are affected are found
+			// with the current pipeline rather than the one of the previous run
+			targets, depGraph = watchTargets(conductor, watcher, logger)
+			if depGraph == nil {
+				continue
+			}
+			rerun = ci.WatchAffected(targets, depGraph, changed)
+			if len(rerun) == 0 {
+				continue
+			}
+			ids := make([]string, 0, len(rerun))
+			for id := range rerun {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			logger.Infof("files have changed, running %s", strings.Join(ids, ", "))
+			stopDaemons(rerun)
+			break
+		}
+	}
+}
+
+// watchRun reads the pipeline and runs it. The handler of the run is nil when the
+// pipeline could not be read.
+func watchRun(conductor *ci.Conductor, logger logrus.Ext1FieldLogger) (*ci.Handler, bool) {
+	pipe, diags := ci.Read(conductor)
+	if diags.HasErrors() {
+		conductor.DiagWriter.WriteDiagnostics(diags)
+		return nil, false
+	}
+	h, d := pipe.Run(conductor)
+	if d.HasErrors() {
+		logger.Error(ui.Red("pipeline failed"))
+		return h, false
+	}
+	logger.Info(ui.Green("pipeline succeeded"))
+	return h, true
+}
+
+// watchTargets evaluates the globs of the stages which watch files, and watches
+// the directories they are in. The dependency graph of the pipeline is returned
+// along with the targets.
+func watchTargets(conductor *ci.Conductor, watcher *fsnotify.Watcher, logger logrus.Ext1FieldLogger) ([]ci.WatchTarget, *depgraph.Graph) {
+	pipe, depGraph, diags := readGraph(conductor)
+	if diags.HasErrors() {
+		conductor.DiagWriter.WriteDiagnostics(diags)
+		return nil, nil
+	}
+	targets, diags := pipe.WatchTargets(conductor)
+	if diags.HasErrors() {
+		conductor.DiagWriter.WriteDiagnostics(diags)
+	}
+	if len(targets) == 0 {
+		logger.Warn("no stage watches files, only changes to the pipeline are watched")
+	}
+	for _, target := range targets {
+		for _, root := range target.Roots() {
+			watchDir(watcher, root, logger)
+		}
+	}
+	return targets, depGraph
+}
+
+// watchDir watches root and the directories in it, except the hidden directories
+func watchDir(watcher *fsnotify.Watcher, root string, logger logrus.Ext1FieldLogger) {
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+	if err != nil {
+		logger.Warnf("failed to watch %s: %s", root, err)
+	}
+}
+
+// waitForChanges waits for files to change, and returns their paths once no
+// other file has changed for watchDebounce. It returns false when interrupted.
+func waitForChanges(watcher *fsnotify.Watcher, signals chan os.Signal, logger logrus.Ext1FieldLogger) ([]string, bool) {
+	var changed []string
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-signals:
+			return nil, false
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil, false
+			}
+			logger.Warnf("failed to watch for changes: %s", err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil, false
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					watchDir(watcher, event.Name, logger)
+				}
+			}
+			logger.Debugf("%s: %s", event.Op, event.Name)
+			changed = append(changed, event.Name)
+			debounce = time.After(watchDebounce)
+		case <-debounce:
+			return changed, true
+		}
+	}
+}
+
+// pipelineChanged returns true if one of the files of the pipeline, in dir, has changed
+func pipelineChanged(dir string, changed []string) bool {
+	for _, path := range changed {
+		if filepath.Dir(path) == dir && strings.HasSuffix(path, ".hcl") {
+			return true
+		}
+	}
+	return false
+}
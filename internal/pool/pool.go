@@ -0,0 +1,42 @@
+package pool
+
+// Pool limits how many goroutines can do work at the same time. Goroutines
+// call Acquire before starting the work, and Release once they are done.
+// A Pool with a size of zero or less does not impose any limit.
+type Pool struct {
+	size  int
+	slots chan struct{}
+}
+
+// New creates a Pool which allows at most size goroutines to hold a slot
+func New(size int) *Pool {
+	p := &Pool{size: size}
+	if size > 0 {
+		p.slots = make(chan struct{}, size)
+	}
+	return p
+}
+
+// Acquire blocks until a slot is available in the pool
+func (p *Pool) Acquire() {
+	if p.slots == nil {
+		return
+	}
+	p.slots <- struct{}{}
+}
+
+// Release returns a slot previously taken with Acquire back to the pool
+func (p *Pool) Release() {
+	if p.slots == nil {
+		return
+	}
+	<-p.slots
+}
+
+// Size returns the maximum number of slots, zero if the pool is unbounded
+func (p *Pool) Size() int {
+	if p.size < 0 {
+		return 0
+	}
+	return p.size
+}
@@ -0,0 +1,43 @@
+package pool
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool_Acquire(t *testing.T) {
+	p := New(2)
+	var wg sync.WaitGroup
+	var running, peak int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Acquire()
+			defer p.Release()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, peak, int32(2))
+	assert.Equal(t, 2, p.Size())
+}
+
+func TestPool_Unbounded(t *testing.T) {
+	p := New(0)
+	for i := 0; i < 100; i++ {
+		p.Acquire()
+	}
+	assert.Equal(t, 0, p.Size())
+}
@@ -16,6 +16,9 @@ type Config struct {
 	Each map[string]cty.Value
 
 	Behavior *behavior.Behavior
+
+	// Reason is recorded in the result of the runnable, see Result.Reason
+	Reason string
 }
 
 type ParentConfig struct {
@@ -66,6 +69,12 @@ func WithBehavior(behavior *behavior.Behavior) Option {
 	}
 }
 
+func WithReason(reason string) Option {
+	return func(c *Config) {
+		c.Reason = reason
+	}
+}
+
 func NewDefaultConfig() *Config {
 	return &Config{
 		Status:   &Status{Status: StatusRunning},
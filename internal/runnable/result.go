@@ -0,0 +1,43 @@
+package runnable
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"time"
+)
+
+// Result is the outcome of a runnable which has finished running
+type Result struct {
+	// Id is the identifier of the runnable, for example stage.build
+	Id string `json:"id"`
+
+	// Status is the final status of the runnable
+	Status StatusType `json:"status"`
+
+	// ExitCode is the exit code of the process started by the runnable. It is
+	// -1 if the runnable failed before its process exited
+	ExitCode int `json:"exit_code"`
+
+	// Attempts is the number of times the runnable was run, including retries
+	Attempts int `json:"attempts"`
+
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+
+	// Output is the combined stdout and stderr of the last attempt
+	Output string `json:"output"`
+
+	// Reason explains why the runnable was skipped, or why it was run
+	// although its condition evaluated to false
+	Reason string `json:"reason,omitempty"`
+
+	// Diags is the diagnostics of all the attempts
+	Diags hcl.Diagnostics `json:"-"`
+}
+
+// Duration returns how long the runnable ran for, including retries
+func (r Result) Duration() time.Duration {
+	if r.Started.IsZero() || r.Finished.IsZero() {
+		return 0
+	}
+	return r.Finished.Sub(r.Started)
+}
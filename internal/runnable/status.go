@@ -8,8 +8,10 @@ const (
 	StatusSuccess    StatusType = "success"
 	StatusFailure    StatusType = "failure"
 	StatusTerminated StatusType = "terminated"
+	StatusTimeout    StatusType = "timeout"
 	StatusRunning    StatusType = "running"
 	StatusSkipped    StatusType = "skipped"
+	StatusCached     StatusType = "cached"
 	StatusUnknown    StatusType = "unknown"
 )
 
@@ -17,6 +19,11 @@ func (s StatusType) String() string {
 	return string(s)
 }
 
+// Failed returns true if the runnable did not complete
+func (s StatusType) Failed() bool {
+	return s == StatusFailure || s == StatusTerminated || s == StatusTimeout
+}
+
 type Status struct {
 	// Diags is the diagnostics of the runnable
 	Diags hcl.Diagnostics
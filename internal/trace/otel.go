@@ -0,0 +1,123 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"os"
+	"strings"
+)
+
+// attributePrefix is prepended to the arguments of a span, to name its OpenTelemetry attributes
+const attributePrefix = meta.AppName + "."
+
+// traceContextEnvVars are the environment variables which carry the W3C trace
+// context to the processes started by a stage
+var traceContextEnvVars = []string{"TRACEPARENT", "TRACESTATE"}
+
+// OTLPConfigured returns true if an OTLP endpoint is configured with the
+// standard OTEL_EXPORTER_OTLP_* environment variables
+func OTLPConfigured() bool {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// NewOTLPExporter creates an exporter of spans to an OTLP collector, which is configured
+// with the standard OTEL_EXPORTER_OTLP_* environment variables. Spans are sent over
+// http/protobuf, unless OTEL_EXPORTER_OTLP_PROTOCOL is grpc.
+func NewOTLPExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	switch protocol {
+	case "", "http/protobuf":
+		return otlptracehttp.New(ctx)
+	case "grpc":
+		return otlptracegrpc.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %s, use grpc or http/protobuf", protocol)
+	}
+}
+
+// otelResource describes togomak to the OpenTelemetry backend, the service name
+// and other attributes can be overridden with OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES
+func otelResource() *resource.Resource {
+	r, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", meta.AppName),
+		attribute.String("service.version", meta.AppVersion),
+	))
+	if err != nil {
+		return resource.Default()
+	}
+	r, err = resource.Merge(r, resource.Environment())
+	if err != nil {
+		return resource.Default()
+	}
+	return r
+}
+
+// otelAttributes converts the arguments of a span to OpenTelemetry attributes
+func otelAttributes(args map[string]any) []attribute.KeyValue {
+	var attributes []attribute.KeyValue
+	for k, v := range args {
+		key := attributePrefix + k
+		switch v := v.(type) {
+		case string:
+			attributes = append(attributes, attribute.String(key, v))
+		case []string:
+			attributes = append(attributes, attribute.StringSlice(key, v))
+		case bool:
+			attributes = append(attributes, attribute.Bool(key, v))
+		case int:
+			attributes = append(attributes, attribute.Int(key, v))
+		case int64:
+			attributes = append(attributes, attribute.Int64(key, v))
+		case float64:
+			attributes = append(attributes, attribute.Float64(key, v))
+		default:
+			attributes = append(attributes, attribute.String(key, fmt.Sprint(v)))
+		}
+	}
+	return attributes
+}
+
+// environCarrier returns the trace context which was passed to togomak in the environment
+func environCarrier() propagation.MapCarrier {
+	carrier := propagation.MapCarrier{}
+	for _, name := range traceContextEnvVars {
+		if v := os.Getenv(name); v != "" {
+			carrier.Set(strings.ToLower(name), v)
+		}
+	}
+	return carrier
+}
+
+// Environ returns the environment variables which pass the trace context of the span
+// open in lane to a process, so that the spans of the process are its children
+func (t *Tracer) Environ(lane string) []string {
+	if t == nil || t.r.tracer == nil {
+		return nil
+	}
+	t.r.mu.Lock()
+	ctx := t.parent(t.prefix + lane)
+	t.r.mu.Unlock()
+
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	var env []string
+	for _, name := range traceContextEnvVars {
+		if v := carrier.Get(strings.ToLower(name)); v != "" {
+			env = append(env, fmt.Sprintf("%s=%s", name, v))
+		}
+	}
+	return env
+}
@@ -0,0 +1,74 @@
+package trace
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"strings"
+	"testing"
+)
+
+func TestTracer_Exporter(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := New(WithExporter(exporter))
+
+	root := tracer.Root("pipeline")
+	stage := tracer.Start("stage.build", "stage", "stage.build").Arg("status", "failure").Arg("exit_code", 2)
+	attempt := tracer.Start("stage.build", "attempt", "attempt 1").Error("exit status 2")
+	attempt.End()
+	stage.End()
+	module := tracer.Start("module.docs", "module", "module.docs")
+	tracer.Module("module.docs").Start("stage.inner", "stage", "stage.inner").End()
+	module.End()
+	root.End()
+	// the in-memory exporter forgets its spans when the tracer is shut down
+	assert.NoError(t, tracer.r.provider.ForceFlush(context.Background()))
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	assert.Len(t, spans, 5)
+	assert.False(t, spans["pipeline"].Parent.IsValid())
+	assert.Equal(t, spans["pipeline"].SpanContext.SpanID(), spans["stage.build"].Parent.SpanID())
+	assert.Equal(t, spans["stage.build"].SpanContext.SpanID(), spans["attempt 1"].Parent.SpanID())
+	assert.Equal(t, spans["module.docs"].SpanContext.SpanID(), spans["stage.inner"].Parent.SpanID())
+	assert.Equal(t, spans["pipeline"].SpanContext.TraceID(), spans["stage.inner"].SpanContext.TraceID())
+
+	assert.Equal(t, codes.Error, spans["attempt 1"].Status.Code)
+	assert.Contains(t, spans["stage.build"].Attributes, attribute.Int("togomak.exit_code", 2))
+	assert.Contains(t, spans["stage.build"].Attributes, attribute.String("togomak.status", "failure"))
+	assert.Contains(t, spans["stage.inner"].Attributes, attribute.String("togomak.lane", "module.docs/stage.inner"))
+}
+
+func TestTracer_Environ(t *testing.T) {
+	var disabled *Tracer
+	assert.Empty(t, disabled.Environ("stage.build"))
+	assert.Empty(t, New(WithEvents()).Environ("stage.build"))
+
+	exporter := tracetest.NewInMemoryExporter()
+	parent := New(WithExporter(exporter))
+	root := parent.Root("pipeline")
+	stage := parent.Start("stage.macro", "stage", "stage.macro")
+	env := parent.Environ("stage.macro")
+	assert.Len(t, env, 1)
+	assert.True(t, strings.HasPrefix(env[0], "TRACEPARENT="))
+
+	// a child process started by the stage continues the trace of the stage
+	t.Setenv("TRACEPARENT", strings.TrimPrefix(env[0], "TRACEPARENT="))
+	child := New(WithExporter(exporter))
+	child.Root("macro").End()
+	stage.End()
+	root.End()
+	assert.NoError(t, child.r.provider.ForceFlush(context.Background()))
+	assert.NoError(t, parent.r.provider.ForceFlush(context.Background()))
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	assert.Equal(t, spans["stage.macro"].SpanContext.SpanID(), spans["macro"].Parent.SpanID())
+	assert.Equal(t, spans["pipeline"].SpanContext.TraceID(), spans["macro"].SpanContext.TraceID())
+}
@@ -0,0 +1,326 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/srevinsaju/togomak/v1/internal/meta"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// pid is the process id of all the events, togomak is a single process in the trace
+	pid = 1
+
+	phaseComplete = "X"
+	phaseMetadata = "M"
+)
+
+// Event is an event in the Chrome trace event format, which can be viewed in
+// Perfetto or chrome://tracing. Timestamps and durations are in microseconds.
+type Event struct {
+	Name      string         `json:"name"`
+	Category  string         `json:"cat,omitempty"`
+	Phase     string         `json:"ph"`
+	Timestamp int64          `json:"ts"`
+	Duration  int64          `json:"dur"`
+	Pid       int            `json:"pid"`
+	Tid       int            `json:"tid"`
+	Args      map[string]any `json:"args,omitempty"`
+}
+
+// recorder has the events recorded by a Tracer and all the tracers derived from it
+type recorder struct {
+	mu      sync.Mutex
+	started time.Time
+
+	// record is true if the events are kept, so that they can be written as a trace
+	record bool
+	events []Event
+
+	// lanes are the threads of the trace, one for each runnable, so that
+	// the spans of runnables running at the same time do not overlap
+	lanes     map[string]int
+	laneOrder []string
+
+	// open has the spans of each lane which have not ended, the last one is
+	// the parent of the next span started in the lane
+	open map[string][]*Span
+
+	// provider exports the spans with OpenTelemetry, it is nil when no exporter is configured
+	provider *sdktrace.TracerProvider
+	tracer   oteltrace.Tracer
+}
+
+// Tracer records spans, which are grouped in lanes. A nil Tracer records nothing,
+// so that tracing does not need to be checked for when it is disabled.
+type Tracer struct {
+	r *recorder
+
+	// prefix is prepended to the lanes of this tracer, for example module.build/
+	prefix string
+
+	// ctx is the parent of the spans started in lanes which do not have an open span,
+	// it has the root span of the run, or the span of the module of this tracer
+	ctx context.Context
+}
+
+// Option configures what a Tracer does with the spans it records
+type Option func(r *recorder)
+
+// WithEvents keeps the events of the spans, so that they can be written with Write
+func WithEvents() Option {
+	return func(r *recorder) {
+		r.record = true
+	}
+}
+
+// WithExporter exports the spans with OpenTelemetry to exporter
+func WithExporter(exporter sdktrace.SpanExporter) Option {
+	return func(r *recorder) {
+		r.provider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(otelResource()),
+		)
+		r.tracer = r.provider.Tracer(meta.AppName, oteltrace.WithInstrumentationVersion(meta.AppVersion))
+	}
+}
+
+func New(opts ...Option) *Tracer {
+	r := &recorder{
+		started: time.Now(),
+		lanes:   make(map[string]int),
+		open:    make(map[string][]*Span),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return &Tracer{r: r, ctx: context.Background()}
+}
+
+// Module returns a tracer for the runnables of the module identified by id, which
+// records its spans in lanes prefixed with the identifier of the module. The spans
+// of the module are the children of the span which is open in the lane of the module.
+func (t *Tracer) Module(id string) *Tracer {
+	if t == nil {
+		return nil
+	}
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	return &Tracer{r: t.r, prefix: t.prefix + id + "/", ctx: t.parent(t.prefix + id)}
+}
+
+// Root starts the span of the whole run, which is the parent of the spans of all the
+// runnables. When togomak is started by a stage, for example to run a macro, the root
+// span is the child of the span of the stage, which is passed in the TRACEPARENT
+// environment variable.
+func (t *Tracer) Root(name string) *Span {
+	if t == nil {
+		return nil
+	}
+	t.r.mu.Lock()
+	t.ctx = propagation.TraceContext{}.Extract(context.Background(), environCarrier())
+	t.r.mu.Unlock()
+
+	span := t.Start(meta.AppName, "pipeline", name)
+	if span.ctx != nil {
+		t.r.mu.Lock()
+		t.ctx = span.ctx
+		t.r.mu.Unlock()
+	}
+	return span
+}
+
+// Span is an operation which is being traced
+type Span struct {
+	t        *Tracer
+	lane     string
+	tid      int
+	category string
+	name     string
+	started  time.Time
+	args     map[string]any
+
+	// ctx and span are the OpenTelemetry span, they are nil when the span is not exported
+	ctx  context.Context
+	span oteltrace.Span
+}
+
+// Start starts a span named name in lane, which is usually the identifier of
+// the runnable the span belongs to. The span is the child of the span which is
+// open in lane, if any.
+func (t *Tracer) Start(lane string, category string, name string) *Span {
+	if t == nil {
+		return nil
+	}
+	r := t.r
+	lane = t.prefix + lane
+	s := &Span{
+		t:        t,
+		lane:     lane,
+		category: category,
+		name:     name,
+		started:  time.Now(),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.tid = r.lane(lane)
+	if r.tracer != nil {
+		s.ctx, s.span = r.tracer.Start(t.parent(lane), name,
+			oteltrace.WithTimestamp(s.started),
+			oteltrace.WithAttributes(
+				attribute.String(attributePrefix+"category", category),
+				attribute.String(attributePrefix+"lane", lane),
+			),
+		)
+	}
+	r.open[lane] = append(r.open[lane], s)
+	return s
+}
+
+// lane returns the thread id of lane, r.mu must be held
+func (r *recorder) lane(lane string) int {
+	tid, ok := r.lanes[lane]
+	if !ok {
+		tid = len(r.lanes) + 1
+		r.lanes[lane] = tid
+		r.laneOrder = append(r.laneOrder, lane)
+	}
+	return tid
+}
+
+// parent returns the context of the span which is open in lane, or the
+// context of the tracer if there is none, t.r.mu must be held
+func (t *Tracer) parent(lane string) context.Context {
+	open := t.r.open[lane]
+	if len(open) > 0 && open[len(open)-1].ctx != nil {
+		return open[len(open)-1].ctx
+	}
+	return t.ctx
+}
+
+// Arg adds an argument to the span, which is shown along with the span
+func (s *Span) Arg(key string, value any) *Span {
+	if s == nil {
+		return nil
+	}
+	if s.args == nil {
+		s.args = make(map[string]any)
+	}
+	s.args[key] = value
+	return s
+}
+
+// Error marks the span as failed, with description as the reason
+func (s *Span) Error(description string) *Span {
+	if s == nil {
+		return nil
+	}
+	if s.span != nil {
+		s.span.SetStatus(codes.Error, description)
+	}
+	return s.Arg("error", description)
+}
+
+// End records the span
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	r := s.t.r
+	finished := time.Now()
+
+	r.mu.Lock()
+	open := r.open[s.lane]
+	for i := range open {
+		if open[i] == s {
+			r.open[s.lane] = append(open[:i], open[i+1:]...)
+			break
+		}
+	}
+	if r.record {
+		r.events = append(r.events, Event{
+			Name:      s.name,
+			Category:  s.category,
+			Phase:     phaseComplete,
+			Timestamp: s.started.Sub(r.started).Microseconds(),
+			Duration:  finished.Sub(s.started).Microseconds(),
+			Pid:       pid,
+			Tid:       s.tid,
+			Args:      s.args,
+		})
+	}
+	r.mu.Unlock()
+
+	if s.span != nil {
+		s.span.SetAttributes(otelAttributes(s.args)...)
+		s.span.End(oteltrace.WithTimestamp(finished))
+	}
+}
+
+// Events returns the recorded events, along with the metadata events which name the lanes
+func (t *Tracer) Events() []Event {
+	if t == nil || !t.r.record {
+		return nil
+	}
+	r := t.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := []Event{{
+		Name:  "process_name",
+		Phase: phaseMetadata,
+		Pid:   pid,
+		Args:  map[string]any{"name": meta.AppName},
+	}}
+	for _, lane := range r.laneOrder {
+		events = append(events, Event{
+			Name:  "thread_name",
+			Phase: phaseMetadata,
+			Pid:   pid,
+			Tid:   r.lanes[lane],
+			Args:  map[string]any{"name": lane},
+		}, Event{
+			Name:  "thread_sort_index",
+			Phase: phaseMetadata,
+			Pid:   pid,
+			Tid:   r.lanes[lane],
+			Args:  map[string]any{"sort_index": r.lanes[lane]},
+		})
+	}
+	return append(events, r.events...)
+}
+
+// Write writes the trace to path, in the JSON object format of Chrome trace events
+func (t *Tracer) Write(path string) error {
+	content, err := json.Marshal(struct {
+		TraceEvents     []Event `json:"traceEvents"`
+		DisplayTimeUnit string  `json:"displayTimeUnit"`
+	}{
+		TraceEvents:     t.Events(),
+		DisplayTimeUnit: "ms",
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+// Shutdown exports the spans which have not been exported yet, and stops the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil || t.r.provider == nil {
+		return nil
+	}
+	return t.r.provider.Shutdown(ctx)
+}
@@ -0,0 +1,59 @@
+package trace
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTracer_Nil(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.Module("module.build").Start("stage.build", "runnable", "stage.build")
+	span.Arg("status", "success").End()
+	assert.Nil(t, span)
+	assert.Empty(t, tracer.Events())
+}
+
+func TestTracer(t *testing.T) {
+	tracer := New(WithEvents())
+	tracer.Start("stage.build", "runnable", "stage.build").Arg("attempts", 1).End()
+	tracer.Start("stage.test", "runnable", "stage.test").End()
+	tracer.Module("module.docs").Start("stage.build", "runnable", "stage.build").End()
+	tracer.Start("stage.build", "hook", "post hooks").End()
+
+	lanes := make(map[int]string)
+	var spans []Event
+	for _, event := range tracer.Events() {
+		switch event.Name {
+		case "thread_name":
+			lanes[event.Tid] = event.Args["name"].(string)
+		case "process_name", "thread_sort_index":
+		default:
+			spans = append(spans, event)
+		}
+	}
+	assert.Equal(t, map[int]string{1: "stage.build", 2: "stage.test", 3: "module.docs/stage.build"}, lanes)
+	assert.Len(t, spans, 4)
+	assert.Equal(t, phaseComplete, spans[0].Phase)
+	assert.Equal(t, 1, spans[0].Args["attempts"])
+	assert.Equal(t, 3, spans[2].Tid)
+	assert.Equal(t, spans[0].Tid, spans[3].Tid)
+}
+
+func TestTracer_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out", "trace.json")
+	tracer := New(WithEvents())
+	tracer.Start("stage.build", "runnable", "stage.build").End()
+	assert.NoError(t, tracer.Write(path))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var trace struct {
+		TraceEvents []map[string]any `json:"traceEvents"`
+	}
+	assert.NoError(t, json.Unmarshal(content, &trace))
+	assert.Len(t, trace.TraceEvents, 4)
+	assert.Contains(t, trace.TraceEvents[3], "dur")
+}
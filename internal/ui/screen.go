@@ -0,0 +1,235 @@
+package ui
+
+import (
+	"fmt"
+	"github.com/acarl005/stripansi"
+	"golang.org/x/term"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Key is a key pressed on a Screen, printable keys are the key itself
+type Key string
+
+const (
+	KeyUp        Key = "up"
+	KeyDown      Key = "down"
+	KeyPageUp    Key = "pgup"
+	KeyPageDown  Key = "pgdown"
+	KeyEnter     Key = "enter"
+	KeyEscape    Key = "esc"
+	KeyInterrupt Key = "ctrl+c"
+)
+
+// escapeKeys are the escape sequences of the keys which are not printable
+var escapeKeys = map[string]Key{
+	"\x1b[A":  KeyUp,
+	"\x1bOA":  KeyUp,
+	"\x1b[B":  KeyDown,
+	"\x1bOB":  KeyDown,
+	"\x1b[5~": KeyPageUp,
+	"\x1b[6~": KeyPageDown,
+}
+
+// Screen draws a full screen interface on the alternate screen of a terminal,
+// and reads the keys which are pressed
+type Screen struct {
+	in  *os.File
+	out *os.File
+
+	mu    sync.Mutex
+	state *term.State
+}
+
+func NewScreen(in *os.File, out *os.File) *Screen {
+	return &Screen{in: in, out: out}
+}
+
+// IsTerminal returns true if the screen can be drawn, which requires both its
+// input and its output to be a terminal
+func (s *Screen) IsTerminal() bool {
+	return term.IsTerminal(int(s.in.Fd())) && term.IsTerminal(int(s.out.Fd()))
+}
+
+// Enter switches the terminal to the alternate screen, and reads the keys
+// without waiting for a new line
+func (s *Screen) Enter() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := term.MakeRaw(int(s.in.Fd()))
+	if err != nil {
+		return err
+	}
+	s.state = state
+	fmt.Fprint(s.out, "\x1b[?1049h\x1b[?25l")
+	return nil
+}
+
+// Exit restores the terminal, it does nothing if the screen was not entered
+func (s *Screen) Exit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return
+	}
+	fmt.Fprint(s.out, "\x1b[?25h\x1b[?1049l")
+	_ = term.Restore(int(s.in.Fd()), s.state)
+	s.state = nil
+}
+
+// Size returns the width and the height of the terminal
+func (s *Screen) Size() (int, int) {
+	width, height, err := term.GetSize(int(s.out.Fd()))
+	if err != nil {
+		return 80, 24
+	}
+	return width, height
+}
+
+// Draw replaces the content of the screen with lines, which are truncated to
+// the width of the screen
+func (s *Screen) Draw(lines []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return
+	}
+	width, height := s.Size()
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i >= height {
+			break
+		}
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(Truncate(line, width))
+		b.WriteString("\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	fmt.Fprint(s.out, b.String())
+}
+
+// Keys returns the keys pressed on the screen. The channel is closed when the
+// input of the screen is closed.
+func (s *Screen) Keys() <-chan Key {
+	keys := make(chan Key)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 32)
+		for {
+			n, err := s.in.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, key := range parseKeys(buf[:n]) {
+				keys <- key
+			}
+		}
+	}()
+	return keys
+}
+
+// parseKeys returns the keys in input, which was read from a terminal in raw mode
+func parseKeys(input []byte) []Key {
+	var keys []Key
+	for len(input) > 0 {
+		if input[0] == 0x1b {
+			matched := false
+			for seq, key := range escapeKeys {
+				if strings.HasPrefix(string(input), seq) {
+					keys = append(keys, key)
+					input = input[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if !matched && len(input) > 1 && input[1] == '[' {
+				// other control sequences, such as the function keys, are ignored
+				end := 2
+				for end < len(input) && (input[end] < 0x40 || input[end] > 0x7e) {
+					end++
+				}
+				if end < len(input) {
+					end++
+				}
+				input = input[end:]
+			} else if !matched {
+				keys = append(keys, KeyEscape)
+				input = input[1:]
+			}
+			continue
+		}
+		r, size := utf8.DecodeRune(input)
+		input = input[size:]
+		switch r {
+		case 0x03:
+			keys = append(keys, KeyInterrupt)
+		case '\r', '\n':
+			keys = append(keys, KeyEnter)
+		default:
+			if r >= 0x20 && r != 0x7f {
+				keys = append(keys, Key(string(r)))
+			}
+		}
+	}
+	return keys
+}
+
+// Truncate returns s, cut to width visible characters. The colors of s are kept,
+// its other escape sequences and control characters are removed, and tabs are
+// replaced with spaces.
+func Truncate(s string, width int) string {
+	var b strings.Builder
+	visible := 0
+	colored := false
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c == 0x1b && i+1 < len(s) && s[i+1] == '[' {
+			// a control sequence ends with a byte between @ and ~
+			j := i + 2
+			for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+				j++
+			}
+			if j < len(s) && s[j] == 'm' {
+				b.WriteString(s[i : j+1])
+				colored = true
+			}
+			i = j + 1
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r == '\t' {
+			r = ' '
+		}
+		if r < 0x20 || r == 0x7f {
+			continue
+		}
+		if visible >= width {
+			break
+		}
+		b.WriteRune(r)
+		visible++
+	}
+	if colored {
+		b.WriteString("\x1b[0m")
+	}
+	return b.String()
+}
+
+// Width returns the number of visible characters of s
+func Width(s string) int {
+	return utf8.RuneCountInString(stripansi.Strip(s))
+}
+
+// Pad pads s with spaces, up to width visible characters
+func Pad(s string, width int) string {
+	if w := Width(s); w < width {
+		return s + strings.Repeat(" ", width-w)
+	}
+	return s
+}
@@ -0,0 +1,57 @@
+package ui
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		keys  []Key
+	}{
+		{"printable", "jk", []Key{"j", "k"}},
+		{"unicode", "é", []Key{"é"}},
+		{"arrows", "\x1b[A\x1b[B\x1bOA\x1bOB", []Key{KeyUp, KeyDown, KeyUp, KeyDown}},
+		{"pages", "\x1b[5~\x1b[6~", []Key{KeyPageUp, KeyPageDown}},
+		{"enter", "\r\n", []Key{KeyEnter, KeyEnter}},
+		{"interrupt", "\x03", []Key{KeyInterrupt}},
+		{"escape", "\x1b", []Key{KeyEscape}},
+		{"escape before a key", "\x1bq", []Key{KeyEscape, "q"}},
+		{"unknown control sequence", "\x1b[15~j", []Key{"j"}},
+		{"unfinished control sequence", "\x1b[1", nil},
+		{"control characters", "\x00\x7fj", []Key{"j"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.keys, parseKeys([]byte(tt.input)))
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		width int
+		want  string
+	}{
+		{"short", "build", 10, "build"},
+		{"cut", "building", 5, "build"},
+		{"empty", "build", 0, ""},
+		{"unicode", "✔ built", 3, "✔ b"},
+		{"tabs", "a\tb", 3, "a b"},
+		{"control characters", "a\rb\x07c", 10, "abc"},
+		{"colors are kept", "\x1b[32mbuilt\x1b[0m", 3, "\x1b[32mbui\x1b[0m"},
+		{"colors are reset", "\x1b[32mbuilt", 10, "\x1b[32mbuilt\x1b[0m"},
+		{"other sequences are removed", "\x1b[2Kbuilt\x1b[1A", 10, "built"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Truncate(tt.s, tt.width)
+			assert.Equal(t, tt.want, got)
+			assert.LessOrEqual(t, Width(got), tt.width)
+		})
+	}
+}